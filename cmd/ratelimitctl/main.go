@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/admin"
+	"github.com/allis/rate-limiter/internal/config"
+	"github.com/allis/rate-limiter/internal/storage"
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage: ratelimitctl [flags] <command> [arguments]
+
+Operates the rate limiter through the storage configured by the environment
+(REDIS_ADDR, .env...) or, with -admin, the admin API of a running server.
+
+Commands:
+  keys [PREFIX]                        list the tracked storage keys
+  blocked [PREFIX]                     list the blocked storage keys
+  show ip|token ID                     show the counters and blocks
+  unblock ip|token ID                  lift the blocks, keeping the counters
+  reset ip|token ID                    remove the counters and blocks
+  block ip|token ID DURATION REASON    block manually, e.g. block ip 10.0.0.1 2h "abuse"
+  validate [FILE]                      check a policy file, RATE_LIMIT_POLICY_FILE by default
+  simulate [-method M] [-path P] [-ip IP] [-api-key KEY]
+                                       show the rule a request would hit
+
+Flags:
+`
+
+// errUsage reports invalid command line arguments
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	// The output already shows the result of each change
+	log.SetOutput(io.Discard)
+	// Like the server, read RATELIMITCTL_ADMIN_URL, ADMIN_TOKEN... from .env
+	_ = godotenv.Load()
+
+	err := run(os.Args[1:], os.Stdout)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(os.Stderr, "ratelimitctl: %v\nRun 'ratelimitctl -h' for usage.\n", err)
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "ratelimitctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command line args, writing the output to stdout
+func run(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("ratelimitctl", flag.ContinueOnError)
+	adminURL := flags.String("admin", "", "admin API URL, e.g. http://127.0.0.1:9090 (default $RATELIMITCTL_ADMIN_URL)")
+	adminToken := flags.String("token", "", "admin API token (default $ADMIN_TOKEN)")
+	route := flags.String("route", "", "name of the route whose limits show, unblock, reset and block apply to")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *adminURL == "" {
+		*adminURL = os.Getenv("RATELIMITCTL_ADMIN_URL")
+	}
+	if *adminToken == "" {
+		*adminToken = os.Getenv("ADMIN_TOKEN")
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("%w: missing command", errUsage)
+	}
+
+	command, args := flags.Arg(0), flags.Args()[1:]
+	switch command {
+	case "validate":
+		return validate(args, stdout)
+	case "simulate":
+		return simulate(args, stdout)
+	}
+
+	ctx := context.Background()
+	var service admin.Service
+	if *adminURL != "" {
+		service = admin.NewClient(*adminURL, *adminToken)
+	} else {
+		store, cfg, err := openStorage()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		service = admin.NewStorageService(store, admin.WithLimits(cfg.Limits))
+	}
+
+	switch command {
+	case "keys":
+		keys, err := service.Keys(ctx, optionalArg(args))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			fmt.Fprintln(stdout, key)
+		}
+		return nil
+	case "blocked":
+		blocked, err := service.Blocked(ctx, optionalArg(args))
+		if err != nil {
+			return err
+		}
+		return writeWindows(stdout, blocked)
+	case "show", "unblock", "reset":
+		ref, err := parseRef(args, 2, *route)
+		if err != nil {
+			return err
+		}
+		operation := map[string]func(context.Context, admin.Ref) (admin.Identity, error){
+			"show":    service.Inspect,
+			"unblock": service.Unblock,
+			"reset":   service.Reset,
+		}[command]
+		identity, err := operation(ctx, ref)
+		if err != nil {
+			return err
+		}
+		return writeIdentity(stdout, identity)
+	case "block":
+		ref, err := parseRef(args, 4, *route)
+		if err != nil {
+			return err
+		}
+		duration, err := time.ParseDuration(args[2])
+		if err != nil {
+			return fmt.Errorf("%w: invalid duration %q", errUsage, args[2])
+		}
+		identity, err := service.Block(ctx, ref, duration, args[3])
+		if err != nil {
+			return err
+		}
+		return writeIdentity(stdout, identity)
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, command)
+	}
+}
+
+// openStorage connects to the Redis storage configured by the environment,
+// returning it with the configuration its limits are read from. The memory
+// storage lives in the server process, so it is reachable only through the
+// admin API.
+func openStorage() (storage.Storage, *config.Config, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if cfg.Storage.Backend == config.StorageBackendMemory {
+		return nil, nil, errors.New("the memory storage is local to the server, use -admin to reach it through the admin API")
+	}
+	store, err := storage.NewRedisStorage(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, cfg, nil
+}
+
+// validate checks a policy file
+func validate(args []string, stdout io.Writer) error {
+	path := optionalArg(args)
+	if path == "" {
+		path = os.Getenv("RATE_LIMIT_POLICY_FILE")
+	}
+	if path == "" {
+		return fmt.Errorf("%w: missing policy file", errUsage)
+	}
+
+	if err := config.CheckPolicyFile(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: OK\n", path)
+	return nil
+}
+
+// optionalArg returns the only argument of a command, empty if none
+func optionalArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+// parseRef parses the "ip|token ID" arguments of a command taking n
+// arguments
+func parseRef(args []string, n int, route string) (admin.Ref, error) {
+	if len(args) != n {
+		return admin.Ref{}, fmt.Errorf("%w: expected %d arguments, got %d", errUsage, n, len(args))
+	}
+	ref := admin.Ref{Kind: args[0], ID: args[1], Route: route}
+	if _, err := ref.Key(); err != nil {
+		return admin.Ref{}, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	return ref, nil
+}
+
+// writeIdentity writes the windows of an identity as a table
+func writeIdentity(w io.Writer, identity admin.Identity) error {
+	name := identity.Kind + " " + identity.ID
+	if identity.Route != "" {
+		name += " on route " + identity.Route
+	}
+	status := "not blocked"
+	if identity.Blocked {
+		status = fmt.Sprintf("blocked for %ds", identity.BlockTTL)
+	}
+	fmt.Fprintf(w, "%s: %s\n\n", name, status)
+	return writeWindows(w, identity.Windows)
+}
+
+// writeWindows writes windows as a table
+func writeWindows(w io.Writer, windows []admin.Window) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tCOUNT\tBLOCKED\tTTL\tREASON")
+	for _, window := range windows {
+		blocked, ttl := "no", "-"
+		if window.Blocked {
+			blocked, ttl = "yes", fmt.Sprintf("%ds", window.BlockTTL)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", window.Key, window.Count, blocked, ttl, strings.ReplaceAll(window.Reason, "\t", " "))
+	}
+	return tw.Flush()
+}
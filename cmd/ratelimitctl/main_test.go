@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/allis/rate-limiter/internal/admin"
+	"github.com/allis/rate-limiter/internal/storage"
+)
+
+func TestRun_Admin(t *testing.T) {
+	server := httptest.NewServer(admin.NewHandler(admin.NewStorageService(storage.NewMemoryStorage(0)), "s3cret"))
+	defer server.Close()
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+
+	ctl := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := run(append([]string{"-admin", server.URL}, args...), &out); err != nil {
+			t.Fatalf("%v failed: %v", args, err)
+		}
+		return out.String()
+	}
+
+	out := ctl("block", "ip", "10.0.0.1", "2h", "abuse report")
+	if !strings.Contains(out, "ip 10.0.0.1: blocked for 7200s") || !strings.Contains(out, "abuse report") {
+		t.Errorf("Expected the block in the output, got:\n%s", out)
+	}
+	if out := ctl("blocked", "ip:"); !strings.Contains(out, "ip:10.0.0.1") {
+		t.Errorf("Expected the blocked IP, got:\n%s", out)
+	}
+	if out := ctl("unblock", "ip", "10.0.0.1"); !strings.Contains(out, "not blocked") {
+		t.Errorf("Expected the IP to be unblocked, got:\n%s", out)
+	}
+	if out := ctl("-route", "login", "show", "token", "abc123"); !strings.Contains(out, "route:login:token:abc123") {
+		t.Errorf("Expected the route key, got:\n%s", out)
+	}
+
+	for _, args := range [][]string{{}, {"show", "user", "alice"}, {"block", "ip", "10.0.0.1", "forever", "x"}, {"frobnicate"}} {
+		if err := run(append([]string{"-admin", server.URL}, args...), &bytes.Buffer{}); !errors.Is(err, errUsage) {
+			t.Errorf("%v: expected a usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestRun_Validate(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	invalid := filepath.Join(dir, "invalid.yaml")
+	os.WriteFile(valid, []byte("ip:\n  rate: 10/s\n"), 0o600)
+	os.WriteFile(invalid, []byte("ip:\n  rate: 10/week\n"), 0o600)
+
+	var out bytes.Buffer
+	if err := run([]string{"validate", valid}, &out); err != nil || !strings.Contains(out.String(), "OK") {
+		t.Errorf("Expected the valid policy to pass, got %q (%v)", out.String(), err)
+	}
+	if err := run([]string{"validate", invalid}, &out); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error at line 2, got %v", err)
+	}
+}
+
+func TestRun_Simulate(t *testing.T) {
+	policy := filepath.Join(t.TempDir(), "policy.yaml")
+	os.WriteFile(policy, []byte(`tokens:
+  abc123:
+    rate: 100/m
+    windows:
+      - rate: 1000/d
+routes:
+  - name: login
+    pattern: POST /login
+    rate: 5/m
+    block: 5m
+bypass: [GET /health]
+costs:
+  - path: /export
+    cost: 50
+`), 0o600)
+	t.Setenv("RATE_LIMIT_POLICY_FILE", policy)
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-method", "POST", "-path", "/login", "-ip", "10.0.0.1"},
+			[]string{"Route:     POST /login (login)", "Key:       route:login:ip:10.0.0.1", "5 req/1m0s, blocks for 5m0s"}},
+		{[]string{"-path", "/export", "-api-key", "abc123"},
+			[]string{"Route:     none", "Rule:      token", "Window:    1000 req/24h0m0s", "Cost:      50"}},
+		{[]string{"-path", "/health"},
+			[]string{"GET /health (bypass"}},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := run(append([]string{"simulate"}, tt.args...), &out); err != nil {
+			t.Fatalf("simulate %v failed: %v", tt.args, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("simulate %v: expected %q in:\n%s", tt.args, want, out.String())
+			}
+		}
+	}
+}
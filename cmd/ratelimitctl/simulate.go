@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/config"
+	"github.com/allis/rate-limiter/internal/limiter"
+	"github.com/allis/rate-limiter/internal/middleware"
+)
+
+// simulate shows the route, rule, storage key and limits a request would
+// hit with the configuration of the environment, without counting it
+func simulate(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	method := flags.String("method", http.MethodGet, "request method")
+	path := flags.String("path", "/", "request path")
+	host := flags.String("host", "localhost", "request host")
+	ip := flags.String("ip", "127.0.0.1", "client IP, as resolved by the server from trusted proxies")
+	apiKey := flags.String("api-key", "", "API_KEY header of the request")
+	policyFile := flags.String("policy", "", "policy file to use instead of RATE_LIMIT_POLICY_FILE")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("%w: unexpected argument %q", errUsage, flags.Arg(0))
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if *policyFile != "" {
+		cfg.Policy.File = *policyFile
+		if cfg, err = cfg.ReloadLimits(); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(*method, "http://"+*host+*path, nil)
+	if err != nil {
+		return fmt.Errorf("%w: invalid request: %v", errUsage, err)
+	}
+
+	// The rate limiters only resolve rules, they never reach a storage
+	var routes []middleware.Route
+	names := make(map[string]string)
+	for _, route := range cfg.RateLimiter.Routes {
+		routes = append(routes, middleware.Route{
+			Pattern: route.Pattern,
+			Limiter: limiter.NewRateLimiter(nil, route.LimiterConfig()),
+		})
+		names[route.Pattern] = route.Name
+	}
+	table, err := middleware.NewRouteTable(routes, cfg.Middleware.Bypass)
+	if err != nil {
+		return err
+	}
+	match := table.Lookup(req)
+
+	w := &lineWriter{w: stdout}
+	request := fmt.Sprintf("%s %s from %s", *method, *path, *ip)
+	if *apiKey != "" {
+		request += " with API key " + *apiKey
+	}
+	w.line("Request", request)
+
+	if match.Bypass {
+		w.line("Route", match.Pattern+" (bypass, never rate limited)")
+		return w.err
+	}
+
+	limiterConfig := cfg.LimiterConfig()
+	if match.Limiter != nil {
+		limiterConfig = match.Limiter.Config()
+		w.line("Route", fmt.Sprintf("%s (%s)", match.Pattern, names[match.Pattern]))
+	} else {
+		w.line("Route", "none, global limits")
+	}
+
+	rule := limiterConfig.IPRule(*ip)
+	if *apiKey != "" {
+		rule = limiterConfig.TokenRule(*apiKey)
+	}
+	w.line("Rule", rule.Name)
+	w.line("Key", rule.Key)
+	w.line("Limit", formatLimit(rule.Limit.Limit, rule.Limit.Period, rule.Limit.BlockDuration))
+	algorithm := string(rule.Limit.Algorithm)
+	if algorithm == "" {
+		algorithm = string(limiter.FixedWindow)
+	}
+	if rule.Limit.Burst > 0 {
+		algorithm += fmt.Sprintf(", burst %d", rule.Limit.Burst)
+	}
+	w.line("Algorithm", algorithm)
+	for _, window := range rule.Limit.Windows {
+		w.line("Window", formatLimit(window.Limit, window.Period, window.BlockDuration))
+	}
+	w.line("Cost", fmt.Sprint(middleware.RouteCosts(cfg.Middleware.RouteCosts)(req)))
+	return w.err
+}
+
+// formatLimit formats a limit per period, one second if zero, with its block
+func formatLimit(limit int, period, block time.Duration) string {
+	if period <= 0 {
+		period = time.Second
+	}
+	s := fmt.Sprintf("%d req/%v", limit, period)
+	if block > 0 {
+		return s + fmt.Sprintf(", blocks for %v", block)
+	}
+	return s + ", no block"
+}
+
+// lineWriter writes aligned "Label: value" lines, keeping the first error
+type lineWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (l *lineWriter) line(label, value string) {
+	if l.err == nil {
+		_, l.err = fmt.Fprintf(l.w, "%-10s %s\n", label+":", strings.TrimSpace(value))
+	}
+}
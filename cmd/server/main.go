@@ -1,53 +1,112 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"html/template"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/allis/rate-limiter/internal/admin"
 	"github.com/allis/rate-limiter/internal/config"
-	"github.com/allis/rate-limiter/internal/limiter"
-	"github.com/allis/rate-limiter/internal/middleware"
-	"github.com/allis/rate-limiter/internal/storage"
+	"github.com/allis/rate-limiter/internal/logging"
+	"github.com/allis/rate-limiter/internal/metrics"
+	"github.com/allis/rate-limiter/internal/proxy"
+	"github.com/allis/rate-limiter/internal/rls"
+	"github.com/allis/rate-limiter/pkg/ratelimit"
+	"google.golang.org/grpc"
 )
 
 func main() {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to load configuration", err)
 	}
 
-	// Initialize Redis storage
-	redisStorage, err := storage.NewRedisStorage(
-		cfg.Redis.Addr,
-		cfg.Redis.Password,
-		cfg.Redis.DB,
-	)
+	// The limiter and the middleware log to the default logger, and so does
+	// the log package
+	slog.SetDefault(newLogger(cfg.Log))
+
+	// Trace the rate limit checks and their storage calls
+	tracerProvider, err := newTracerProvider(context.Background(), cfg.Tracing)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		fatal("Failed to set up tracing", err)
+	}
+	var redisOptions []ratelimit.RedisOption
+	if tracerProvider != nil {
+		redisOptions = append(redisOptions, ratelimit.WithRedisTracing(tracerProvider))
+		go flushTracesOnExit(tracerProvider)
 	}
-	defer redisStorage.Close()
 
-	log.Println("Connected to Redis successfully")
+	// Initialize storage
+	var store ratelimit.Storage
+	switch cfg.Storage.Backend {
+	case config.StorageBackendMemory:
+		store = ratelimit.NewMemoryStorage()
+		slog.Info("Using in-memory storage")
+	default:
+		redisStorage, err := ratelimit.NewRedisStorage(cfg.Redis.Addr, append(redisOptions,
+			ratelimit.WithRedisPassword(cfg.Redis.Password),
+			ratelimit.WithRedisDB(cfg.Redis.DB),
+		)...)
+		if err != nil {
+			fatal("Failed to connect to Redis", err)
+		}
+		store = redisStorage
+		slog.Info("Connected to Redis successfully", "addr", cfg.Redis.Addr)
 
-	// Create rate limiter
-	rateLimiter := limiter.NewRateLimiter(redisStorage, limiter.Config{
-		IPLimit:                   cfg.RateLimiter.IPLimit,
-		IPBlockDuration:           cfg.RateLimiter.IPBlockDuration,
-		TokenLimits:               cfg.RateLimiter.TokenLimits,
-		DefaultTokenLimit:         cfg.RateLimiter.DefaultTokenLimit,
-		DefaultTokenBlockDuration: cfg.RateLimiter.DefaultTokenBlockDuration,
-	})
+		// Stop calling Redis while it is failing
+		if cfg.Storage.BreakerThreshold > 0 {
+			breaker := ratelimit.NewCircuitBreaker(cfg.Storage.BreakerThreshold, cfg.Storage.BreakerTimeout)
+			breaker.OnStateChange = func(from, to ratelimit.BreakerState) {
+				slog.Warn("Storage circuit breaker state changed", "from", from.String(), "to", to.String())
+			}
+			store = ratelimit.NewCircuitBreakerStorage(store, breaker)
+		}
+
+		// Limit requests locally until Redis recovers
+		if cfg.Storage.FailureMode == config.FailureModeFallback {
+			store = ratelimit.NewFailoverStorage(store, ratelimit.NewMemoryStorage())
+		}
+	}
+	defer store.Close()
+
+	// Record the storage call latency
+	var stats *metrics.Metrics
+	if cfg.Metrics.Enabled {
+		stats = metrics.New()
+		store = ratelimit.NewInstrumentedStorage(store, stats.ObserveStorage)
+	}
+
+	// Create rate limiter, with the limits reloaded on SIGHUP and policy
+	// file changes
+	limits, err := newReloader(cfg, store)
+	if err != nil {
+		fatal("Failed to create rate limiter", err)
+	}
+	limits.watch(context.Background())
 
 	// Create HTTP server with rate limiter middleware
 	mux := http.NewServeMux()
 
-	// Add a simple handler for testing
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Request successful!"))
-	})
+	if len(cfg.Proxy.Upstreams) > 0 {
+		// Forward the allowed requests to the upstreams
+		upstreams, err := proxy.New(cfg.Proxy.Upstreams, proxy.WithTrustedProxies(cfg.Middleware.TrustedProxies))
+		if err != nil {
+			fatal("Failed to create reverse proxy", err)
+		}
+		mux.Handle("/", upstreams)
+	} else {
+		// Add a simple handler for testing
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Request successful!"))
+		})
+	}
 
 	// Add health check endpoint
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -55,20 +114,179 @@ func main() {
 		w.Write([]byte("OK"))
 	})
 
+	// Build the response writer for rejected requests
+	var htmlTemplate *template.Template
+	if cfg.Middleware.HTMLTemplateFile != "" {
+		htmlTemplate, err = template.ParseFiles(cfg.Middleware.HTMLTemplateFile)
+		if err != nil {
+			fatal("Failed to parse HTML response template", err)
+		}
+	}
+	responder, err := ratelimit.NewResponder(cfg.Middleware.ResponseFormat, htmlTemplate, cfg.Middleware.ProblemType)
+	if err != nil {
+		fatal("Failed to create responder", err)
+	}
+
 	// Apply rate limiter middleware
-	handler := middleware.RateLimiterMiddleware(rateLimiter)(mux)
+	middlewareOptions := []ratelimit.MiddlewareOption{
+		ratelimit.WithResponder(responder),
+		ratelimit.WithTrustedProxies(cfg.Middleware.TrustedProxies),
+		ratelimit.WithIPHeaders(cfg.Middleware.IPHeaders),
+		ratelimit.WithTimeout(cfg.Storage.Timeout),
+	}
+	if cfg.Middleware.LegacyHeaders {
+		middlewareOptions = append(middlewareOptions, ratelimit.WithLegacyHeaders())
+	}
+	if cfg.Storage.FailureMode == config.FailureModeOpen {
+		middlewareOptions = append(middlewareOptions, ratelimit.WithFailOpen())
+	}
+	if cfg.Middleware.CostHeader != "" {
+		middlewareOptions = append(middlewareOptions, ratelimit.WithCostHeader(cfg.Middleware.CostHeader))
+	}
+	if tracerProvider != nil {
+		middlewareOptions = append(middlewareOptions, ratelimit.WithTracerProvider(tracerProvider))
+	}
+	if stats != nil {
+		middlewareOptions = append(middlewareOptions, ratelimit.WithObserver(stats.Observer(cfg.Storage.FailureMode == config.FailureModeOpen)))
+	}
+	middlewareOptions = append(middlewareOptions,
+		ratelimit.WithCost(limits.cost),
+		ratelimit.WithRouteTable(limits.routes),
+	)
+	var handler http.Handler = ratelimit.Middleware(limits.rateLimiter, middlewareOptions...)(mux)
+
+	if tracerProvider != nil {
+		handler = withTraceContext(handler)
+	}
 
 	// Start server
 	addr := fmt.Sprintf(":%s", cfg.Server.Port)
-	log.Printf("Starting server on %s", addr)
-	log.Printf("Rate Limiter Config:")
-	log.Printf("  - IP Limit: %d req/s", cfg.RateLimiter.IPLimit)
-	log.Printf("  - IP Block Duration: %v", cfg.RateLimiter.IPBlockDuration)
-	log.Printf("  - Default Token Limit: %d req/s", cfg.RateLimiter.DefaultTokenLimit)
-	log.Printf("  - Default Token Block Duration: %v", cfg.RateLimiter.DefaultTokenBlockDuration)
-	log.Printf("  - Custom Token Limits: %d configured", len(cfg.RateLimiter.TokenLimits))
+	slog.Info("Starting server", "addr", addr)
+	// A single record, as records with the same message are sampled
+	var routeLimits []string
+	for _, route := range cfg.RateLimiter.Routes {
+		routeLimits = append(routeLimits, fmt.Sprintf("%s %s", route.Pattern, formatRate(route.Limit.Limit, route.Limit.Period)))
+	}
+	slog.Info("Rate limiter configuration",
+		"policy_file", cfg.Policy.File,
+		"ip_limit", formatRate(cfg.RateLimiter.IPLimit, cfg.RateLimiter.IPPeriod),
+		"ip_block_duration", cfg.RateLimiter.IPBlockDuration.String(),
+		"ip_algorithm", cfg.RateLimiter.IPAlgorithm,
+		"default_token_limit", formatRate(cfg.RateLimiter.DefaultTokenLimit, cfg.RateLimiter.DefaultTokenPeriod),
+		"default_token_block_duration", cfg.RateLimiter.DefaultTokenBlockDuration.String(),
+		"default_token_algorithm", cfg.RateLimiter.DefaultTokenAlgorithm,
+		"token_limits", len(cfg.RateLimiter.TokenLimits),
+		"response_format", cfg.Middleware.ResponseFormat,
+		"trusted_proxies", cfg.Middleware.TrustedProxies,
+		"routes", routeLimits,
+		"route_costs", len(cfg.Middleware.RouteCosts),
+		"bypass", cfg.Middleware.Bypass,
+		"storage_failure_mode", cfg.Storage.FailureMode,
+		"storage_timeout", cfg.Storage.Timeout.String(),
+		"metrics", stats != nil,
+		"log_level", cfg.Log.Level,
+		"traces_exporter", cfg.Tracing.Exporter,
+		"upstreams", upstreamList(cfg.Proxy.Upstreams),
+		"rls_addr", cfg.RLS.Addr,
+	)
+
+	// Start the admin API on its own listener
+	if cfg.Admin.Addr != "" {
+		slog.Info("Starting admin API", "addr", cfg.Admin.Addr)
+		go func() {
+			// Audit records are never sampled
+			service := admin.NewStorageService(store,
+				admin.WithLimits(limits.limits),
+				admin.WithLogger(slog.New(newHandler(cfg.Log))))
+			if err := http.ListenAndServe(cfg.Admin.Addr, admin.NewHandler(service, cfg.Admin.Token)); err != nil {
+				fatal("Admin API failed", err)
+			}
+		}()
+	}
+
+	// Serve the metrics on their own listener, outside rate limiting, and
+	// count the blocked keys in the background rather than on every scrape
+	if stats != nil {
+		if cfg.Metrics.BlockedInterval > 0 {
+			go stats.WatchBlocked(context.Background(), store, cfg.Metrics.BlockedInterval, slog.Default())
+		}
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle(cfg.Metrics.Path, stats.Handler())
+		slog.Info("Starting metrics endpoint", "addr", cfg.Metrics.Addr, "path", cfg.Metrics.Path)
+		go func() {
+			if err := http.ListenAndServe(cfg.Metrics.Addr, metricsMux); err != nil {
+				fatal("Metrics endpoint failed", err)
+			}
+		}()
+	}
+
+	// Start the Envoy rate limit service, applying the same limits and
+	// routes as the middleware
+	if cfg.RLS.Addr != "" {
+		listener, err := net.Listen("tcp", cfg.RLS.Addr)
+		if err != nil {
+			fatal("Failed to listen for the Envoy rate limit service", err)
+		}
+		rlsOptions := []rls.Option{
+			rls.WithDomain(cfg.RLS.Domain),
+			rls.WithTokenKey(cfg.RLS.TokenKey),
+			rls.WithRouteTable(limits.routes),
+			rls.WithTimeout(cfg.Storage.Timeout),
+		}
+		if cfg.Middleware.LegacyHeaders {
+			rlsOptions = append(rlsOptions, rls.WithLegacyHeaders())
+		}
+		server := grpc.NewServer()
+		rls.NewService(limits.rateLimiter, rlsOptions...).Register(server)
+
+		slog.Info("Starting Envoy rate limit service", "addr", cfg.RLS.Addr, "domain", cfg.RLS.Domain)
+		go func() {
+			if err := server.Serve(listener); err != nil {
+				fatal("Envoy rate limit service failed", err)
+			}
+		}()
+	}
 
 	if err := http.ListenAndServe(addr, handler); err != nil {
-		log.Fatalf("Server failed: %v", err)
+		fatal("Server failed", err)
+	}
+}
+
+// newLogger creates the sampled logger of the configuration, writing to
+// stderr like the log package
+func newLogger(cfg config.LogConfig) *slog.Logger {
+	return slog.New(logging.NewSamplingHandler(newHandler(cfg), cfg.SampleInitial, cfg.SampleThereafter, time.Second))
+}
+
+// newHandler creates the unsampled handler of the configuration
+func newHandler(cfg config.LogConfig) slog.Handler {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	if cfg.Format == config.LogFormatJSON {
+		return slog.NewJSONHandler(os.Stderr, opts)
+	}
+	return slog.NewTextHandler(os.Stderr, opts)
+}
+
+// fatal logs an error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// upstreamList formats the upstreams as "PREFIX=URL", with their passwords
+// redacted
+func upstreamList(upstreams []proxy.Upstream) []string {
+	var list []string
+	for _, upstream := range upstreams {
+		list = append(list, upstream.String())
+	}
+	return list
+}
+
+// formatRate formats a limit per period, one second if zero
+func formatRate(limit int, period time.Duration) string {
+	if period <= 0 || period == time.Second {
+		return fmt.Sprintf("%d req/s", limit)
 	}
+	return fmt.Sprintf("%d req/%v", limit, period)
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/allis/rate-limiter/internal/config"
+	"github.com/allis/rate-limiter/pkg/ratelimit"
+)
+
+// reloader swaps the rate limits, routes, bypass patterns and costs of the
+// running server. Counters and blocks are kept in the storage, so they
+// survive a reload.
+type reloader struct {
+	mu          sync.Mutex
+	cfg         *config.Config
+	store       ratelimit.Storage
+	rateLimiter *ratelimit.Limiter
+	routes      *ratelimit.RouteTable
+	costs       atomic.Pointer[ratelimit.CostFunc]
+}
+
+// newReloader creates the rate limiter and route table of a configuration
+func newReloader(cfg *config.Config, store ratelimit.Storage) (*reloader, error) {
+	r := &reloader{
+		cfg:         cfg,
+		store:       store,
+		rateLimiter: ratelimit.New(store, ratelimit.WithConfig(cfg.LimiterConfig())),
+	}
+
+	var err error
+	if r.routes, err = ratelimit.NewRouteTable(routes(store, cfg), cfg.Middleware.Bypass); err != nil {
+		return nil, err
+	}
+	r.setCosts(cfg.Middleware.RouteCosts)
+	return r, nil
+}
+
+// cost returns the cost of a request with the current route costs
+func (r *reloader) cost(req *http.Request) int {
+	return (*r.costs.Load())(req)
+}
+
+func (r *reloader) setCosts(rules []ratelimit.RouteCost) {
+	cost := ratelimit.RouteCosts(rules)
+	r.costs.Store(&cost)
+}
+
+// reload loads the limits again and applies them. On error the previous
+// configuration stays active.
+func (r *reloader) reload(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := r.cfg.ReloadLimits()
+	if err == nil {
+		err = r.routes.Update(routes(r.store, cfg), cfg.Middleware.Bypass)
+	}
+	if err != nil {
+		slog.Error("Failed to reload configuration, keeping the previous configuration", "reason", reason, "error", err)
+		return
+	}
+	r.rateLimiter.SetConfig(cfg.LimiterConfig())
+	r.setCosts(cfg.Middleware.RouteCosts)
+	r.cfg = cfg
+
+	slog.Info("Configuration reloaded",
+		"reason", reason,
+		"ip_limit", formatRate(cfg.RateLimiter.IPLimit, cfg.RateLimiter.IPPeriod),
+		"default_token_limit", formatRate(cfg.RateLimiter.DefaultTokenLimit, cfg.RateLimiter.DefaultTokenPeriod),
+		"token_limits", len(cfg.RateLimiter.TokenLimits),
+		"routes", len(cfg.RateLimiter.Routes),
+		"route_costs", len(cfg.Middleware.RouteCosts),
+		"bypass", cfg.Middleware.Bypass)
+}
+
+// watch reloads the configuration on SIGHUP and, if enabled, when the
+// policy file changes, in the background until ctx is done. SIGHUP is
+// handled once watch returns, so a signal sent right after it does not
+// terminate the process.
+func (r *reloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	if file, interval := r.cfg.Policy.File, r.cfg.Policy.WatchInterval; file != "" && interval > 0 {
+		go config.WatchFile(ctx, file, interval, slog.Default(), func() {
+			r.reload("policy file changed")
+		})
+	}
+
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				r.reload("SIGHUP")
+			}
+		}
+	}()
+}
+
+// limits returns the current configuration of the global limits or of a
+// named route, see config.Config.Limits
+func (r *reloader) limits(route string) (ratelimit.Config, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg.Limits(route)
+}
+
+// routes creates the rate limiters of the configured routes
+func routes(store ratelimit.Storage, cfg *config.Config) []ratelimit.Route {
+	var routes []ratelimit.Route
+	for _, route := range cfg.RateLimiter.Routes {
+		routes = append(routes, ratelimit.Route{
+			Pattern: route.Pattern,
+			Limiter: ratelimit.New(store, ratelimit.WithConfig(route.LimiterConfig())),
+		})
+	}
+	return routes
+}
@@ -7,9 +7,7 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/allis/rate-limiter/internal/limiter"
-	"github.com/allis/rate-limiter/internal/middleware"
-	"github.com/allis/rate-limiter/internal/storage"
+	"github.com/allis/rate-limiter/pkg/ratelimit"
 )
 
 // User representa um usuário na API
@@ -32,48 +30,20 @@ var users = []User{
 	{ID: 2, Name: "Maria Santos", Email: "maria@example.com", CreatedAt: time.Now()},
 }
 
+// Limites do exemplo
+var (
+	ipLimit    = ratelimit.Limit{Limit: 5, BlockDuration: 30 * time.Second}  // 5 requisições por segundo por IP
+	tokenLimit = ratelimit.Limit{Limit: 20, BlockDuration: 60 * time.Second} // 20 requisições por segundo para tokens
+)
+
 func main() {
 	// Configurar Redis Storage
-	redisStorage, err := storage.NewRedisStorage("localhost:6379", "", 0)
+	redisStorage, err := ratelimit.NewRedisStorage("localhost:6379")
 	if err != nil {
 		log.Fatalf("Erro ao conectar ao Redis: %v", err)
 	}
 	defer redisStorage.Close()
 
-	// Configurar Rate Limiter
-	config := limiter.Config{
-		IPLimit:                   5, // 5 requisições por segundo por IP
-		IPBlockDuration:           30 * time.Second,
-		DefaultTokenLimit:         20, // 20 requisições por segundo para tokens
-		DefaultTokenBlockDuration: 60 * time.Second,
-		TokenLimits: map[string]limiter.TokenConfig{
-			"premium_token": {
-				Limit:         100,
-				BlockDuration: 30 * time.Second,
-			},
-			"basic_token": {
-				Limit:         10,
-				BlockDuration: 60 * time.Second,
-			},
-		},
-	}
-
-	rateLimiter := limiter.NewRateLimiter(redisStorage, config)
-
-	// Configurar rotas
-	mux := http.NewServeMux()
-
-	// Health check (sem rate limiting)
-	mux.HandleFunc("/health", handleHealth)
-
-	// API routes (com rate limiting)
-	apiMux := http.NewServeMux()
-	apiMux.HandleFunc("/api/users", handleUsers)
-	apiMux.HandleFunc("/api/stats", handleStats)
-
-	// Aplicar middleware de rate limiting apenas nas rotas /api/*
-	mux.Handle("/api/", middleware.RateLimiterMiddleware(rateLimiter)(apiMux))
-
 	// Iniciar servidor
 	addr := ":8080"
 	fmt.Printf("🚀 Servidor iniciado em http://localhost%s\n", addr)
@@ -88,15 +58,43 @@ func main() {
 	fmt.Println("   basic_token   - 10 req/s")
 	fmt.Println()
 	fmt.Println("⚡ Rate Limits:")
-	fmt.Printf("   IP: %d req/s (bloqueio: %v)\n", config.IPLimit, config.IPBlockDuration)
-	fmt.Printf("   Token padrão: %d req/s (bloqueio: %v)\n", config.DefaultTokenLimit, config.DefaultTokenBlockDuration)
+	fmt.Printf("   IP: %d req/s (bloqueio: %v)\n", ipLimit.Limit, ipLimit.BlockDuration)
+	fmt.Printf("   Token padrão: %d req/s (bloqueio: %v)\n", tokenLimit.Limit, tokenLimit.BlockDuration)
 	fmt.Println()
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	if err := http.ListenAndServe(addr, newHandler(redisStorage)); err != nil {
 		log.Fatalf("Erro ao iniciar servidor: %v", err)
 	}
 }
 
+// newHandler cria as rotas da API, limitando as rotas /api/* com os
+// contadores em store
+func newHandler(store ratelimit.Storage) http.Handler {
+	// Configurar Rate Limiter
+	rateLimiter := ratelimit.New(store,
+		ratelimit.WithIPLimit(ipLimit),
+		ratelimit.WithDefaultTokenLimit(tokenLimit),
+		ratelimit.WithTokenLimit("premium_token", ratelimit.Limit{Limit: 100, BlockDuration: 30 * time.Second}),
+		ratelimit.WithTokenLimit("basic_token", ratelimit.Limit{Limit: 10, BlockDuration: 60 * time.Second}),
+	)
+
+	// Configurar rotas
+	mux := http.NewServeMux()
+
+	// Health check (sem rate limiting)
+	mux.HandleFunc("/health", handleHealth)
+
+	// API routes (com rate limiting)
+	apiMux := http.NewServeMux()
+	apiMux.HandleFunc("/api/users", handleUsers)
+	apiMux.HandleFunc("/api/stats", handleStats)
+
+	// Aplicar middleware de rate limiting apenas nas rotas /api/*
+	mux.Handle("/api/", ratelimit.Middleware(rateLimiter)(apiMux))
+
+	return mux
+}
+
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, APIResponse{
 		Success: true,
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/allis/rate-limiter/pkg/ratelimit"
+)
+
+func TestNewHandler(t *testing.T) {
+	store := ratelimit.NewMemoryStorage()
+	defer store.Close()
+	handler := newHandler(store)
+
+	request := func(path, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if token != "" {
+			req.Header.Set("API_KEY", token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Test: The IP limit applies to the API routes only
+	for i := 1; i <= ipLimit.Limit; i++ {
+		if code := request("/api/users", ""); code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200, got %d", i, code)
+		}
+	}
+	if code := request("/api/users", ""); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 above the IP limit, got %d", code)
+	}
+	if code := request("/health", ""); code != http.StatusOK {
+		t.Errorf("Expected the health check not to be limited, got %d", code)
+	}
+
+	// Test: Tokens have their own limit
+	if code := request("/api/stats", "premium_token"); code != http.StatusOK {
+		t.Errorf("Expected 200 with a token, got %d", code)
+	}
+}
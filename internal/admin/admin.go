@@ -0,0 +1,169 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Handler serves the admin API, which inspects, unblocks, resets and blocks
+// the IPs and tokens tracked in a storage:
+//
+//	GET    /keys?prefix=ip:                    lists the tracked storage keys
+//	GET    /blocks?prefix=ip:                  lists the blocked storage keys
+//	GET    /identities/{kind}/{id}             shows the counters and blocks
+//	PUT    /identities/{kind}/{id}/block       blocks with {"duration", "reason"}
+//	DELETE /identities/{kind}/{id}/block       lifts the blocks
+//	DELETE /identities/{kind}/{id}             resets the counters and blocks
+//
+// kind is "ip" or "token". The "route" query parameter selects the limits of
+// a named route instead of the global ones. Every request must carry the
+// admin token as "Authorization: Bearer <token>".
+type Handler struct {
+	service Service
+	token   string
+	mux     *http.ServeMux
+}
+
+// NewHandler creates the admin API for service, authenticated with token
+func NewHandler(service Service, token string) *Handler {
+	h := &Handler{
+		service: service,
+		token:   token,
+		mux:     http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /keys", h.listKeys)
+	h.mux.HandleFunc("GET /blocks", h.listBlocks)
+	h.mux.HandleFunc("GET /identities/{kind}/{id}", h.inspect)
+	h.mux.HandleFunc("PUT /identities/{kind}/{id}/block", h.block)
+	h.mux.HandleFunc("DELETE /identities/{kind}/{id}/block", h.unblock)
+	h.mux.HandleFunc("DELETE /identities/{kind}/{id}", h.reset)
+	return h
+}
+
+// ServeHTTP authenticates the request and serves it
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || h.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid admin token"))
+		return
+	}
+	h.mux.ServeHTTP(w, r.WithContext(WithActor(r.Context(), actor(r))))
+}
+
+// actor returns the address of the client of an admin request, the actor
+// of its changes
+func actor(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// blockRequest is the body of a block request
+type blockRequest struct {
+	// Duration is a Go duration, e.g. "30m" or "24h"
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+// ref returns the identity addressed by a request
+func ref(r *http.Request) Ref {
+	return Ref{
+		Kind:  r.PathValue("kind"),
+		ID:    r.PathValue("id"),
+		Route: r.URL.Query().Get("route"),
+	}
+}
+
+func (h *Handler) listKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.service.Keys(r.Context(), r.URL.Query().Get("prefix"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"keys": keys})
+}
+
+func (h *Handler) listBlocks(w http.ResponseWriter, r *http.Request) {
+	blocked, err := h.service.Blocked(r.Context(), r.URL.Query().Get("prefix"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if blocked == nil {
+		blocked = []Window{}
+	}
+	writeJSON(w, http.StatusOK, map[string][]Window{"blocks": blocked})
+}
+
+func (h *Handler) inspect(w http.ResponseWriter, r *http.Request) {
+	identity, err := h.service.Inspect(r.Context(), ref(r))
+	writeIdentity(w, identity, err)
+}
+
+func (h *Handler) block(w http.ResponseWriter, r *http.Request) {
+	var req blockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration %q: must be a duration such as 30m or 24h", req.Duration))
+		return
+	}
+
+	identity, err := h.service.Block(r.Context(), ref(r), duration, req.Reason)
+	writeIdentity(w, identity, err)
+}
+
+func (h *Handler) unblock(w http.ResponseWriter, r *http.Request) {
+	identity, err := h.service.Unblock(r.Context(), ref(r))
+	writeIdentity(w, identity, err)
+}
+
+func (h *Handler) reset(w http.ResponseWriter, r *http.Request) {
+	identity, err := h.service.Reset(r.Context(), ref(r))
+	writeIdentity(w, identity, err)
+}
+
+// writeIdentity writes an identity or the error of the operation
+func writeIdentity(w http.ResponseWriter, identity Identity, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownKind):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrInvalidBlock):
+		writeError(w, http.StatusBadRequest, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, identity)
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// errorResponse is the body of the error responses
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeError writes an error as a JSON response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
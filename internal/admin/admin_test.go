@@ -0,0 +1,216 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+	"github.com/allis/rate-limiter/internal/storage"
+)
+
+const testToken = "s3cret"
+
+// do sends an authenticated request to the handler
+func do(t *testing.T, h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeIdentity decodes an identity response, failing on other statuses
+func decodeIdentity(t *testing.T, rec *httptest.ResponseRecorder) Identity {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var identity Identity
+	if err := json.NewDecoder(rec.Body).Decode(&identity); err != nil {
+		t.Fatalf("Failed to decode identity: %v", err)
+	}
+	return identity
+}
+
+func TestHandler_Auth(t *testing.T) {
+	h := NewHandler(NewStorageService(storage.NewMemoryStorage(0)), testToken)
+
+	for _, header := range []string{"", "Bearer wrong", testToken} {
+		req := httptest.NewRequest(http.MethodGet, "/keys", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Authorization %q: expected 401 with WWW-Authenticate, got %d", header, rec.Code)
+		}
+	}
+
+	// Test: An empty admin token rejects every request
+	if rec := do(t, NewHandler(NewStorageService(storage.NewMemoryStorage(0)), ""), http.MethodGet, "/keys", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without an admin token, got %d", rec.Code)
+	}
+
+	if rec := do(t, h, http.MethodGet, "/keys", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 with the admin token, got %d", rec.Code)
+	}
+}
+
+func TestHandler_Identity(t *testing.T) {
+	store := storage.NewMemoryStorage(0)
+	config := limiter.Config{
+		IPLimit:         2,
+		IPBlockDuration: time.Minute,
+		IPWindows:       []limiter.Window{{Limit: 100, Period: time.Hour}},
+	}
+	rl := limiter.NewRateLimiter(store, config)
+	limits := func(route string) (limiter.Config, bool) {
+		return config, route == ""
+	}
+	// The window keys come from the limits, the keyspace is never listed
+	h := NewHandler(NewStorageService(unlistedStorage{store}, WithLimits(limits)), testToken)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		rl.CheckIP(ctx, "10.0.0.1")
+	}
+
+	// Test: Lookup reports the counter, the block and its TTL
+	identity := decodeIdentity(t, do(t, h, http.MethodGet, "/identities/ip/10.0.0.1", ""))
+	if !identity.Blocked || identity.BlockTTL != 60 || len(identity.Windows) != 2 {
+		t.Fatalf("Expected a 60s block with 2 windows, got %+v", identity)
+	}
+	if main := identity.Windows[0]; main.Key != "ip:10.0.0.1" || main.Count != 3 || !main.Blocked {
+		t.Errorf("Expected a blocked main window with count 3, got %+v", main)
+	}
+	// The rejected request does not count in the longer window
+	if hourly := identity.Windows[1]; hourly.Key != "ip:10.0.0.1:1h0m0s" || hourly.Count != 2 {
+		t.Errorf("Expected the hourly window with count 2, got %+v", hourly)
+	}
+
+	// Test: Unblock lifts the block and keeps the counters
+	identity = decodeIdentity(t, do(t, h, http.MethodDelete, "/identities/ip/10.0.0.1/block", ""))
+	if identity.Blocked || identity.Windows[0].Count != 3 {
+		t.Fatalf("Expected an unblocked identity with count 3, got %+v", identity)
+	}
+
+	// Test: Manual block with a reason rejects requests
+	identity = decodeIdentity(t, do(t, h, http.MethodPut, "/identities/ip/10.0.0.1/block",
+		`{"duration": "2h", "reason": "abuse report #42"}`))
+	if !identity.Blocked || identity.BlockTTL != 7200 || identity.Windows[0].Reason != "abuse report #42" {
+		t.Fatalf("Expected a 2h block for the abuse report, got %+v", identity)
+	}
+	if decision, _ := rl.CheckIP(ctx, "10.0.0.1"); decision.Allowed {
+		t.Error("Expected the blocked IP to be rejected")
+	}
+
+	// Test: Reset removes the counters and blocks of every window
+	identity = decodeIdentity(t, do(t, h, http.MethodDelete, "/identities/ip/10.0.0.1", ""))
+	if identity.Blocked || len(identity.Windows) != 2 || identity.Windows[0].Count != 0 || identity.Windows[1].Count != 0 {
+		t.Fatalf("Expected a reset identity, got %+v", identity)
+	}
+	if decision, _ := rl.CheckIP(ctx, "10.0.0.1"); !decision.Allowed {
+		t.Error("Expected the reset IP to be allowed")
+	}
+}
+
+func TestHandler_Audit(t *testing.T) {
+	var buf bytes.Buffer
+	service := NewStorageService(storage.NewMemoryStorage(0), WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+	h := NewHandler(service, testToken)
+
+	do(t, h, http.MethodPut, "/identities/ip/10.0.0.1/block", `{"duration": "1h", "reason": "abuse"}`)
+	do(t, h, http.MethodDelete, "/identities/ip/10.0.0.1/block", "")
+	do(t, h, http.MethodDelete, "/identities/token/abc123?route=login", "")
+	do(t, h, http.MethodGet, "/identities/ip/10.0.0.1", "")
+
+	// Test: Every change is audited with structured fields, lookups are not
+	type record struct {
+		Msg, Actor, Action, Key, Duration, Reason string
+	}
+	want := []record{
+		{"Admin change", "192.0.2.1", "block", "ip:10.0.0.1", "1h0m0s", "abuse"},
+		{"Admin change", "192.0.2.1", "unblock", "ip:10.0.0.1", "", ""},
+		{"Admin change", "192.0.2.1", "reset", "route:login:token:abc123", "", ""},
+	}
+	var got []record
+	for dec := json.NewDecoder(&buf); dec.More(); {
+		var r record
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("Failed to decode audit record: %v", err)
+		}
+		got = append(got, r)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d audit records, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Record %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestHandler_RouteAndKeys(t *testing.T) {
+	store := storage.NewMemoryStorage(0)
+	rl := limiter.NewRateLimiter(store, limiter.Config{
+		DefaultTokenLimit: 10,
+		KeyPrefix:         limiter.RouteKeyPrefix("login"),
+	})
+	h := NewHandler(NewStorageService(store), testToken)
+	rl.CheckToken(context.Background(), "abc123")
+
+	identity := decodeIdentity(t, do(t, h, http.MethodGet, "/identities/token/abc123?route=login", ""))
+	if identity.Route != "login" || identity.Windows[0].Key != "route:login:token:abc123" || identity.Windows[0].Count != 1 {
+		t.Errorf("Expected the login route counter, got %+v", identity)
+	}
+
+	rec := do(t, h, http.MethodGet, "/keys?prefix=route:", "")
+	var keys struct{ Keys []string }
+	json.NewDecoder(rec.Body).Decode(&keys)
+	if len(keys.Keys) != 1 || keys.Keys[0] != "route:login:token:abc123" {
+		t.Errorf("Expected the login route key, got %v", keys.Keys)
+	}
+}
+
+func TestHandler_BadRequests(t *testing.T) {
+	h := NewHandler(NewStorageService(storage.NewMemoryStorage(0)), testToken)
+
+	tests := []struct {
+		method, target, body string
+		status               int
+	}{
+		{http.MethodGet, "/identities/user/alice", "", http.StatusNotFound},
+		{http.MethodPut, "/identities/ip/10.0.0.1/block", `{"duration": "forever", "reason": "abuse"}`, http.StatusBadRequest},
+		{http.MethodPut, "/identities/ip/10.0.0.1/block", `{"duration": "-1h", "reason": "abuse"}`, http.StatusBadRequest},
+		{http.MethodPut, "/identities/ip/10.0.0.1/block", `{"duration": "1h"}`, http.StatusBadRequest},
+		{http.MethodPut, "/identities/ip/10.0.0.1/block", `not json`, http.StatusBadRequest},
+		{http.MethodPost, "/identities/ip/10.0.0.1", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		if rec := do(t, h, tt.method, tt.target, tt.body); rec.Code != tt.status {
+			t.Errorf("%s %s %s: expected %d, got %d: %s", tt.method, tt.target, tt.body, tt.status, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// unlistedStorage fails the keyspace listings, which the identity
+// operations must not need
+type unlistedStorage struct {
+	storage.Storage
+}
+
+func (s unlistedStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	return nil, errors.New("unexpected List call")
+}
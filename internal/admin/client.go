@@ -0,0 +1,128 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client implements Service over the admin API of a running server
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the admin API at baseURL, e.g.
+// "http://127.0.0.1:9090", authenticated with token
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Keys returns the tracked storage keys starting with prefix
+func (c *Client) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var resp struct {
+		Keys []string `json:"keys"`
+	}
+	err := c.do(ctx, http.MethodGet, "/keys?"+url.Values{"prefix": {prefix}}.Encode(), nil, &resp)
+	return resp.Keys, err
+}
+
+// Blocked returns the blocked keys starting with prefix
+func (c *Client) Blocked(ctx context.Context, prefix string) ([]Window, error) {
+	var resp struct {
+		Blocks []Window `json:"blocks"`
+	}
+	err := c.do(ctx, http.MethodGet, "/blocks?"+url.Values{"prefix": {prefix}}.Encode(), nil, &resp)
+	return resp.Blocks, err
+}
+
+// Inspect returns the state of an identity
+func (c *Client) Inspect(ctx context.Context, ref Ref) (Identity, error) {
+	return c.identity(ctx, http.MethodGet, ref, "", nil)
+}
+
+// Block blocks an identity for duration, recording the reason
+func (c *Client) Block(ctx context.Context, ref Ref, duration time.Duration, reason string) (Identity, error) {
+	if err := checkBlock(duration, reason); err != nil {
+		return Identity{}, err
+	}
+	return c.identity(ctx, http.MethodPut, ref, "/block", blockRequest{Duration: duration.String(), Reason: reason})
+}
+
+// Unblock lifts the blocks of every window of an identity
+func (c *Client) Unblock(ctx context.Context, ref Ref) (Identity, error) {
+	return c.identity(ctx, http.MethodDelete, ref, "/block", nil)
+}
+
+// Reset removes the state of every window of an identity
+func (c *Client) Reset(ctx context.Context, ref Ref) (Identity, error) {
+	return c.identity(ctx, http.MethodDelete, ref, "", nil)
+}
+
+// identity sends a request on an identity and decodes its state
+func (c *Client) identity(ctx context.Context, method string, ref Ref, suffix string, body interface{}) (Identity, error) {
+	if _, err := ref.Key(); err != nil {
+		return Identity{}, err
+	}
+
+	path := "/identities/" + url.PathEscape(ref.Kind) + "/" + url.PathEscape(ref.ID) + suffix
+	if ref.Route != "" {
+		path += "?" + url.Values{"route": {ref.Route}}.Encode()
+	}
+
+	var identity Identity
+	err := c.do(ctx, method, path, body, &identity)
+	return identity, err
+}
+
+// do sends an authenticated request and decodes the JSON response into v
+func (c *Client) do(ctx context.Context, method, path string, body, v interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call admin API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			errResp.Error = resp.Status
+		}
+		return errors.New("admin API: " + errResp.Error)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode admin API response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/storage"
+)
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(NewHandler(NewStorageService(storage.NewMemoryStorage(0)), testToken))
+	defer server.Close()
+	client := NewClient(server.URL+"/", testToken)
+	ctx := context.Background()
+	ref := Ref{Kind: KindToken, ID: "abc 123", Route: "login"}
+
+	identity, err := client.Block(ctx, ref, time.Hour, "chargeback")
+	if err != nil {
+		t.Fatalf("Block failed: %v", err)
+	}
+	if !identity.Blocked || identity.Route != "login" || identity.Windows[0].Key != "route:login:token:abc 123" {
+		t.Fatalf("Expected a blocked login route token, got %+v", identity)
+	}
+
+	blocked, err := client.Blocked(ctx, "route:")
+	if err != nil || len(blocked) != 1 || blocked[0].Reason != "chargeback" || blocked[0].BlockTTL != 3600 {
+		t.Fatalf("Expected the chargeback block, got %+v (%v)", blocked, err)
+	}
+	if keys, err := client.Keys(ctx, "route:login:"); err != nil || len(keys) != 1 {
+		t.Fatalf("Expected 1 key, got %v (%v)", keys, err)
+	}
+
+	if identity, err := client.Unblock(ctx, ref); err != nil || identity.Blocked {
+		t.Fatalf("Expected an unblocked token, got %+v (%v)", identity, err)
+	}
+	if identity, err := client.Reset(ctx, ref); err != nil || len(identity.Windows) != 1 {
+		t.Fatalf("Expected a reset token, got %+v (%v)", identity, err)
+	}
+	if identity, err := client.Inspect(ctx, ref); err != nil || identity.Blocked {
+		t.Fatalf("Expected an unblocked token, got %+v (%v)", identity, err)
+	}
+
+	// Test: Invalid requests fail before reaching the API
+	if _, err := client.Block(ctx, ref, time.Hour, ""); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("Expected ErrInvalidBlock, got %v", err)
+	}
+	if _, err := client.Inspect(ctx, Ref{Kind: "user", ID: "alice"}); !errors.Is(err, ErrUnknownKind) {
+		t.Errorf("Expected ErrUnknownKind, got %v", err)
+	}
+
+	// Test: API errors are returned with their message
+	_, err = NewClient(server.URL, "wrong").Keys(ctx, "")
+	if err == nil || !strings.Contains(err.Error(), "missing or invalid admin token") {
+		t.Errorf("Expected an authentication error, got %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+	"github.com/allis/rate-limiter/internal/storage"
+)
+
+// Supported identity kinds
+const (
+	KindIP    = "ip"
+	KindToken = "token"
+)
+
+var (
+	// ErrUnknownKind is returned for identity kinds other than KindIP and
+	// KindToken
+	ErrUnknownKind = errors.New("unknown identity kind")
+	// ErrInvalidBlock is returned for blocks without a positive duration or
+	// a reason
+	ErrInvalidBlock = errors.New("invalid block")
+)
+
+// Ref refers to an IP or token, optionally on a named route
+type Ref struct {
+	// Kind is KindIP or KindToken
+	Kind string
+	ID   string
+	// Route selects the limits of a named route instead of the global ones
+	Route string
+}
+
+// Key returns the storage key of the main window of the identity
+func (r Ref) Key() (string, error) {
+	var config limiter.Config
+	if r.Route != "" {
+		config.KeyPrefix = limiter.RouteKeyPrefix(r.Route)
+	}
+	switch r.Kind {
+	case KindIP:
+		return config.IPKey(r.ID), nil
+	case KindToken:
+		return config.TokenKey(r.ID), nil
+	default:
+		return "", fmt.Errorf("%w %q: must be %q or %q", ErrUnknownKind, r.Kind, KindIP, KindToken)
+	}
+}
+
+// Window is the state of one window of an identity
+type Window struct {
+	// Key is the storage key of the window
+	Key string `json:"key"`
+	// Count is the fixed window counter, the state of the other algorithms
+	// is not a count and reported as 0
+	Count int64 `json:"count"`
+	// Blocked reports whether the window is blocked
+	Blocked bool `json:"blocked"`
+	// BlockTTL is the remaining block duration in seconds
+	BlockTTL int64 `json:"block_ttl,omitempty"`
+	// Reason is why the block was imposed, empty for exceeded limits
+	Reason string `json:"reason,omitempty"`
+}
+
+// Identity is the state of an IP or token
+type Identity struct {
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	Route string `json:"route,omitempty"`
+	// Blocked reports whether any window is blocked
+	Blocked bool `json:"blocked"`
+	// BlockTTL is the longest remaining block duration in seconds
+	BlockTTL int64 `json:"block_ttl,omitempty"`
+	// Windows are the main window, with the identity key, followed by the
+	// additional windows configured for the identity
+	Windows []Window `json:"windows"`
+}
+
+// Service inspects, unblocks, resets and blocks the tracked identities,
+// implemented on top of a storage by StorageService and over the admin API
+// by Client
+type Service interface {
+	// Keys returns the tracked storage keys starting with prefix
+	Keys(ctx context.Context, prefix string) ([]string, error)
+	// Blocked returns the blocked keys starting with prefix
+	Blocked(ctx context.Context, prefix string) ([]Window, error)
+	// Inspect returns the state of an identity
+	Inspect(ctx context.Context, ref Ref) (Identity, error)
+	// Block blocks an identity for duration, recording the reason
+	Block(ctx context.Context, ref Ref, duration time.Duration, reason string) (Identity, error)
+	// Unblock lifts the blocks of every window of an identity, keeping the
+	// counters
+	Unblock(ctx context.Context, ref Ref) (Identity, error)
+	// Reset removes the counters, algorithm state and blocks of every
+	// window of an identity
+	Reset(ctx context.Context, ref Ref) (Identity, error)
+}
+
+// StorageService implements Service on a storage, logging every change
+type StorageService struct {
+	store  storage.Storage
+	limits Limits
+	logger *slog.Logger
+}
+
+// Limits returns the rate limiter configuration of the global limits, for
+// an empty route, or of a named route, reporting false if the route is not
+// configured
+type Limits func(route string) (limiter.Config, bool)
+
+// ServiceOption configures a StorageService
+type ServiceOption func(*StorageService)
+
+// WithLogger sets the logger the changes are audited to, slog.Default() if
+// not set. Audit records should not be sampled.
+func WithLogger(logger *slog.Logger) ServiceOption {
+	return func(s *StorageService) {
+		s.logger = logger
+	}
+}
+
+// WithLimits sets the limits the additional windows of the identities are
+// taken from. Without them, or for routes they do not know, only the main
+// window of an identity is operated on.
+func WithLimits(limits Limits) ServiceOption {
+	return func(s *StorageService) {
+		s.limits = limits
+	}
+}
+
+// NewStorageService creates a service operating on store
+func NewStorageService(store storage.Storage, opts ...ServiceOption) *StorageService {
+	s := &StorageService{store: store, logger: slog.Default()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// actorKey is the context key of the actor of a change
+type actorKey struct{}
+
+// WithActor returns a context attributing the changes made with it to
+// actor in the audit records, e.g. the address of an admin API client
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// audit records a change to the key of an identity
+func (s *StorageService) audit(ctx context.Context, action, key string, attrs ...any) {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	if actor == "" {
+		actor = "unknown"
+	}
+	s.logger.InfoContext(ctx, "Admin change", append([]any{"actor", actor, "action", action, "key", key}, attrs...)...)
+}
+
+// Keys returns the tracked storage keys starting with prefix
+func (s *StorageService) Keys(ctx context.Context, prefix string) ([]string, error) {
+	return s.store.List(ctx, prefix)
+}
+
+// Blocked returns the blocked keys starting with prefix
+func (s *StorageService) Blocked(ctx context.Context, prefix string) ([]Window, error) {
+	keys, err := s.store.ListBlocked(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocked []Window
+	for _, key := range keys {
+		window, err := s.window(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		// The block may have expired since it was listed
+		if window.Blocked {
+			blocked = append(blocked, window)
+		}
+	}
+	return blocked, nil
+}
+
+// Inspect returns the state of an identity
+func (s *StorageService) Inspect(ctx context.Context, ref Ref) (Identity, error) {
+	if _, err := ref.Key(); err != nil {
+		return Identity{}, err
+	}
+	return s.identity(ctx, ref)
+}
+
+// Block blocks the main window of an identity, which rejects every request
+func (s *StorageService) Block(ctx context.Context, ref Ref, duration time.Duration, reason string) (Identity, error) {
+	key, err := ref.Key()
+	if err != nil {
+		return Identity{}, err
+	}
+	if err := checkBlock(duration, reason); err != nil {
+		return Identity{}, err
+	}
+
+	if err := s.store.SetBlockWithReason(ctx, key, duration, reason); err != nil {
+		return Identity{}, err
+	}
+	s.audit(ctx, "block", key, "duration", duration.String(), "reason", reason)
+	return s.identity(ctx, ref)
+}
+
+// checkBlock reports a block without a positive duration or a reason
+func checkBlock(duration time.Duration, reason string) error {
+	if duration <= 0 {
+		return fmt.Errorf("%w: duration must be positive", ErrInvalidBlock)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("%w: reason is required", ErrInvalidBlock)
+	}
+	return nil
+}
+
+// Unblock lifts the blocks of every window of an identity
+func (s *StorageService) Unblock(ctx context.Context, ref Ref) (Identity, error) {
+	return s.apply(ctx, ref, "unblock", s.store.Unblock)
+}
+
+// Reset removes the state of every window of an identity
+func (s *StorageService) Reset(ctx context.Context, ref Ref) (Identity, error) {
+	return s.apply(ctx, ref, "reset", s.store.Delete)
+}
+
+// apply calls fn for every window key of an identity and returns its state
+func (s *StorageService) apply(ctx context.Context, ref Ref, action string, fn func(context.Context, string) error) (Identity, error) {
+	keys, err := s.windowKeys(ref)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, fn(ctx, key))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Identity{}, err
+	}
+	s.audit(ctx, action, keys[0])
+	return s.identity(ctx, ref)
+}
+
+// windowKeys returns the key of an identity followed by the keys of the
+// additional windows of its rule, see limiter.TokenConfig.WindowKeys
+func (s *StorageService) windowKeys(ref Ref) ([]string, error) {
+	key, err := ref.Key()
+	if err != nil {
+		return nil, err
+	}
+	if s.limits == nil {
+		return []string{key}, nil
+	}
+	config, ok := s.limits(ref.Route)
+	if !ok {
+		return []string{key}, nil
+	}
+
+	rule := config.IPRule(ref.ID)
+	if ref.Kind == KindToken {
+		rule = config.TokenRule(ref.ID)
+	}
+	return rule.Limit.WindowKeys(key), nil
+}
+
+// identity reads the current state of an identity
+func (s *StorageService) identity(ctx context.Context, ref Ref) (Identity, error) {
+	keys, err := s.windowKeys(ref)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	result := Identity{Kind: ref.Kind, ID: ref.ID, Route: ref.Route}
+	for _, key := range keys {
+		window, err := s.window(ctx, key)
+		if err != nil {
+			return Identity{}, err
+		}
+		result.Windows = append(result.Windows, window)
+		result.Blocked = result.Blocked || window.Blocked
+		result.BlockTTL = max(result.BlockTTL, window.BlockTTL)
+	}
+	return result, nil
+}
+
+// window reads the state of a window key
+func (s *StorageService) window(ctx context.Context, key string) (Window, error) {
+	window := Window{Key: key}
+	var err error
+	if window.Count, err = s.store.Get(ctx, key); err != nil {
+		return Window{}, err
+	}
+	if window.Blocked, err = s.store.IsBlocked(ctx, key); err != nil || !window.Blocked {
+		return window, err
+	}
+
+	ttl, err := s.store.TTL(ctx, key)
+	if err != nil {
+		return Window{}, err
+	}
+	window.BlockTTL = ceilSeconds(ttl)
+	if window.Reason, err = s.store.BlockReason(ctx, key); err != nil {
+		return Window{}, err
+	}
+	return window, nil
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -2,20 +2,83 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
+	"net/netip"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/allis/rate-limiter/internal/limiter"
+	"github.com/allis/rate-limiter/internal/middleware"
+	"github.com/allis/rate-limiter/internal/proxy"
+	"github.com/allis/rate-limiter/internal/rls"
 	"github.com/joho/godotenv"
 )
 
+// Supported storage backends
+const (
+	StorageBackendRedis  = "redis"
+	StorageBackendMemory = "memory"
+)
+
+// Supported storage failure modes
+const (
+	// FailureModeClosed rejects requests with 500 while the storage fails
+	FailureModeClosed = "closed"
+	// FailureModeOpen lets requests through while the storage fails
+	FailureModeOpen = "open"
+	// FailureModeFallback limits requests in memory while the storage fails
+	FailureModeFallback = "fallback"
+)
+
+// Supported log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Supported trace exporters, named after the values of OTEL_TRACES_EXPORTER
+const (
+	// TracesExporterNone disables tracing
+	TracesExporterNone = "none"
+	// TracesExporterOTLP exports spans over OTLP/HTTP, configured by the
+	// OTEL_EXPORTER_OTLP_* variables
+	TracesExporterOTLP = "otlp"
+	// TracesExporterConsole writes spans to stdout
+	TracesExporterConsole = "console"
+)
+
 // Config holds application configuration
 type Config struct {
+	Storage     StorageConfig
 	Redis       RedisConfig
 	RateLimiter RateLimiterConfig
+	Policy      PolicyConfig
+	Middleware  MiddlewareConfig
 	Server      ServerConfig
+	Admin       AdminConfig
+	Metrics     MetricsConfig
+	Log         LogConfig
+	Tracing     TracingConfig
+	Proxy       ProxyConfig
+	RLS         RLSConfig
+}
+
+// StorageConfig holds storage backend configuration
+type StorageConfig struct {
+	Backend string
+	// FailureMode selects how requests are handled while the storage fails
+	FailureMode string
+	// BreakerThreshold is the number of consecutive failures that opens the
+	// circuit breaker around the storage, 0 to disable it
+	BreakerThreshold int
+	// BreakerTimeout is how long the circuit breaker stays open
+	BreakerTimeout time.Duration
+	// Timeout bounds each rate limit check, 0 to disable it
+	Timeout time.Duration
 }
 
 // RedisConfig holds Redis configuration
@@ -28,10 +91,64 @@ type RedisConfig struct {
 // RateLimiterConfig holds rate limiter configuration
 type RateLimiterConfig struct {
 	IPLimit                   int
+	IPPeriod                  time.Duration
 	IPBlockDuration           time.Duration
+	IPAlgorithm               limiter.Algorithm
+	IPBurst                   int
+	IPWindows                 []limiter.Window
 	DefaultTokenLimit         int
+	DefaultTokenPeriod        time.Duration
 	DefaultTokenBlockDuration time.Duration
+	DefaultTokenAlgorithm     limiter.Algorithm
+	DefaultTokenBurst         int
+	DefaultTokenWindows       []limiter.Window
 	TokenLimits               map[string]limiter.TokenConfig
+	// Routes are the rules of the requests matching a pattern, applied
+	// instead of the IP and token limits
+	Routes []RouteConfig
+}
+
+// PolicyConfig holds the policy file settings
+type PolicyConfig struct {
+	// File is the YAML or JSON file the limits are loaded from, if any,
+	// before the environment variables
+	File string
+	// WatchInterval is how often the file is checked for changes to reload
+	// the limits, 0 to disable it
+	WatchInterval time.Duration
+}
+
+// RouteConfig holds the rate limit rule of the requests matching a pattern
+type RouteConfig struct {
+	// Name keeps the counters of the route apart in the storage
+	Name string
+	// Pattern is an http.ServeMux pattern, e.g. "POST /login" or "/api/"
+	Pattern string
+	// Limit applies to each client IP or token on the route
+	Limit limiter.TokenConfig
+}
+
+// MiddlewareConfig holds HTTP middleware configuration
+type MiddlewareConfig struct {
+	LegacyHeaders bool
+	// ResponseFormat selects the body written for rejected requests
+	ResponseFormat middleware.ResponseFormat
+	// ProblemType is the problem+json type URI
+	ProblemType string
+	// HTMLTemplateFile is an optional html/template file for HTML responses
+	HTMLTemplateFile string
+	// TrustedProxies are the proxies whose forwarding headers are honored
+	TrustedProxies []netip.Prefix
+	// IPHeaders are the client IP headers in order of precedence
+	IPHeaders []string
+	// RouteCosts are the units consumed by requests to expensive routes
+	RouteCosts []middleware.RouteCost
+	// CostHeader is the response header handlers set to raise the cost of
+	// a request, disabled if empty
+	CostHeader string
+	// Bypass are the patterns of the requests never rate limited, e.g.
+	// health checks and metrics
+	Bypass []string
 }
 
 // ServerConfig holds server configuration
@@ -39,37 +156,356 @@ type ServerConfig struct {
 	Port string
 }
 
+// LogConfig holds the logging configuration
+type LogConfig struct {
+	// Level is the lowest level of the records written
+	Level slog.Level
+	// Format is LogFormatText or LogFormatJSON
+	Format string
+	// SampleInitial is the number of records with the same level and
+	// message written per second before sampling them, 0 disables sampling
+	SampleInitial int
+	// SampleThereafter is how many records of the same level and message
+	// are sampled into one once SampleInitial is reached, 0 drops them all
+	SampleThereafter int
+}
+
+// MetricsConfig holds the Prometheus metrics configuration
+type MetricsConfig struct {
+	// Enabled serves the metrics on their own listener, outside rate
+	// limiting
+	Enabled bool
+	// Addr is the address the metrics are served on, apart from the server
+	// port so they need not be exposed with it
+	Addr string
+	// Path is the path the metrics are served on
+	Path string
+	// BlockedInterval is how often the blocked keys are counted, which
+	// scans the whole keyspace on Redis. Zero disables the gauge.
+	BlockedInterval time.Duration
+}
+
+// ProxyConfig holds the reverse proxy configuration
+type ProxyConfig struct {
+	// Upstreams are the services the allowed requests are forwarded to by
+	// path prefix. Without upstreams the server answers the requests itself.
+	Upstreams []proxy.Upstream
+}
+
+// RLSConfig holds the configuration of the Envoy rate limit service
+type RLSConfig struct {
+	// Addr is the address the gRPC service listens on, disabled if empty
+	Addr string
+	// Domain is the rate limit domain of the requests accepted, any domain
+	// if empty
+	Domain string
+	// TokenKey is the descriptor key of the token entries
+	TokenKey string
+}
+
+// TracingConfig holds the OpenTelemetry tracing configuration
+type TracingConfig struct {
+	// Exporter is TracesExporterNone, TracesExporterOTLP or
+	// TracesExporterConsole
+	Exporter string
+}
+
+// AdminConfig holds the admin API configuration
+type AdminConfig struct {
+	// Addr is the address the admin API listens on, disabled if empty. It
+	// should not be reachable by the clients of the server.
+	Addr string
+	// Token authenticates the admin requests as a bearer token
+	Token string
+}
+
 // Load loads configuration from environment variables
 func Load() (*Config, error) {
 	// Load .env file if it exists
 	_ = godotenv.Load()
 
 	cfg := &Config{
+		Storage: StorageConfig{
+			Backend:          getEnv("STORAGE_BACKEND", StorageBackendRedis),
+			FailureMode:      getEnv("STORAGE_FAILURE_MODE", FailureModeClosed),
+			BreakerThreshold: getEnvAsInt("STORAGE_BREAKER_THRESHOLD", 5),
+			BreakerTimeout:   time.Duration(getEnvAsInt("STORAGE_BREAKER_TIMEOUT", 10)) * time.Second,
+			Timeout:          time.Duration(getEnvAsInt("STORAGE_TIMEOUT_MS", 100)) * time.Millisecond,
+		},
 		Redis: RedisConfig{
 			Addr:     getEnv("REDIS_ADDR", "localhost:6379"),
 			Password: getEnv("REDIS_PASSWORD", ""),
 			DB:       getEnvAsInt("REDIS_DB", 0),
 		},
-		RateLimiter: RateLimiterConfig{
-			IPLimit:                   getEnvAsInt("IP_RATE_LIMIT", 10),
-			IPBlockDuration:           time.Duration(getEnvAsInt("IP_BLOCK_DURATION", 300)) * time.Second,
-			DefaultTokenLimit:         getEnvAsInt("TOKEN_RATE_LIMIT", 100),
-			DefaultTokenBlockDuration: time.Duration(getEnvAsInt("TOKEN_BLOCK_DURATION", 300)) * time.Second,
-			TokenLimits:               make(map[string]limiter.TokenConfig),
+		Policy: PolicyConfig{
+			File:          getEnv("RATE_LIMIT_POLICY_FILE", ""),
+			WatchInterval: time.Duration(getEnvAsInt("RATE_LIMIT_POLICY_WATCH_INTERVAL", 5)) * time.Second,
+		},
+		Middleware: MiddlewareConfig{
+			LegacyHeaders:    getEnvAsBool("RATE_LIMIT_LEGACY_HEADERS", false),
+			ResponseFormat:   middleware.ResponseFormat(getEnv("RESPONSE_FORMAT", string(middleware.FormatPlain))),
+			ProblemType:      getEnv("RESPONSE_PROBLEM_TYPE", ""),
+			HTMLTemplateFile: getEnv("RESPONSE_HTML_TEMPLATE", ""),
+			IPHeaders:        getEnvAsList("IP_HEADERS", middleware.DefaultIPHeaders),
+			CostHeader:       getEnv("RATE_LIMIT_COST_HEADER", ""),
 		},
 		Server: ServerConfig{
 			Port: getEnv("SERVER_PORT", "8080"),
 		},
+		Admin: AdminConfig{
+			Addr:  getEnv("ADMIN_ADDR", ""),
+			Token: getEnv("ADMIN_TOKEN", ""),
+		},
+		Metrics: MetricsConfig{
+			Enabled:         getEnvAsBool("METRICS_ENABLED", false),
+			Addr:            getEnv("METRICS_ADDR", ":9090"),
+			Path:            getEnv("METRICS_PATH", "/metrics"),
+			BlockedInterval: time.Duration(getEnvAsInt("METRICS_BLOCKED_INTERVAL", 30)) * time.Second,
+		},
+		Log: LogConfig{
+			Format:           getEnv("LOG_FORMAT", LogFormatText),
+			SampleInitial:    getEnvAsInt("LOG_SAMPLE_INITIAL", 10),
+			SampleThereafter: getEnvAsInt("LOG_SAMPLE_THEREAFTER", 100),
+		},
+		Tracing: TracingConfig{
+			Exporter: getEnv("OTEL_TRACES_EXPORTER", TracesExporterNone),
+		},
+		RLS: RLSConfig{
+			Addr:     getEnv("RLS_ADDR", ""),
+			Domain:   getEnv("RLS_DOMAIN", ""),
+			TokenKey: getEnv("RLS_TOKEN_KEY", rls.DefaultTokenKey),
+		},
 	}
 
-	// Load token-specific configurations
-	loadTokenConfigs(cfg)
+	switch cfg.Storage.Backend {
+	case StorageBackendRedis, StorageBackendMemory:
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_BACKEND %q: must be %q or %q",
+			cfg.Storage.Backend, StorageBackendRedis, StorageBackendMemory)
+	}
+
+	switch cfg.Storage.FailureMode {
+	case FailureModeClosed, FailureModeOpen, FailureModeFallback:
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_FAILURE_MODE %q: must be %q, %q or %q",
+			cfg.Storage.FailureMode, FailureModeClosed, FailureModeOpen, FailureModeFallback)
+	}
+
+	switch cfg.Middleware.ResponseFormat {
+	case middleware.FormatPlain, middleware.FormatJSON, middleware.FormatProblem,
+		middleware.FormatHTML, middleware.FormatNegotiate:
+	default:
+		return nil, fmt.Errorf("invalid RESPONSE_FORMAT %q: must be plain, json, problem, html or negotiate",
+			cfg.Middleware.ResponseFormat)
+	}
+
+	if cfg.Admin.Addr != "" && cfg.Admin.Token == "" {
+		return nil, fmt.Errorf("ADMIN_TOKEN is required when ADMIN_ADDR is set")
+	}
+	if cfg.Metrics.Enabled && cfg.Metrics.Addr == "" {
+		return nil, fmt.Errorf("METRICS_ADDR is required when METRICS_ENABLED is set")
+	}
+	if cfg.Metrics.Enabled && !strings.HasPrefix(cfg.Metrics.Path, "/") {
+		return nil, fmt.Errorf("invalid METRICS_PATH %q: must start with /", cfg.Metrics.Path)
+	}
+
+	if err := cfg.Log.Level.UnmarshalText([]byte(getEnv("LOG_LEVEL", "info"))); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL: must be debug, info, warn or error: %w", err)
+	}
+	switch cfg.Log.Format {
+	case LogFormatText, LogFormatJSON:
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q: must be %q or %q", cfg.Log.Format, LogFormatText, LogFormatJSON)
+	}
+	switch cfg.Tracing.Exporter {
+	case TracesExporterNone, TracesExporterOTLP, TracesExporterConsole:
+	default:
+		return nil, fmt.Errorf("invalid OTEL_TRACES_EXPORTER %q: must be %q, %q or %q",
+			cfg.Tracing.Exporter, TracesExporterNone, TracesExporterOTLP, TracesExporterConsole)
+	}
+
+	var err error
+	if cfg.Middleware.TrustedProxies, err = middleware.ParseTrustedProxies(getEnv("TRUSTED_PROXIES", "")); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+
+	if cfg.Proxy.Upstreams, err = loadUpstreams(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.loadLimits(); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
 
-// loadTokenConfigs loads token-specific rate limit configurations
-func loadTokenConfigs(cfg *Config) {
+// ReloadLimits returns a copy of the configuration with the rate limits,
+// routes, bypass patterns and costs loaded again from the policy file and
+// the environment variables. The configuration is unchanged on error.
+func (c *Config) ReloadLimits() (*Config, error) {
+	reloaded := *c
+	if err := reloaded.loadLimits(); err != nil {
+		return nil, err
+	}
+	return &reloaded, nil
+}
+
+// CheckPolicyFile reports every error of a policy file with its line,
+// without the environment variables
+func CheckPolicyFile(path string) error {
+	cfg := &Config{}
+	cfg.setDefaultLimits()
+	return loadPolicyFile(cfg, path)
+}
+
+// LimiterConfig returns the rate limiter configuration of the IP and token
+// limits
+func (c *Config) LimiterConfig() limiter.Config {
+	return limiter.Config{
+		IPLimit:                   c.RateLimiter.IPLimit,
+		IPPeriod:                  c.RateLimiter.IPPeriod,
+		IPBlockDuration:           c.RateLimiter.IPBlockDuration,
+		IPAlgorithm:               c.RateLimiter.IPAlgorithm,
+		IPBurst:                   c.RateLimiter.IPBurst,
+		IPWindows:                 c.RateLimiter.IPWindows,
+		TokenLimits:               c.RateLimiter.TokenLimits,
+		DefaultTokenLimit:         c.RateLimiter.DefaultTokenLimit,
+		DefaultTokenPeriod:        c.RateLimiter.DefaultTokenPeriod,
+		DefaultTokenBlockDuration: c.RateLimiter.DefaultTokenBlockDuration,
+		DefaultTokenAlgorithm:     c.RateLimiter.DefaultTokenAlgorithm,
+		DefaultTokenBurst:         c.RateLimiter.DefaultTokenBurst,
+		DefaultTokenWindows:       c.RateLimiter.DefaultTokenWindows,
+	}
+}
+
+// Limits returns the rate limiter configuration of the global limits, for
+// an empty route, or of the named route, reporting false if no route has
+// that name
+func (c *Config) Limits(route string) (limiter.Config, bool) {
+	if route == "" {
+		return c.LimiterConfig(), true
+	}
+	for _, r := range c.RateLimiter.Routes {
+		if r.Name == route {
+			return r.LimiterConfig(), true
+		}
+	}
+	return limiter.Config{}, false
+}
+
+// LimiterConfig returns the rate limiter configuration of a route, applying
+// its limit to every client IP and token with keys of its own
+func (r RouteConfig) LimiterConfig() limiter.Config {
+	return limiter.Config{
+		IPLimit:                   r.Limit.Limit,
+		IPPeriod:                  r.Limit.Period,
+		IPBlockDuration:           r.Limit.BlockDuration,
+		IPAlgorithm:               r.Limit.Algorithm,
+		IPBurst:                   r.Limit.Burst,
+		IPWindows:                 r.Limit.Windows,
+		DefaultTokenLimit:         r.Limit.Limit,
+		DefaultTokenPeriod:        r.Limit.Period,
+		DefaultTokenBlockDuration: r.Limit.BlockDuration,
+		DefaultTokenAlgorithm:     r.Limit.Algorithm,
+		DefaultTokenBurst:         r.Limit.Burst,
+		DefaultTokenWindows:       r.Limit.Windows,
+		KeyPrefix:                 limiter.RouteKeyPrefix(r.Name),
+	}
+}
+
+// loadLimits loads the built-in limits, overridden by the policy file and
+// then by the environment variables
+func (c *Config) loadLimits() error {
+	c.setDefaultLimits()
+
+	if c.Policy.File != "" {
+		if err := loadPolicyFile(c, c.Policy.File); err != nil {
+			return err
+		}
+	}
+	return loadLimitsFromEnv(c)
+}
+
+// setDefaultLimits resets the limits, routes, bypass patterns and costs to
+// the built-in defaults
+func (c *Config) setDefaultLimits() {
+	c.RateLimiter = RateLimiterConfig{
+		IPLimit:                   10,
+		IPBlockDuration:           300 * time.Second,
+		IPAlgorithm:               limiter.FixedWindow,
+		DefaultTokenLimit:         100,
+		DefaultTokenBlockDuration: 300 * time.Second,
+		DefaultTokenAlgorithm:     limiter.FixedWindow,
+		TokenLimits:               make(map[string]limiter.TokenConfig),
+	}
+	c.Middleware.Bypass = nil
+	c.Middleware.RouteCosts = nil
+}
+
+// loadLimitsFromEnv overrides the rate limits with the environment
+// variables that are set
+func loadLimitsFromEnv(cfg *Config) error {
+	rl := &cfg.RateLimiter
+	var err error
+	if value := getEnv("IP_RATE_LIMIT", ""); value != "" {
+		if rl.IPLimit, rl.IPPeriod, err = parseRate(value); err != nil {
+			return fmt.Errorf("invalid IP_RATE_LIMIT: %w", err)
+		}
+	}
+	if value := getEnv("TOKEN_RATE_LIMIT", ""); value != "" {
+		if rl.DefaultTokenLimit, rl.DefaultTokenPeriod, err = parseRate(value); err != nil {
+			return fmt.Errorf("invalid TOKEN_RATE_LIMIT: %w", err)
+		}
+	}
+
+	rl.IPBlockDuration = time.Duration(getEnvAsInt("IP_BLOCK_DURATION", int(rl.IPBlockDuration/time.Second))) * time.Second
+	rl.IPBurst = getEnvAsInt("IP_BURST", rl.IPBurst)
+	rl.DefaultTokenBlockDuration = time.Duration(getEnvAsInt("TOKEN_BLOCK_DURATION", int(rl.DefaultTokenBlockDuration/time.Second))) * time.Second
+	rl.DefaultTokenBurst = getEnvAsInt("TOKEN_BURST", rl.DefaultTokenBurst)
+
+	if value := getEnv("IP_ALGORITHM", ""); value != "" {
+		if rl.IPAlgorithm, err = limiter.ParseAlgorithm(value); err != nil {
+			return fmt.Errorf("invalid IP_ALGORITHM: %w", err)
+		}
+	}
+	if value := getEnv("TOKEN_ALGORITHM", ""); value != "" {
+		if rl.DefaultTokenAlgorithm, err = limiter.ParseAlgorithm(value); err != nil {
+			return fmt.Errorf("invalid TOKEN_ALGORITHM: %w", err)
+		}
+	}
+
+	if value := getEnv("IP_WINDOWS", ""); value != "" {
+		if rl.IPWindows, err = parseWindows(value, rl.IPBlockDuration); err != nil {
+			return fmt.Errorf("invalid IP_WINDOWS: %w", err)
+		}
+	}
+	if value := getEnv("TOKEN_WINDOWS", ""); value != "" {
+		if rl.DefaultTokenWindows, err = parseWindows(value, rl.DefaultTokenBlockDuration); err != nil {
+			return fmt.Errorf("invalid TOKEN_WINDOWS: %w", err)
+		}
+	}
+
+	if value := getEnv("RATE_LIMIT_ROUTE_COSTS", ""); value != "" {
+		if cfg.Middleware.RouteCosts, err = middleware.ParseRouteCosts(value); err != nil {
+			return fmt.Errorf("invalid RATE_LIMIT_ROUTE_COSTS: %w", err)
+		}
+	}
+	cfg.Middleware.Bypass = getEnvAsList("RATE_LIMIT_BYPASS", cfg.Middleware.Bypass)
+
+	// Load token-specific configurations
+	if err := loadTokenConfigs(cfg); err != nil {
+		return err
+	}
+
+	// Load route-specific configurations
+	return loadRouteConfigs(cfg)
+}
+
+// loadTokenConfigs loads token-specific rate limit configurations. Invalid
+// additional windows are an error, as they would silently lift the daily or
+// minute limits of a token.
+func loadTokenConfigs(cfg *Config) error {
 	for _, env := range os.Environ() {
 		if strings.HasPrefix(env, "API_KEY_") {
 			parts := strings.SplitN(env, "=", 2)
@@ -80,28 +516,244 @@ func loadTokenConfigs(cfg *Config) {
 			// Extract token name (remove API_KEY_ prefix)
 			token := strings.TrimPrefix(parts[0], "API_KEY_")
 
-			// Parse value: format is "LIMIT:BLOCK_DURATION"
-			valueParts := strings.Split(parts[1], ":")
-			if len(valueParts) != 2 {
+			tokenConfig, err := parseLimit(parts[1])
+			if err != nil {
 				continue
 			}
 
-			limit, err := strconv.Atoi(valueParts[0])
-			if err != nil {
+			cfg.RateLimiter.TokenLimits[token] = tokenConfig
+		}
+	}
+
+	// Load additional windows of the configured tokens
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, "API_WINDOWS_") {
+			parts := strings.SplitN(env, "=", 2)
+			if len(parts) != 2 {
 				continue
 			}
 
-			blockDuration, err := strconv.Atoi(valueParts[1])
-			if err != nil {
+			token := strings.TrimPrefix(parts[0], "API_WINDOWS_")
+			tokenConfig, exists := cfg.RateLimiter.TokenLimits[token]
+			if !exists {
 				continue
 			}
 
-			cfg.RateLimiter.TokenLimits[token] = limiter.TokenConfig{
-				Limit:         limit,
-				BlockDuration: time.Duration(blockDuration) * time.Second,
+			windows, err := parseWindows(parts[1], tokenConfig.BlockDuration)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", parts[0], err)
 			}
+
+			tokenConfig.Windows = windows
+			cfg.RateLimiter.TokenLimits[token] = tokenConfig
 		}
 	}
+	return nil
+}
+
+// periodUnits maps the period units accepted without a count to durations
+var periodUnits = map[string]time.Duration{
+	"s":      time.Second,
+	"second": time.Second,
+	"m":      time.Minute,
+	"minute": time.Minute,
+	"h":      time.Hour,
+	"hour":   time.Hour,
+	"d":      24 * time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// loadRouteConfigs loads the route rules from ROUTE_LIMIT_<NAME> variables,
+// formatted as "PATTERN=LIMIT[/PERIOD]:BLOCK_DURATION[:ALGORITHM[:BURST]]",
+// and their additional windows from ROUTE_WINDOWS_<NAME>, replacing the
+// routes of the same name. Unlike token limits, invalid routes are an
+// error, as they would silently lift the limits of sensitive endpoints.
+func loadRouteConfigs(cfg *Config) error {
+	var routes []RouteConfig
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, "ROUTE_LIMIT_") {
+			continue
+		}
+		key, value, _ := strings.Cut(env, "=")
+		name := strings.TrimPrefix(key, "ROUTE_LIMIT_")
+
+		// Limits never contain '=', patterns might
+		i := strings.LastIndex(value, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid %s: expected PATTERN=LIMIT[/PERIOD]:BLOCK_DURATION", key)
+		}
+		limit, err := parseLimit(value[i+1:])
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		if limit.Windows, err = parseWindows(os.Getenv("ROUTE_WINDOWS_"+name), limit.BlockDuration); err != nil {
+			return fmt.Errorf("invalid ROUTE_WINDOWS_%s: %w", name, err)
+		}
+
+		routes = append(routes, RouteConfig{
+			Name:    name,
+			Pattern: strings.TrimSpace(value[:i]),
+			Limit:   limit,
+		})
+	}
+
+	// Environment order is unspecified
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Name < routes[j].Name
+	})
+	for _, route := range routes {
+		i := slices.IndexFunc(cfg.RateLimiter.Routes, func(r RouteConfig) bool { return r.Name == route.Name })
+		if i < 0 {
+			cfg.RateLimiter.Routes = append(cfg.RateLimiter.Routes, route)
+			continue
+		}
+		cfg.RateLimiter.Routes[i] = route
+	}
+
+	patterns := append([]string(nil), cfg.Middleware.Bypass...)
+	for _, route := range cfg.RateLimiter.Routes {
+		patterns = append(patterns, route.Pattern)
+	}
+	if err := middleware.CheckPatterns(patterns...); err != nil {
+		return fmt.Errorf("invalid route or bypass pattern: %w", err)
+	}
+	return nil
+}
+
+// parseLimit parses a "LIMIT[/PERIOD]:BLOCK_DURATION[:ALGORITHM[:BURST]]"
+// rule, with the block duration in seconds
+func parseLimit(s string) (limiter.TokenConfig, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return limiter.TokenConfig{}, fmt.Errorf("invalid limit %q: expected LIMIT[/PERIOD]:BLOCK_DURATION[:ALGORITHM[:BURST]]", s)
+	}
+
+	limit, period, err := parseRate(parts[0])
+	if err != nil {
+		return limiter.TokenConfig{}, err
+	}
+
+	blockDuration, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return limiter.TokenConfig{}, fmt.Errorf("invalid block duration %q: %w", parts[1], err)
+	}
+
+	cfg := limiter.TokenConfig{
+		Limit:         limit,
+		Period:        period,
+		BlockDuration: time.Duration(blockDuration) * time.Second,
+	}
+
+	if len(parts) > 2 {
+		if cfg.Algorithm, err = limiter.ParseAlgorithm(parts[2]); err != nil {
+			return limiter.TokenConfig{}, err
+		}
+	}
+
+	if len(parts) > 3 {
+		if cfg.Burst, err = strconv.Atoi(parts[3]); err != nil {
+			return limiter.TokenConfig{}, fmt.Errorf("invalid burst %q: %w", parts[3], err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// parsePeriod parses a window period, either a unit (s, m, h or d) or a
+// duration such as "10s", "1h30m" or "7d"
+func parsePeriod(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if period, ok := periodUnits[s]; ok {
+		return period, nil
+	}
+
+	var period time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid period %q", s)
+		}
+		period = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if period, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid period %q", s)
+		}
+	}
+
+	if period <= 0 {
+		return 0, fmt.Errorf("invalid period %q: must be positive", s)
+	}
+	return period, nil
+}
+
+// parseRate parses a positive limit in the format "LIMIT[/PERIOD]", e.g.
+// "100", "100/10s" or "5000/h". The period is zero, meaning one second, if
+// omitted.
+func parseRate(s string) (int, time.Duration, error) {
+	limitStr, periodStr, hasPeriod := strings.Cut(strings.TrimSpace(s), "/")
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid limit %q", limitStr)
+	}
+	if limit < 1 {
+		return 0, 0, fmt.Errorf("invalid limit %q: must be positive", limitStr)
+	}
+	if !hasPeriod {
+		return limit, 0, nil
+	}
+
+	period, err := parsePeriod(periodStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return limit, period, nil
+}
+
+// parseWindows parses a comma-separated list of windows in the format
+// "LIMIT/PERIOD[:BLOCK_DURATION]", e.g. "2000/m,100000/d:3600", where PERIOD
+// is a unit or a duration accepted by parsePeriod. Windows without a block
+// duration use defaultBlock. Periods must be distinct, as the windows of a
+// period share their storage key.
+func parseWindows(s string, defaultBlock time.Duration) ([]limiter.Window, error) {
+	var windows []limiter.Window
+	periods := make(map[time.Duration]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		spec, block, hasBlock := strings.Cut(item, ":")
+		if !strings.Contains(spec, "/") {
+			return nil, fmt.Errorf("invalid window %q: expected LIMIT/PERIOD", item)
+		}
+
+		limit, period, err := parseRate(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", item, err)
+		}
+		if periods[period] {
+			return nil, fmt.Errorf("invalid window %q: duplicate period %v", item, period)
+		}
+		periods[period] = true
+
+		window := limiter.Window{
+			Limit:         limit,
+			Period:        period,
+			BlockDuration: defaultBlock,
+		}
+		if hasBlock {
+			seconds, err := strconv.Atoi(block)
+			if err != nil || seconds < 0 {
+				return nil, fmt.Errorf("invalid window %q: invalid block duration", item)
+			}
+			window.BlockDuration = time.Duration(seconds) * time.Second
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
 }
 
 // getEnv gets an environment variable with a default value
@@ -127,3 +779,53 @@ func getEnvAsInt(key string, defaultValue int) int {
 
 	return value
 }
+
+// getEnvAsBool gets an environment variable as bool with a default value
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		fmt.Printf("Warning: invalid value for %s, using default %t\n", key, defaultValue)
+		return defaultValue
+	}
+
+	return value
+}
+
+// getEnvAsList gets a comma-separated environment variable as a list with a default value
+func getEnvAsList(key string, defaultValue []string) []string {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	var values []string
+	for _, value := range strings.Split(valueStr, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// loadUpstreams loads the upstream of every path from UPSTREAM_URL and the
+// upstreams of path prefixes from UPSTREAM_ROUTES, formatted as
+// "PREFIX=URL, PREFIX=URL"
+func loadUpstreams() ([]proxy.Upstream, error) {
+	upstreams, err := proxy.ParseUpstreams(getEnv("UPSTREAM_ROUTES", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid UPSTREAM_ROUTES: %w", err)
+	}
+	if rawURL := getEnv("UPSTREAM_URL", ""); rawURL != "" {
+		upstream, err := proxy.ParseUpstream("/", rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UPSTREAM_URL: %w", err)
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	return upstreams, nil
+}
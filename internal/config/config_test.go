@@ -0,0 +1,215 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+)
+
+func TestParseWindows(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []limiter.Window
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"2000/m", []limiter.Window{{Limit: 2000, Period: time.Minute, BlockDuration: time.Minute}}, false},
+		{"2000/minute:0, 100000/d:3600", []limiter.Window{
+			{Limit: 2000, Period: time.Minute},
+			{Limit: 100000, Period: 24 * time.Hour, BlockDuration: time.Hour},
+		}, false},
+		{"50/H", []limiter.Window{{Limit: 50, Period: time.Hour, BlockDuration: time.Minute}}, false},
+		{"2000", nil, true},
+		{"100/10s, 5000/1h30m, 7/7d", []limiter.Window{
+			{Limit: 100, Period: 10 * time.Second, BlockDuration: time.Minute},
+			{Limit: 5000, Period: 90 * time.Minute, BlockDuration: time.Minute},
+			{Limit: 7, Period: 7 * 24 * time.Hour, BlockDuration: time.Minute},
+		}, false},
+		{"2000/week", nil, true},
+		{"2000/0s", nil, true},
+		{"0/m", nil, true},
+		{"100/m, 2000/1m", nil, true},
+		{"abc/m", nil, true},
+		{"2000/m:abc", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseWindows(tt.value, time.Minute)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseWindows(%q): expected error %v, got %v", tt.value, tt.wantErr, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("parseWindows(%q): expected %v, got %v", tt.value, tt.want, got)
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Fatalf("parseWindows(%q): expected %v, got %v", tt.value, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value      string
+		wantLimit  int
+		wantPeriod time.Duration
+		wantErr    bool
+	}{
+		{"100", 100, 0, false},
+		{"100/10s", 100, 10 * time.Second, false},
+		{"5000/1h", 5000, time.Hour, false},
+		{"50/m", 50, time.Minute, false},
+		{"100/", 0, 0, true},
+		{"100/-1s", 0, 0, true},
+		{"abc", 0, 0, true},
+		{"0", 0, 0, true},
+		{"0/m", 0, 0, true},
+		{"-5/s", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		limit, period, err := parseRate(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseRate(%q): expected error %v, got %v", tt.value, tt.wantErr, err)
+		}
+		if limit != tt.wantLimit || period != tt.wantPeriod {
+			t.Fatalf("parseRate(%q): expected %d/%v, got %d/%v", tt.value, tt.wantLimit, tt.wantPeriod, limit, period)
+		}
+	}
+}
+
+func TestLoadTokenConfigs(t *testing.T) {
+	t.Setenv("API_KEY_legacy", "100:300")
+	t.Setenv("API_KEY_export", "5000/1h:600:sliding_window_log")
+	t.Setenv("API_KEY_invalid", "100/week:300")
+	t.Setenv("API_WINDOWS_legacy", "2000/m")
+
+	cfg := &Config{RateLimiter: RateLimiterConfig{TokenLimits: make(map[string]limiter.TokenConfig)}}
+	if err := loadTokenConfigs(cfg); err != nil {
+		t.Fatalf("loadTokenConfigs failed: %v", err)
+	}
+
+	legacy := cfg.RateLimiter.TokenLimits["legacy"]
+	if legacy.Limit != 100 || legacy.Period != 0 || legacy.BlockDuration != 300*time.Second {
+		t.Errorf("Expected legacy syntax to be parsed as 100 req/s, got %+v", legacy)
+	}
+	if len(legacy.Windows) != 1 || legacy.Windows[0].Period != time.Minute || legacy.Windows[0].BlockDuration != 300*time.Second {
+		t.Errorf("Expected a minute window blocking for 300s, got %+v", legacy.Windows)
+	}
+
+	export := cfg.RateLimiter.TokenLimits["export"]
+	if export.Limit != 5000 || export.Period != time.Hour || export.Algorithm != limiter.SlidingWindowLog {
+		t.Errorf("Expected 5000 req/h with the sliding log, got %+v", export)
+	}
+
+	if _, exists := cfg.RateLimiter.TokenLimits["invalid"]; exists {
+		t.Error("Expected invalid token configuration to be skipped")
+	}
+}
+
+func TestLoadTokenConfigs_InvalidWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows string
+	}{
+		{name: "duplicate period", windows: "2000/m,10000/d,3000/1m"},
+		{name: "typo", windows: "2000/m,10000/dya"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("API_KEY_partner", "100:300")
+			t.Setenv("API_WINDOWS_partner", tt.windows)
+
+			cfg := &Config{RateLimiter: RateLimiterConfig{TokenLimits: make(map[string]limiter.TokenConfig)}}
+			err := loadTokenConfigs(cfg)
+			if err == nil || !strings.Contains(err.Error(), "API_WINDOWS_partner") {
+				t.Fatalf("Expected an error naming API_WINDOWS_partner, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadRouteConfigs(t *testing.T) {
+	t.Setenv("ROUTE_LIMIT_products", "GET /products=100:0")
+	t.Setenv("ROUTE_LIMIT_login", "POST /login=5/m:300")
+	t.Setenv("ROUTE_WINDOWS_login", "20/h")
+
+	cfg := &Config{Middleware: MiddlewareConfig{Bypass: []string{"GET /health"}}}
+	if err := loadRouteConfigs(cfg); err != nil {
+		t.Fatalf("loadRouteConfigs failed: %v", err)
+	}
+
+	routes := cfg.RateLimiter.Routes
+	if len(routes) != 2 {
+		t.Fatalf("Expected 2 routes, got %+v", routes)
+	}
+	login := routes[0]
+	if login.Name != "login" || login.Pattern != "POST /login" || login.Limit.Limit != 5 || login.Limit.Period != time.Minute {
+		t.Errorf("Expected POST /login limited to 5 req/min, got %+v", login)
+	}
+	if len(login.Limit.Windows) != 1 || login.Limit.Windows[0].Period != time.Hour || login.Limit.Windows[0].BlockDuration != 300*time.Second {
+		t.Errorf("Expected an hour window blocking for 300s, got %+v", login.Limit.Windows)
+	}
+	if products := routes[1]; products.Pattern != "GET /products" || products.Limit.Limit != 100 || products.Limit.Period != 0 {
+		t.Errorf("Expected GET /products limited to 100 req/s, got %+v", products)
+	}
+
+	// Test: Invalid and conflicting routes are errors
+	t.Setenv("ROUTE_LIMIT_health", "GET /health=1:0")
+	if err := loadRouteConfigs(&Config{Middleware: MiddlewareConfig{Bypass: []string{"GET /health"}}}); err == nil {
+		t.Error("Expected error for a route conflicting with a bypass pattern")
+	}
+	t.Setenv("ROUTE_LIMIT_health", "GET /health")
+	if err := loadRouteConfigs(&Config{}); err == nil {
+		t.Error("Expected error for a route without limit")
+	}
+}
+
+func TestConfig_Limits(t *testing.T) {
+	cfg := &Config{RateLimiter: RateLimiterConfig{
+		IPLimit:   10,
+		IPWindows: []limiter.Window{{Limit: 1000, Period: 24 * time.Hour}},
+		Routes: []RouteConfig{{
+			Name:    "login",
+			Pattern: "POST /login",
+			Limit:   limiter.TokenConfig{Limit: 5, Period: time.Minute, Windows: []limiter.Window{{Limit: 20, Period: time.Hour}}},
+		}},
+	}}
+
+	global, ok := cfg.Limits("")
+	if !ok || global.IPLimit != 10 || len(global.IPWindows) != 1 || global.KeyPrefix != "" {
+		t.Errorf("Expected the global limits, got %+v (%v)", global, ok)
+	}
+
+	login, ok := cfg.Limits("login")
+	if !ok || login.IPLimit != 5 || len(login.IPWindows) != 1 || login.KeyPrefix != limiter.RouteKeyPrefix("login") {
+		t.Errorf("Expected the limits of the login route, got %+v (%v)", login, ok)
+	}
+
+	if _, ok := cfg.Limits("unknown"); ok {
+		t.Error("Expected no limits for an unknown route")
+	}
+}
+
+func TestLoadUpstreams(t *testing.T) {
+	t.Setenv("UPSTREAM_URL", "http://legacy:8080")
+	t.Setenv("UPSTREAM_ROUTES", "/api=http://api:8080, /auth=http://auth:9000")
+
+	upstreams, err := loadUpstreams()
+	if err != nil {
+		t.Fatalf("loadUpstreams failed: %v", err)
+	}
+	if len(upstreams) != 3 || upstreams[0].Prefix != "/api" || upstreams[2].Prefix != "/" || upstreams[2].URL.Host != "legacy:8080" {
+		t.Errorf("Expected the routes then the default upstream, got %v", upstreams)
+	}
+
+	// Test: Invalid URLs are errors
+	t.Setenv("UPSTREAM_URL", "legacy:8080")
+	if _, err := loadUpstreams(); err == nil {
+		t.Error("Expected error for a URL without scheme")
+	}
+}
@@ -0,0 +1,459 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+	"github.com/allis/rate-limiter/internal/middleware"
+	"gopkg.in/yaml.v3"
+)
+
+// policyVersion is the schema version of the policy file
+const policyVersion = 1
+
+// policy is the schema of the policy file, in YAML or JSON. See
+// policy.example.yaml for a documented example.
+type policy struct {
+	Version int                   `yaml:"version"`
+	IP      *policyRule           `yaml:"ip"`
+	Token   *policyRule           `yaml:"token"`
+	Tiers   map[string]policyRule `yaml:"tiers"`
+	Tokens  map[string]policyRule `yaml:"tokens"`
+	Routes  []policyRoute         `yaml:"routes"`
+	Bypass  []policyString        `yaml:"bypass"`
+	Costs   []policyCost          `yaml:"costs"`
+}
+
+// policyRule is a limit of the policy file. Fields set along with a tier
+// override the ones of the tier.
+type policyRule struct {
+	Tier      *policyString    `yaml:"tier"`
+	Rate      *policyRate      `yaml:"rate"`
+	Block     *policyDuration  `yaml:"block"`
+	Algorithm *policyAlgorithm `yaml:"algorithm"`
+	Burst     *int             `yaml:"burst"`
+	Windows   []policyWindow   `yaml:"windows"`
+	line      int
+}
+
+// ruleFields are the fields of a policyRule
+var ruleFields = []string{"tier", "rate", "block", "algorithm", "burst", "windows"}
+
+// policyWindow is an additional window of a rule
+type policyWindow struct {
+	Rate  *policyRate     `yaml:"rate"`
+	Block *policyDuration `yaml:"block"`
+	Burst int             `yaml:"burst"`
+	line  int
+}
+
+// policyRoute is the rule of the requests matching a pattern, with the
+// fields of the rule next to the name and pattern
+type policyRoute struct {
+	Name    policyString `yaml:"name"`
+	Pattern policyString `yaml:"pattern"`
+	Limit   policyRule   `yaml:"-"`
+	line    int
+}
+
+// policyCost is the cost of the requests matching a method and path prefix
+type policyCost struct {
+	Method string       `yaml:"method"`
+	Path   policyString `yaml:"path"`
+	Cost   int          `yaml:"cost"`
+}
+
+// policyString is a string remembering its line for later validation
+type policyString struct {
+	value string
+	line  int
+}
+
+// policyRate is a "LIMIT[/PERIOD]" rate
+type policyRate struct {
+	limit  int
+	period time.Duration
+}
+
+// policyDuration is a duration such as "30s", "5m" or "1d"
+type policyDuration time.Duration
+
+// policyAlgorithm is the name of a rate limiting algorithm
+type policyAlgorithm limiter.Algorithm
+
+// loadPolicyFile applies the policy file at path to cfg. Every error of the
+// file is reported with its line.
+func loadPolicyFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var p policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	if err := p.apply(cfg); err != nil {
+		return fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return nil
+}
+
+// apply validates the rules of the policy and maps them into cfg. The ip
+// and token sections only override the fields they set.
+func (p *policy) apply(cfg *Config) error {
+	var errs []error
+	fail := func(line int, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...)))
+	}
+
+	for _, name := range sortedKeys(p.Tiers) {
+		tier := p.Tiers[name]
+		if tier.Tier != nil {
+			fail(tier.Tier.line, "tier %q cannot refer to another tier", name)
+		}
+		if tier.Rate == nil {
+			fail(tier.line, "tier %q: rate is required", name)
+		}
+	}
+
+	rl := &cfg.RateLimiter
+	if p.IP != nil {
+		ip, err := p.resolve(p.IP, limiter.TokenConfig{
+			Limit:         rl.IPLimit,
+			Period:        rl.IPPeriod,
+			BlockDuration: rl.IPBlockDuration,
+			Algorithm:     rl.IPAlgorithm,
+			Burst:         rl.IPBurst,
+			Windows:       rl.IPWindows,
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+		rl.IPLimit, rl.IPPeriod, rl.IPBlockDuration = ip.Limit, ip.Period, ip.BlockDuration
+		rl.IPAlgorithm, rl.IPBurst, rl.IPWindows = ip.Algorithm, ip.Burst, ip.Windows
+	}
+	if p.Token != nil {
+		token, err := p.resolve(p.Token, limiter.TokenConfig{
+			Limit:         rl.DefaultTokenLimit,
+			Period:        rl.DefaultTokenPeriod,
+			BlockDuration: rl.DefaultTokenBlockDuration,
+			Algorithm:     rl.DefaultTokenAlgorithm,
+			Burst:         rl.DefaultTokenBurst,
+			Windows:       rl.DefaultTokenWindows,
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+		rl.DefaultTokenLimit, rl.DefaultTokenPeriod, rl.DefaultTokenBlockDuration = token.Limit, token.Period, token.BlockDuration
+		rl.DefaultTokenAlgorithm, rl.DefaultTokenBurst, rl.DefaultTokenWindows = token.Algorithm, token.Burst, token.Windows
+	}
+
+	for _, name := range sortedKeys(p.Tokens) {
+		rule := p.Tokens[name]
+		tokenConfig, err := p.resolveRequired(&rule)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rl.TokenLimits[name] = tokenConfig
+	}
+
+	// Patterns are checked one at a time to locate conflicts
+	var patterns []string
+	checkPattern := func(pattern policyString) {
+		if err := middleware.CheckPatterns(append(patterns, pattern.value)...); err != nil {
+			fail(pattern.line, "%v", err)
+			return
+		}
+		patterns = append(patterns, pattern.value)
+	}
+
+	if p.Bypass != nil {
+		cfg.Middleware.Bypass = nil
+	}
+	for _, pattern := range p.Bypass {
+		checkPattern(pattern)
+		cfg.Middleware.Bypass = append(cfg.Middleware.Bypass, pattern.value)
+	}
+
+	names := make(map[string]bool)
+	if p.Routes != nil {
+		rl.Routes = nil
+	}
+	for _, route := range p.Routes {
+		switch {
+		case route.Name.value == "":
+			fail(route.line, "route name is required")
+			continue
+		case names[route.Name.value]:
+			fail(route.Name.line, "duplicate route name %q", route.Name.value)
+			continue
+		case route.Pattern.value == "":
+			fail(route.line, "route pattern is required")
+			continue
+		}
+		names[route.Name.value] = true
+		checkPattern(route.Pattern)
+
+		limit, err := p.resolveRequired(&route.Limit)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rl.Routes = append(rl.Routes, RouteConfig{
+			Name:    route.Name.value,
+			Pattern: route.Pattern.value,
+			Limit:   limit,
+		})
+	}
+
+	if p.Costs != nil {
+		cfg.Middleware.RouteCosts = nil
+	}
+	for _, cost := range p.Costs {
+		cfg.Middleware.RouteCosts = append(cfg.Middleware.RouteCosts, middleware.RouteCost{
+			Method: strings.ToUpper(cost.Method),
+			Path:   cost.Path.value,
+			Cost:   cost.Cost,
+		})
+	}
+
+	return errors.Join(errs...)
+}
+
+// resolveRequired resolves a rule of the tokens or routes sections, which
+// must set a rate directly or through a tier
+func (p *policy) resolveRequired(rule *policyRule) (limiter.TokenConfig, error) {
+	if rule.Rate == nil && rule.Tier == nil {
+		return limiter.TokenConfig{}, fmt.Errorf("line %d: rate or tier is required", rule.line)
+	}
+	return p.resolve(rule, limiter.TokenConfig{})
+}
+
+// resolve applies the tier of a rule and then the rule itself to base
+func (p *policy) resolve(rule *policyRule, base limiter.TokenConfig) (limiter.TokenConfig, error) {
+	if rule.Tier != nil {
+		tier, exists := p.Tiers[rule.Tier.value]
+		if !exists {
+			return base, fmt.Errorf("line %d: unknown tier %q", rule.Tier.line, rule.Tier.value)
+		}
+		var err error
+		if base, err = tier.overlay(base); err != nil {
+			return base, err
+		}
+	}
+	return rule.overlay(base)
+}
+
+// overlay returns base with the fields set by the rule. Windows without a
+// block duration use the one of the rule. It returns an error for windows
+// of the same period, which would share their storage key.
+func (r *policyRule) overlay(base limiter.TokenConfig) (limiter.TokenConfig, error) {
+	if r.Rate != nil {
+		base.Limit, base.Period = r.Rate.limit, r.Rate.period
+	}
+	if r.Block != nil {
+		base.BlockDuration = time.Duration(*r.Block)
+	}
+	if r.Algorithm != nil {
+		base.Algorithm = limiter.Algorithm(*r.Algorithm)
+	}
+	if r.Burst != nil {
+		base.Burst = *r.Burst
+	}
+	if r.Windows != nil {
+		base.Windows = make([]limiter.Window, 0, len(r.Windows))
+		periods := make(map[time.Duration]bool, len(r.Windows))
+		for _, w := range r.Windows {
+			if periods[w.Rate.period] {
+				return base, fmt.Errorf("line %d: duplicate window period %v", w.line, w.Rate.period)
+			}
+			periods[w.Rate.period] = true
+			window := limiter.Window{
+				Limit:         w.Rate.limit,
+				Period:        w.Rate.period,
+				BlockDuration: base.BlockDuration,
+				Burst:         w.Burst,
+			}
+			if w.Block != nil {
+				window.BlockDuration = time.Duration(*w.Block)
+			}
+			base.Windows = append(base.Windows, window)
+		}
+	}
+	return base, nil
+}
+
+// sortedKeys returns the keys of a section in order, so errors are reported
+// in a stable order
+func sortedKeys(rules map[string]policyRule) []string {
+	keys := make([]string, 0, len(rules))
+	for key := range rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// policyError returns an error located at a node. The YAML decoder collects
+// such errors and reports them all together.
+func policyError(node *yaml.Node, format string, args ...any) error {
+	return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: %s", node.Line, fmt.Sprintf(format, args...))}}
+}
+
+// decodeFields decodes a mapping node into v, reporting its unknown keys
+// along with the errors of the known fields
+func decodeFields(node *yaml.Node, v any, fields ...string) error {
+	if node.Kind != yaml.MappingNode {
+		return policyError(node, "expected a mapping")
+	}
+
+	var errs []string
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if !slices.Contains(fields, key.Value) {
+			errs = append(errs, fmt.Sprintf("line %d: unknown field %q", key.Line, key.Value))
+		}
+	}
+
+	if err := node.Decode(v); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return err
+		}
+		errs = append(errs, typeErr.Errors...)
+	}
+	if len(errs) > 0 {
+		return &yaml.TypeError{Errors: errs}
+	}
+	return nil
+}
+
+// UnmarshalYAML decodes the policy, rejecting unknown fields
+func (p *policy) UnmarshalYAML(node *yaml.Node) error {
+	type plain policy
+	if err := decodeFields(node, (*plain)(p), "version", "ip", "token", "tiers", "tokens", "routes", "bypass", "costs"); err != nil {
+		return err
+	}
+	if p.Version != 0 && p.Version != policyVersion {
+		return policyError(node, "unsupported version %d, expected %d", p.Version, policyVersion)
+	}
+	return nil
+}
+
+// UnmarshalYAML decodes a rule, rejecting unknown fields
+func (r *policyRule) UnmarshalYAML(node *yaml.Node) error {
+	type plain policyRule
+	r.line = node.Line
+	return decodeFields(node, (*plain)(r), ruleFields...)
+}
+
+// UnmarshalYAML decodes a window, which requires a rate
+func (w *policyWindow) UnmarshalYAML(node *yaml.Node) error {
+	type plain policyWindow
+	if err := decodeFields(node, (*plain)(w), "rate", "block", "burst"); err != nil {
+		return err
+	}
+	if w.Rate == nil {
+		return policyError(node, "window rate is required")
+	}
+	w.line = node.Line
+	return nil
+}
+
+// UnmarshalYAML decodes a route, rejecting unknown fields
+func (r *policyRoute) UnmarshalYAML(node *yaml.Node) error {
+	r.line = node.Line
+	r.Limit.line = node.Line
+
+	// Each decoding ignores the fields of the other
+	type plainRule policyRule
+	ruleErr := node.Decode((*plainRule)(&r.Limit))
+	type plain policyRoute
+	err := decodeFields(node, (*plain)(r), append([]string{"name", "pattern"}, ruleFields...)...)
+
+	ruleTypeErr, ok := ruleErr.(*yaml.TypeError)
+	switch {
+	case ruleErr == nil:
+		return err
+	case !ok:
+		return ruleErr
+	}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		ruleTypeErr.Errors = append(typeErr.Errors, ruleTypeErr.Errors...)
+	} else if err != nil {
+		return err
+	}
+	return ruleTypeErr
+}
+
+// UnmarshalYAML decodes a route cost, which requires a path and a cost
+func (c *policyCost) UnmarshalYAML(node *yaml.Node) error {
+	type plain policyCost
+	if err := decodeFields(node, (*plain)(c), "method", "path", "cost"); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(c.Path.value, "/") {
+		return policyError(node, "cost path must start with /")
+	}
+	if c.Cost < 1 {
+		return policyError(node, "cost must be a positive integer")
+	}
+	return nil
+}
+
+// UnmarshalYAML decodes a scalar string
+func (s *policyString) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return policyError(node, "expected a string")
+	}
+	s.value, s.line = node.Value, node.Line
+	return nil
+}
+
+// UnmarshalYAML parses a rate such as 100, "100/m" or "5000/1h"
+func (r *policyRate) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return policyError(node, "expected a rate such as 100/m")
+	}
+	limit, period, err := parseRate(node.Value)
+	if err != nil {
+		return policyError(node, "invalid rate %q: %v", node.Value, err)
+	}
+	r.limit, r.period = limit, period
+	return nil
+}
+
+// UnmarshalYAML parses a duration such as 0, "30s", "5m" or "1d"
+func (d *policyDuration) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return policyError(node, "expected a duration such as 5m")
+	}
+	if node.Value == "0" {
+		*d = 0
+		return nil
+	}
+	duration, err := parsePeriod(node.Value)
+	if err != nil {
+		return policyError(node, "invalid duration %q: %v", node.Value, err)
+	}
+	*d = policyDuration(duration)
+	return nil
+}
+
+// UnmarshalYAML parses an algorithm name
+func (a *policyAlgorithm) UnmarshalYAML(node *yaml.Node) error {
+	algorithm, err := limiter.ParseAlgorithm(node.Value)
+	if err != nil || node.Kind != yaml.ScalarNode {
+		return policyError(node, "invalid algorithm %q", node.Value)
+	}
+	*a = policyAlgorithm(algorithm)
+	return nil
+}
@@ -0,0 +1,328 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+)
+
+// newTestConfig returns a configuration with the built-in limits
+func newTestConfig() *Config {
+	return &Config{
+		RateLimiter: RateLimiterConfig{
+			IPLimit:                   10,
+			IPBlockDuration:           300 * time.Second,
+			DefaultTokenLimit:         100,
+			DefaultTokenBlockDuration: 300 * time.Second,
+			TokenLimits:               make(map[string]limiter.TokenConfig),
+		},
+	}
+}
+
+// writePolicy writes a policy file in a temporary directory
+func writePolicy(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write policy file: %v", err)
+	}
+	return path
+}
+
+func TestLoadPolicyFile(t *testing.T) {
+	cfg := newTestConfig()
+	if err := loadPolicyFile(cfg, "../../policy.example.yaml"); err != nil {
+		t.Fatalf("loadPolicyFile failed: %v", err)
+	}
+	rl := cfg.RateLimiter
+
+	if rl.IPLimit != 10 || rl.IPPeriod != time.Second || len(rl.IPWindows) != 2 {
+		t.Errorf("Expected 10 req/s per IP with 2 windows, got %d/%v with %+v", rl.IPLimit, rl.IPPeriod, rl.IPWindows)
+	}
+	if day := rl.IPWindows[1]; day.Limit != 100000 || day.Period != 24*time.Hour || day.BlockDuration != time.Hour {
+		t.Errorf("Expected a day window blocking for 1h, got %+v", day)
+	}
+	if minute := rl.IPWindows[0]; minute.BlockDuration != 5*time.Minute {
+		t.Errorf("Expected the minute window to use the rule block, got %+v", minute)
+	}
+
+	if free := rl.TokenLimits["abc123"]; free.Limit != 100 || free.Period != time.Minute || free.BlockDuration != time.Minute {
+		t.Errorf("Expected the free tier for abc123, got %+v", free)
+	}
+	premium := rl.TokenLimits["xyz789"]
+	if premium.Limit != 1000 || premium.Algorithm != limiter.TokenBucket || premium.Burst != 5000 || premium.BlockDuration != 0 {
+		t.Errorf("Expected the premium tier with a burst of 5000, got %+v", premium)
+	}
+
+	if len(rl.Routes) != 2 || rl.Routes[0].Name != "login" || rl.Routes[0].Pattern != "POST /login" {
+		t.Fatalf("Expected the login and products routes, got %+v", rl.Routes)
+	}
+	if login := rl.Routes[0].Limit; login.Limit != 5 || login.Period != time.Minute || len(login.Windows) != 1 {
+		t.Errorf("Expected 5 req/min with an hour window for login, got %+v", login)
+	}
+
+	if len(cfg.Middleware.Bypass) != 2 || cfg.Middleware.Bypass[0] != "GET /health" {
+		t.Errorf("Expected 2 bypass patterns, got %v", cfg.Middleware.Bypass)
+	}
+	if len(cfg.Middleware.RouteCosts) != 2 || cfg.Middleware.RouteCosts[0].Cost != 50 {
+		t.Errorf("Expected 2 route costs, got %+v", cfg.Middleware.RouteCosts)
+	}
+}
+
+func TestLoadPolicyFile_JSON(t *testing.T) {
+	path := writePolicy(t, "policy.json", `{
+	"version": 1,
+	"ip": {"rate": "50/m"},
+	"tokens": {"abc123": {"rate": 200, "block": "10m"}},
+	"routes": [{"name": "login", "pattern": "POST /login", "rate": "5/m"}]
+}`)
+
+	cfg := newTestConfig()
+	if err := loadPolicyFile(cfg, path); err != nil {
+		t.Fatalf("loadPolicyFile failed: %v", err)
+	}
+
+	// Test: Unset fields of the ip section keep their defaults
+	rl := cfg.RateLimiter
+	if rl.IPLimit != 50 || rl.IPPeriod != time.Minute || rl.IPBlockDuration != 300*time.Second {
+		t.Errorf("Expected 50 req/min per IP blocking for 300s, got %+v", rl)
+	}
+	if token := rl.TokenLimits["abc123"]; token.Limit != 200 || token.BlockDuration != 10*time.Minute {
+		t.Errorf("Expected 200 req/s for abc123 blocking for 10m, got %+v", token)
+	}
+	if len(rl.Routes) != 1 || rl.Routes[0].Limit.Limit != 5 {
+		t.Errorf("Expected the login route, got %+v", rl.Routes)
+	}
+}
+
+func TestLoadPolicyFile_Errors(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", `version: 1
+ip:
+  rate: 10/week
+  blok: 5m
+tokens:
+  abc123:
+    tier: gold
+  xyz789:
+    block: 1m
+routes:
+  - name: login
+    pattern: POST /login
+    rate: 5/m
+  - name: signin
+    pattern: POST /login
+    rate: 5/m
+    algorithm: leaky_bucket
+tiers:
+  free:
+    rate: 0/m
+`)
+
+	err := loadPolicyFile(newTestConfig(), path)
+	if err == nil {
+		t.Fatal("Expected errors for the invalid policy")
+	}
+	for _, want := range []string{
+		`line 3: invalid rate "10/week"`,
+		`line 4: unknown field "blok"`,
+		`line 17: invalid algorithm "leaky_bucket"`,
+		`line 20: invalid rate "0/m": invalid limit "0": must be positive`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got %v", want, err)
+		}
+	}
+
+	path = writePolicy(t, "policy.yaml", `tokens:
+  abc123:
+    tier: gold
+  xyz789:
+    block: 1m
+routes:
+  - name: login
+    pattern: POST /login
+    rate: 5/m
+  - name: signin
+    pattern: POST /login
+    rate: 5/m
+token:
+  rate: 100/s
+  windows:
+    - rate: 1000/m
+    - rate: 2000/1m
+`)
+
+	err = loadPolicyFile(newTestConfig(), path)
+	if err == nil {
+		t.Fatal("Expected errors for the invalid rules")
+	}
+	for _, want := range []string{
+		`line 3: unknown tier "gold"`,
+		`line 5: rate or tier is required`,
+		`line 11: pattern "POST /login"`,
+		`line 17: duplicate window period 1m0s`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got %v", want, err)
+		}
+	}
+}
+
+func TestLoadLimitsFromEnv(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", `ip:
+  rate: 50/m
+  block: 1m
+tokens:
+  abc123:
+    rate: 100
+routes:
+  - name: login
+    pattern: POST /login
+    rate: 5/m
+bypass: [GET /health]
+`)
+	t.Setenv("IP_BLOCK_DURATION", "30")
+	t.Setenv("API_KEY_abc123", "500:0")
+	t.Setenv("ROUTE_LIMIT_login", "POST /login=3/m:60")
+
+	cfg := newTestConfig()
+	if err := loadPolicyFile(cfg, path); err != nil {
+		t.Fatalf("loadPolicyFile failed: %v", err)
+	}
+	if err := loadLimitsFromEnv(cfg); err != nil {
+		t.Fatalf("loadLimitsFromEnv failed: %v", err)
+	}
+
+	// Test: Environment variables override the policy file
+	rl := cfg.RateLimiter
+	if rl.IPLimit != 50 || rl.IPPeriod != time.Minute || rl.IPBlockDuration != 30*time.Second {
+		t.Errorf("Expected 50 req/min per IP blocking for 30s, got %+v", rl)
+	}
+	if token := rl.TokenLimits["abc123"]; token.Limit != 500 {
+		t.Errorf("Expected API_KEY_abc123 to override the policy, got %+v", token)
+	}
+	if len(rl.Routes) != 1 || rl.Routes[0].Limit.Limit != 3 {
+		t.Errorf("Expected ROUTE_LIMIT_login to replace the policy route, got %+v", rl.Routes)
+	}
+	if len(cfg.Middleware.Bypass) != 1 {
+		t.Errorf("Expected the bypass of the policy, got %v", cfg.Middleware.Bypass)
+	}
+}
+
+func TestLoadLimitsFromEnv_ZeroLimit(t *testing.T) {
+	for _, env := range []string{"IP_RATE_LIMIT", "TOKEN_RATE_LIMIT"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, "0/m")
+			if err := loadLimitsFromEnv(newTestConfig()); err == nil || !strings.Contains(err.Error(), "must be positive") {
+				t.Errorf("Expected error for a zero limit, got %v", err)
+			}
+		})
+	}
+
+	t.Setenv("ROUTE_LIMIT_login", "POST /login=0:60")
+	if err := loadLimitsFromEnv(newTestConfig()); err == nil {
+		t.Error("Expected error for a zero route limit")
+	}
+}
+
+func TestConfig_ReloadLimits(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", "tokens:\n  abc123:\n    rate: 100\n")
+	cfg := &Config{Policy: PolicyConfig{File: path}}
+	if err := cfg.loadLimits(); err != nil {
+		t.Fatalf("loadLimits failed: %v", err)
+	}
+
+	// Test: Reload reads the changed policy file
+	if err := os.WriteFile(path, []byte("tokens:\n  abc123:\n    rate: 500\n  xyz789:\n    rate: 50\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write policy file: %v", err)
+	}
+	reloaded, err := cfg.ReloadLimits()
+	if err != nil {
+		t.Fatalf("ReloadLimits failed: %v", err)
+	}
+	if reloaded.RateLimiter.TokenLimits["abc123"].Limit != 500 || len(reloaded.RateLimiter.TokenLimits) != 2 {
+		t.Errorf("Expected the reloaded tokens, got %+v", reloaded.RateLimiter.TokenLimits)
+	}
+	if cfg.RateLimiter.TokenLimits["abc123"].Limit != 100 {
+		t.Errorf("Expected the previous configuration to be unchanged, got %+v", cfg.RateLimiter.TokenLimits)
+	}
+
+	// Test: Invalid policy fails the reload
+	if err := os.WriteFile(path, []byte("tokens:\n  abc123:\n    rate: fast\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write policy file: %v", err)
+	}
+	if _, err := reloaded.ReloadLimits(); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected error at line 3, got %v", err)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", "version: 1\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan struct{}, 10)
+	var logs syncBuffer
+	go WatchFile(ctx, path, 10*time.Millisecond, slog.New(slog.NewTextHandler(&logs, nil)), func() { changes <- struct{}{} })
+
+	// Test: Unchanged file is not reported
+	select {
+	case <-changes:
+		t.Fatal("Expected no change before the file is written")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Test: File replaced by a rename is reported once
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte("version: 1\nip:\n  rate: 5\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("Failed to rename file: %v", err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the change to be reported")
+	}
+	select {
+	case <-changes:
+		t.Fatal("Expected a single change")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Test: Read errors are logged once
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := strings.Count(logs.String(), `msg="Failed to read watched file"`); n != 1 {
+		t.Errorf("Expected the read error logged once, got %d in %q", n, logs.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// WatchFile calls onChange whenever the content of the file at path changes,
+// checking it every interval until ctx is done. Files replaced by a rename,
+// as many editors and Kubernetes ConfigMaps do, are detected as well. Read
+// errors are logged once to logger and the file is checked again at the
+// next interval.
+func WatchFile(ctx context.Context, path string, interval time.Duration, logger *slog.Logger, onChange func()) {
+	last, err := os.ReadFile(path)
+	failing := err != nil
+	if failing {
+		logger.WarnContext(ctx, "Failed to read watched file", "path", path, "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			if !failing {
+				logger.WarnContext(ctx, "Failed to read watched file", "path", path, "error", err)
+			}
+			failing = true
+			continue
+		}
+		failing = false
+		if bytes.Equal(content, last) {
+			continue
+		}
+
+		last = content
+		onChange()
+	}
+}
@@ -0,0 +1,36 @@
+package limiter
+
+import "time"
+
+// Rule names reported in a Decision
+const (
+	RuleIP           = "ip"
+	RuleToken        = "token"
+	RuleDefaultToken = "token_default"
+)
+
+// Decision holds the outcome of a rate limit check
+type Decision struct {
+	// Allowed reports whether the request may proceed
+	Allowed bool
+	// Limit is the number of requests allowed per window by the matched rule
+	Limit int
+	// Window is the period of the window that rejected the request or, when
+	// allowed, of the window with the fewest remaining requests
+	Window time.Duration
+	// Remaining is the number of requests still allowed, in units
+	Remaining int
+	// ResetAt is when the limit is fully available again
+	ResetAt time.Time
+	// RetryAfter is how long the client should wait before retrying, zero
+	// when the request was allowed
+	RetryAfter time.Duration
+	// Cost is the number of units the request consumed
+	Cost int
+	// Blocked reports whether the identity is under a penalty block
+	Blocked bool
+	// Rule names the configuration that was applied
+	Rule string
+	// Key is the storage key identifying the client, e.g. "ip:192.168.1.1"
+	Key string
+}
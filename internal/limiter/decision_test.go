@@ -0,0 +1,225 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/storage"
+)
+
+func TestRateLimiter_CheckIP(t *testing.T) {
+	config := Config{
+		IPLimit:         2,
+		IPBlockDuration: 10 * time.Second,
+	}
+	rl := NewRateLimiter(NewEvaluatorStorage(), config)
+	now := time.Unix(1700000000, 0)
+	rl.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Test: Allowed decision reports the remaining requests
+	decision, err := rl.CheckIP(ctx, "192.168.1.1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !decision.Allowed || decision.Limit != 2 || decision.Remaining != 1 || decision.Blocked {
+		t.Fatalf("Expected allowed decision with 1 of 2 remaining, got %+v", decision)
+	}
+	if decision.Rule != RuleIP || decision.Key != "ip:192.168.1.1" {
+		t.Fatalf("Expected rule %q and key ip:192.168.1.1, got %q and %q", RuleIP, decision.Rule, decision.Key)
+	}
+	if decision.RetryAfter != 0 || decision.ResetAt.Before(now) || decision.ResetAt.After(now.Add(time.Second)) {
+		t.Fatalf("Expected reset within the window and no retry after, got %+v", decision)
+	}
+
+	rl.CheckIP(ctx, "192.168.1.1")
+
+	// Test: Rejected decision reports the block
+	decision, err = rl.CheckIP(ctx, "192.168.1.1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if decision.Allowed || !decision.Blocked || decision.Remaining != 0 {
+		t.Fatalf("Expected blocked decision, got %+v", decision)
+	}
+	if decision.RetryAfter != 10*time.Second || !decision.ResetAt.Equal(now.Add(10*time.Second)) {
+		t.Fatalf("Expected 10s retry after and reset, got %+v", decision)
+	}
+}
+
+func TestRateLimiter_CheckToken(t *testing.T) {
+	config := Config{
+		DefaultTokenLimit: 5,
+		TokenLimits: map[string]TokenConfig{
+			"premium": {Limit: 20},
+		},
+	}
+	rl := NewRateLimiter(NewEvaluatorStorage(), config)
+	ctx := context.Background()
+
+	tests := []struct {
+		token     string
+		wantRule  string
+		wantLimit int
+	}{
+		{"premium", RuleToken, 20},
+		{"unknown", RuleDefaultToken, 5},
+	}
+
+	for _, tt := range tests {
+		decision, err := rl.CheckToken(ctx, tt.token)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !decision.Allowed || decision.Rule != tt.wantRule || decision.Limit != tt.wantLimit || decision.Remaining != tt.wantLimit-1 {
+			t.Fatalf("Token %s: expected rule %q with limit %d, got %+v", tt.token, tt.wantRule, tt.wantLimit, decision)
+		}
+		if decision.Key != "token:"+tt.token {
+			t.Fatalf("Token %s: expected key token:%s, got %q", tt.token, tt.token, decision.Key)
+		}
+	}
+}
+
+func TestRateLimiter_CheckWindows(t *testing.T) {
+	store := NewEvaluatorStorage()
+	config := Config{
+		IPLimit: 10,
+		IPWindows: []Window{
+			{Limit: 100, Period: time.Hour},
+			{Limit: 3, Period: time.Minute, BlockDuration: time.Minute},
+		},
+		DefaultTokenLimit: 2,
+		DefaultTokenWindows: []Window{
+			{Limit: 5, Period: time.Minute},
+		},
+	}
+	rl := NewRateLimiter(store, config)
+	ctx := context.Background()
+
+	// Test: Allowed decision reports the window with the fewest remaining requests
+	decision, err := rl.CheckIP(ctx, "192.168.1.1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !decision.Allowed || decision.Window != time.Minute || decision.Limit != 3 || decision.Remaining != 2 {
+		t.Fatalf("Expected allowed decision for the minute window with 2 of 3 remaining, got %+v", decision)
+	}
+
+	rl.CheckIP(ctx, "192.168.1.1")
+	rl.CheckIP(ctx, "192.168.1.1")
+
+	// Test: Exhausted window rejects the request and blocks its key
+	decision, _ = rl.CheckIP(ctx, "192.168.1.1")
+	if decision.Allowed || !decision.Blocked || decision.Window != time.Minute || decision.RetryAfter != time.Minute {
+		t.Fatalf("Expected blocked decision for the minute window, got %+v", decision)
+	}
+	if decision.Key != "ip:192.168.1.1" {
+		t.Fatalf("Expected key ip:192.168.1.1, got %q", decision.Key)
+	}
+
+	// Test: Requests rejected by a shorter window are not counted in longer ones
+	for i := 0; i < 3; i++ {
+		rl.CheckToken(ctx, "abc123")
+	}
+	decision, _ = rl.CheckToken(ctx, "abc123")
+	if decision.Allowed || decision.Window != time.Second {
+		t.Fatalf("Expected rejection by the second window, got %+v", decision)
+	}
+	if count, _ := store.Get(ctx, "token:abc123:"+time.Minute.String()); count != 2 {
+		t.Fatalf("Expected 2 requests counted in the minute window, got %d", count)
+	}
+}
+
+func TestRateLimiter_CheckPeriod(t *testing.T) {
+	config := Config{
+		IPLimit:  2,
+		IPPeriod: 10 * time.Second,
+		TokenLimits: map[string]TokenConfig{
+			"export": {Limit: 1, Period: time.Hour},
+		},
+	}
+	rl := NewRateLimiter(NewEvaluatorStorage(), config)
+	now := time.Unix(1700000000, 0)
+	rl.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		check      func() (Decision, error)
+		wantPeriod time.Duration
+	}{
+		{"ip", func() (Decision, error) { return rl.CheckIP(ctx, "192.168.1.1") }, 10 * time.Second},
+		{"token", func() (Decision, error) { return rl.CheckToken(ctx, "export") }, time.Hour},
+	}
+
+	for _, tt := range tests {
+		decision, err := tt.check()
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tt.name, err)
+		}
+		reset := decision.ResetAt.Sub(now)
+		if !decision.Allowed || decision.Window != tt.wantPeriod || reset <= tt.wantPeriod-time.Second || reset > tt.wantPeriod {
+			t.Fatalf("%s: expected allowed decision resetting within %v, got %+v", tt.name, tt.wantPeriod, decision)
+		}
+	}
+
+	// Test: Limit applies over the whole period
+	rl.CheckIP(ctx, "192.168.1.1")
+	decision, _ := rl.CheckIP(ctx, "192.168.1.1")
+	if decision.Allowed {
+		t.Fatalf("Expected rejection within the period, got %+v", decision)
+	}
+}
+
+func TestRateLimiter_CheckCost(t *testing.T) {
+	config := Config{
+		IPLimit:           100,
+		IPPeriod:          time.Minute,
+		IPBlockDuration:   time.Minute,
+		DefaultTokenLimit: 100,
+		DefaultTokenWindows: []Window{
+			{Limit: 60, Period: time.Hour},
+		},
+	}
+
+	stores := map[string]storage.Storage{
+		"counter":   NewMockStorage(),
+		"evaluator": NewEvaluatorStorage(),
+	}
+	for name, store := range stores {
+		rl := NewRateLimiter(store, config)
+		ctx := context.Background()
+
+		// Test: Weighted request consumes n units of the limit
+		decision, err := rl.CheckIPN(ctx, "192.168.1.1", 50)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+		if !decision.Allowed || decision.Cost != 50 || decision.Remaining != 50 {
+			t.Fatalf("%s: expected allowed decision with 50 remaining, got %+v", name, decision)
+		}
+
+		rl.CheckIP(ctx, "192.168.1.1")
+		decision, _ = rl.CheckIPN(ctx, "192.168.1.1", 50)
+		if decision.Allowed || decision.Cost != 50 {
+			t.Fatalf("%s: expected rejection of 50 units, got %+v", name, decision)
+		}
+
+		// Test: Cost below 1 counts as a single request
+		decision, _ = rl.CheckTokenN(ctx, "abc123", 0)
+		if !decision.Allowed || decision.Cost != 1 || decision.Remaining != 59 {
+			t.Fatalf("%s: expected allowed decision with 59 remaining, got %+v", name, decision)
+		}
+
+		// Test: Cost applies to every window
+		allowed, _ := rl.AllowTokenN(ctx, "abc123", 59)
+		if !allowed {
+			t.Fatalf("%s: expected 59 more units to be allowed", name)
+		}
+		decision, _ = rl.CheckTokenN(ctx, "abc123", 10)
+		if decision.Allowed || decision.Window != time.Hour {
+			t.Fatalf("%s: expected rejection by the hour window, got %+v", name, decision)
+		}
+	}
+}
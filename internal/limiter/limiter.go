@@ -2,111 +2,373 @@ package limiter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/allis/rate-limiter/internal/storage"
 )
 
+// Algorithm identifies a rate limiting algorithm
+type Algorithm = storage.Algorithm
+
+// Supported rate limiting algorithms
+const (
+	FixedWindow          = storage.FixedWindow
+	TokenBucket          = storage.TokenBucket
+	SlidingWindowCounter = storage.SlidingWindowCounter
+	SlidingWindowLog     = storage.SlidingWindowLog
+	GCRA                 = storage.GCRA
+)
+
+// ParseAlgorithm parses an algorithm name, returning FixedWindow for an empty name
+func ParseAlgorithm(name string) (Algorithm, error) {
+	if name == "" {
+		return FixedWindow, nil
+	}
+	for _, algorithm := range algorithms {
+		if Algorithm(name) == algorithm {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", storage.ErrUnsupportedAlgorithm, name)
+}
+
+// ErrTimeout is returned when a rate limit check does not complete before
+// the deadline of its context
+var ErrTimeout = errors.New("rate limit check timed out")
+
 // Config holds the configuration for rate limiter
 type Config struct {
 	IPLimit                   int
+	IPPeriod                  time.Duration
 	IPBlockDuration           time.Duration
+	IPAlgorithm               Algorithm
+	IPBurst                   int
+	IPWindows                 []Window
 	TokenLimits               map[string]TokenConfig
 	DefaultTokenLimit         int
+	DefaultTokenPeriod        time.Duration
 	DefaultTokenBlockDuration time.Duration
+	DefaultTokenAlgorithm     Algorithm
+	DefaultTokenBurst         int
+	DefaultTokenWindows       []Window
+	// KeyPrefix is prepended to the storage keys, keeping apart the counters
+	// of rate limiters sharing a storage, e.g. "route:login:"
+	KeyPrefix string
+}
+
+// IPKey returns the storage key of the limits of an IP. Additional windows
+// are stored under the key suffixed with ":" and their period, e.g.
+// "ip:192.168.1.1:24h0m0s".
+func (c Config) IPKey(ip string) string {
+	return c.KeyPrefix + "ip:" + ip
+}
+
+// TokenKey returns the storage key of the limits of a token, see IPKey
+func (c Config) TokenKey(token string) string {
+	return c.KeyPrefix + "token:" + token
+}
+
+// Rule is the limit a configuration applies to an IP or token
+type Rule struct {
+	// Name is RuleIP, RuleToken or RuleDefaultToken
+	Name string
+	// Key is the storage key of the limit
+	Key string
+	// Limit is the limit with its windows
+	Limit TokenConfig
+}
+
+// IPRule returns the rule applied to the requests of an IP
+func (c Config) IPRule(ip string) Rule {
+	return Rule{
+		Name: RuleIP,
+		Key:  c.IPKey(ip),
+		Limit: TokenConfig{
+			Limit:         c.IPLimit,
+			Period:        c.IPPeriod,
+			BlockDuration: c.IPBlockDuration,
+			Algorithm:     c.IPAlgorithm,
+			Burst:         c.IPBurst,
+			Windows:       c.IPWindows,
+		},
+	}
+}
+
+// TokenRule returns the rule applied to the requests with a token, its own
+// limit if configured or the default token limit
+func (c Config) TokenRule(token string) Rule {
+	if tokenConfig, exists := c.TokenLimits[token]; exists {
+		return Rule{Name: RuleToken, Key: c.TokenKey(token), Limit: tokenConfig}
+	}
+	return Rule{
+		Name: RuleDefaultToken,
+		Key:  c.TokenKey(token),
+		Limit: TokenConfig{
+			Limit:         c.DefaultTokenLimit,
+			Period:        c.DefaultTokenPeriod,
+			BlockDuration: c.DefaultTokenBlockDuration,
+			Algorithm:     c.DefaultTokenAlgorithm,
+			Burst:         c.DefaultTokenBurst,
+			Windows:       c.DefaultTokenWindows,
+		},
+	}
+}
+
+// RouteKeyPrefix returns the KeyPrefix of the rate limiter of a named route
+func RouteKeyPrefix(name string) string {
+	return "route:" + name + ":"
 }
 
 // TokenConfig holds token-specific configuration
 type TokenConfig struct {
-	Limit         int
+	Limit int
+	// Period is the window length of Limit, one second if zero
+	Period        time.Duration
 	BlockDuration time.Duration
+	// Algorithm selects the rate limiting algorithm, FixedWindow if empty
+	Algorithm Algorithm
+	// Burst is the token bucket capacity or the GCRA burst tolerance,
+	// Limit if zero
+	Burst int
+	// Windows are further limits evaluated together with Limit, e.g. per
+	// minute and per day. A request is rejected when any window is exhausted.
+	Windows []Window
+}
+
+// Window is a limit over a period of time
+type Window struct {
+	// Limit is the number of requests allowed per Period
+	Limit int
+	// Period is the length of the window
+	Period time.Duration
+	// BlockDuration is how long the key is blocked once Limit is exceeded
+	BlockDuration time.Duration
+	// Burst is the token bucket capacity or the GCRA burst tolerance,
+	// Limit if zero
+	Burst int
+}
+
+// keyedWindow is a window with the storage key of its state
+type keyedWindow struct {
+	Window
+	key string
+}
+
+// period returns the window length of Limit
+func (c TokenConfig) period() time.Duration {
+	if c.Period <= 0 {
+		return time.Second
+	}
+	return c.Period
+}
+
+// windows returns the windows of the configuration ordered by increasing
+// period. The Limit window keeps key as its storage key, so its state is
+// unaffected by the addition of windows, the others are suffixed with their
+// period.
+func (c TokenConfig) windows(key string) []keyedWindow {
+	windows := make([]keyedWindow, 0, len(c.Windows)+1)
+	windows = append(windows, keyedWindow{
+		Window: Window{
+			Limit:         c.Limit,
+			Period:        c.period(),
+			BlockDuration: c.BlockDuration,
+			Burst:         c.Burst,
+		},
+		key: key,
+	})
+	for _, window := range c.Windows {
+		windows = append(windows, keyedWindow{
+			Window: window,
+			key:    windowKey(key, window.Period),
+		})
+	}
+
+	sort.SliceStable(windows, func(i, j int) bool {
+		return windows[i].Period < windows[j].Period
+	})
+	return windows
+}
+
+// WindowKeys returns the storage keys of the configuration for key: key
+// itself, the key of Limit, followed by the keys of the additional windows
+func (c TokenConfig) WindowKeys(key string) []string {
+	keys := make([]string, 0, len(c.Windows)+1)
+	keys = append(keys, key)
+	for _, window := range c.Windows {
+		keys = append(keys, windowKey(key, window.Period))
+	}
+	return keys
+}
+
+// windowKey returns the storage key of an additional window of key
+func windowKey(key string, period time.Duration) string {
+	return key + ":" + period.String()
 }
 
 // RateLimiter handles rate limiting logic
 type RateLimiter struct {
-	storage storage.Storage
-	config  Config
+	storage    storage.Storage
+	config     atomic.Pointer[Config]
+	strategies map[Algorithm]Strategy
+	now        func() time.Time
 }
 
+// Option configures a rate limiter
+type Option func(*RateLimiter)
+
 // NewRateLimiter creates a new rate limiter instance
-func NewRateLimiter(storage storage.Storage, config Config) *RateLimiter {
-	return &RateLimiter{
-		storage: storage,
-		config:  config,
+func NewRateLimiter(storage storage.Storage, config Config, opts ...Option) *RateLimiter {
+	rl := &RateLimiter{
+		storage:    storage,
+		strategies: newStrategies(storage),
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(rl)
 	}
+	rl.config.Store(&config)
+	return rl
 }
 
-// AllowIP checks if a request from an IP is allowed
-func (rl *RateLimiter) AllowIP(ctx context.Context, ip string) (bool, error) {
-	key := fmt.Sprintf("ip:%s", ip)
+// Config returns the current configuration
+func (rl *RateLimiter) Config() Config {
+	return *rl.config.Load()
+}
 
-	// Check if IP is blocked
-	blocked, err := rl.storage.IsBlocked(ctx, key)
-	if err != nil {
-		return false, fmt.Errorf("failed to check if IP is blocked: %w", err)
-	}
-	if blocked {
-		return false, nil
-	}
+// SetConfig atomically replaces the configuration while the rate limiter is
+// in use. Counters and blocks are kept in the storage, so clients keep their
+// current usage under the new limits. The config must not be modified
+// afterwards.
+func (rl *RateLimiter) SetConfig(config Config) {
+	rl.config.Store(&config)
+}
 
-	// Increment counter
-	count, err := rl.storage.Increment(ctx, key, time.Second)
-	if err != nil {
-		return false, fmt.Errorf("failed to increment IP counter: %w", err)
-	}
+// RegisterStrategy registers a strategy for an algorithm, replacing any
+// built-in one. It must be called before the rate limiter is used.
+func (rl *RateLimiter) RegisterStrategy(algorithm Algorithm, strategy Strategy) {
+	rl.strategies[algorithm] = strategy
+}
 
-	// Check if limit exceeded
-	if count > int64(rl.config.IPLimit) {
-		// Block the IP
-		if err := rl.storage.SetBlock(ctx, key, rl.config.IPBlockDuration); err != nil {
-			return false, fmt.Errorf("failed to block IP: %w", err)
-		}
-		return false, nil
-	}
+// AllowIP checks if a request from an IP is allowed
+func (rl *RateLimiter) AllowIP(ctx context.Context, ip string) (bool, error) {
+	return rl.AllowIPN(ctx, ip, 1)
+}
 
-	return true, nil
+// AllowIPN checks if a request from an IP consuming n units is allowed
+func (rl *RateLimiter) AllowIPN(ctx context.Context, ip string, n int) (bool, error) {
+	decision, err := rl.CheckIPN(ctx, ip, n)
+	return decision.Allowed, err
 }
 
 // AllowToken checks if a request with a token is allowed
 func (rl *RateLimiter) AllowToken(ctx context.Context, token string) (bool, error) {
-	key := fmt.Sprintf("token:%s", token)
+	return rl.AllowTokenN(ctx, token, 1)
+}
 
-	// Check if token is blocked
-	blocked, err := rl.storage.IsBlocked(ctx, key)
-	if err != nil {
-		return false, fmt.Errorf("failed to check if token is blocked: %w", err)
+// AllowTokenN checks if a request with a token consuming n units is allowed
+func (rl *RateLimiter) AllowTokenN(ctx context.Context, token string, n int) (bool, error) {
+	decision, err := rl.CheckTokenN(ctx, token, n)
+	return decision.Allowed, err
+}
+
+// CheckIP checks a request from an IP and returns the full decision
+func (rl *RateLimiter) CheckIP(ctx context.Context, ip string) (Decision, error) {
+	return rl.CheckIPN(ctx, ip, 1)
+}
+
+// CheckIPN checks a request from an IP consuming n units of the limit, e.g.
+// an expensive export, and returns the full decision
+func (rl *RateLimiter) CheckIPN(ctx context.Context, ip string, n int) (Decision, error) {
+	config := rl.config.Load()
+	return rl.check(ctx, "IP", config.IPRule(ip), n)
+}
+
+// CheckToken checks a request with a token and returns the full decision
+func (rl *RateLimiter) CheckToken(ctx context.Context, token string) (Decision, error) {
+	return rl.CheckTokenN(ctx, token, 1)
+}
+
+// CheckTokenN checks a request with a token consuming n units of the limit
+// and returns the full decision
+func (rl *RateLimiter) CheckTokenN(ctx context.Context, token string, n int) (Decision, error) {
+	config := rl.config.Load()
+	return rl.check(ctx, "token", config.TokenRule(token), n)
+}
+
+// check counts a request of cost units of an IP or token in every window of
+// rule using the strategy for the configured algorithm. Windows are
+// evaluated from the shortest to the longest and the first exhausted one
+// rejects the request, so rejected requests are not counted in the longer
+// windows. A cost below 1 counts as 1. Storage failures are returned, not
+// logged, so the caller logs them once with the request they failed.
+func (rl *RateLimiter) check(ctx context.Context, kind string, r Rule, cost int) (Decision, error) {
+	if cost < 1 {
+		cost = 1
 	}
-	if blocked {
-		return false, nil
+	key, rule, cfg := r.Key, r.Name, r.Limit
+
+	decision := Decision{
+		Limit:  cfg.Limit,
+		Window: cfg.period(),
+		Cost:   cost,
+		Rule:   rule,
+		Key:    key,
 	}
 
-	// Get token configuration
-	tokenConfig, exists := rl.config.TokenLimits[token]
-	if !exists {
-		// Use default token configuration
-		tokenConfig = TokenConfig{
-			Limit:         rl.config.DefaultTokenLimit,
-			BlockDuration: rl.config.DefaultTokenBlockDuration,
-		}
+	algorithm := cfg.Algorithm
+	if algorithm == "" {
+		algorithm = FixedWindow
 	}
 
-	// Increment counter
-	count, err := rl.storage.Increment(ctx, key, time.Second)
-	if err != nil {
-		return false, fmt.Errorf("failed to increment token counter: %w", err)
+	strategy, ok := rl.strategies[algorithm]
+	if !ok {
+		return decision, fmt.Errorf("failed to evaluate %s rate limit: %w: %s", kind, storage.ErrUnsupportedAlgorithm, algorithm)
 	}
 
-	// Check if limit exceeded
-	if count > int64(tokenConfig.Limit) {
-		// Block the token
-		if err := rl.storage.SetBlock(ctx, key, tokenConfig.BlockDuration); err != nil {
-			return false, fmt.Errorf("failed to block token: %w", err)
+	for i, window := range cfg.windows(key) {
+		// Do not reach the storage for a request already canceled or timed out
+		var result storage.Result
+		err := ctx.Err()
+		if err == nil {
+			result, err = strategy.Allow(ctx, window.key, window.Window, cost)
+		}
+		if err != nil {
+			// Storage clients may report an expired deadline as a network
+			// timeout, so the context is checked as well
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return decision, fmt.Errorf("failed to evaluate %s rate limit: %w: %w", kind, ErrTimeout, err)
+			}
+			return decision, fmt.Errorf("failed to evaluate %s rate limit: %w", kind, err)
+		}
+
+		windowDecision := Decision{
+			Allowed:    result.Allowed,
+			Limit:      window.Limit,
+			Remaining:  int(result.Remaining),
+			ResetAt:    rl.now().Add(result.ResetAfter),
+			RetryAfter: result.RetryAfter,
+			Blocked:    result.Blocked,
+			Window:     window.Period,
+			Cost:       cost,
+			Rule:       rule,
+			Key:        key,
+		}
+		if !windowDecision.Allowed {
+			return windowDecision, nil
+		}
+
+		// Report the window closest to its limit
+		if i == 0 || windowDecision.Remaining < decision.Remaining {
+			decision = windowDecision
 		}
-		return false, nil
 	}
 
-	return true, nil
+	return decision, nil
 }
 
 // GetBlockTTL returns the remaining block duration for a key
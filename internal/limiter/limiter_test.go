@@ -2,8 +2,14 @@ package limiter
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/allis/rate-limiter/internal/storage"
 )
 
 // MockStorage is a mock implementation of Storage for testing
@@ -24,6 +30,11 @@ func (m *MockStorage) Increment(ctx context.Context, key string, expiration time
 	return m.counters[key], nil
 }
 
+func (m *MockStorage) IncrementBy(ctx context.Context, key string, n int64, expiration time.Duration) (int64, error) {
+	m.counters[key] += n
+	return m.counters[key], nil
+}
+
 func (m *MockStorage) Get(ctx context.Context, key string) (int64, error) {
 	return m.counters[key], nil
 }
@@ -47,6 +58,33 @@ func (m *MockStorage) TTL(ctx context.Context, key string) (time.Duration, error
 	return 0, nil
 }
 
+func (m *MockStorage) SetBlockWithReason(ctx context.Context, key string, duration time.Duration, reason string) error {
+	return m.SetBlock(ctx, key, duration)
+}
+
+func (m *MockStorage) BlockReason(ctx context.Context, key string) (string, error) {
+	return "", nil
+}
+
+func (m *MockStorage) Unblock(ctx context.Context, key string) error {
+	delete(m.blocks, key)
+	return nil
+}
+
+func (m *MockStorage) Delete(ctx context.Context, key string) error {
+	delete(m.counters, key)
+	delete(m.blocks, key)
+	return nil
+}
+
+func (m *MockStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (m *MockStorage) ListBlocked(ctx context.Context, prefix string) ([]string, error) {
+	return nil, nil
+}
+
 func (m *MockStorage) Close() error {
 	return nil
 }
@@ -56,6 +94,21 @@ func (m *MockStorage) Reset() {
 	m.blocks = make(map[string]time.Time)
 }
 
+// EvaluatorStorage wraps MemoryStorage counting Evaluate calls
+type EvaluatorStorage struct {
+	*storage.MemoryStorage
+	calls int64
+}
+
+func NewEvaluatorStorage() *EvaluatorStorage {
+	return &EvaluatorStorage{MemoryStorage: storage.NewMemoryStorage(0)}
+}
+
+func (e *EvaluatorStorage) Evaluate(ctx context.Context, req storage.Request) (storage.Result, error) {
+	atomic.AddInt64(&e.calls, 1)
+	return e.MemoryStorage.Evaluate(ctx, req)
+}
+
 func TestRateLimiter_AllowIP(t *testing.T) {
 	storage := NewMockStorage()
 	config := Config{
@@ -196,3 +249,219 @@ func TestRateLimiter_TokenOverridesIP(t *testing.T) {
 		t.Fatal("Request should be blocked after exceeding token limit")
 	}
 }
+
+func TestRateLimiter_UsesEvaluator(t *testing.T) {
+	storage := NewEvaluatorStorage()
+	config := Config{
+		IPLimit:         2,
+		IPBlockDuration: 5 * time.Second,
+	}
+	rl := NewRateLimiter(storage, config)
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		allowed, err := rl.AllowIP(ctx, "192.168.1.1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if allowed != (i <= 2) {
+			t.Fatalf("Request %d: expected allowed=%v, got %v", i, i <= 2, allowed)
+		}
+	}
+
+	// Test: One storage call per request
+	if storage.calls != 3 {
+		t.Fatalf("Expected 3 Evaluate calls, got %d", storage.calls)
+	}
+}
+
+func TestRateLimiter_ConcurrentEvaluate(t *testing.T) {
+	storage := NewEvaluatorStorage()
+	config := Config{
+		IPLimit:         50,
+		IPBlockDuration: 5 * time.Second,
+	}
+	rl := NewRateLimiter(storage, config)
+	ctx := context.Background()
+
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 500; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := rl.AllowIP(ctx, "192.168.1.1")
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+				return
+			}
+			if ok {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 50 {
+		t.Fatalf("Expected exactly 50 allowed requests, got %d", allowed)
+	}
+}
+
+func TestRateLimiter_TokenBucket(t *testing.T) {
+	storage := NewEvaluatorStorage()
+	config := Config{
+		IPLimit:               5,
+		IPAlgorithm:           TokenBucket,
+		IPBurst:               10,
+		DefaultTokenLimit:     2,
+		DefaultTokenAlgorithm: FixedWindow,
+		TokenLimits: map[string]TokenConfig{
+			"bucket": {
+				Limit:     2,
+				Algorithm: TokenBucket,
+				Burst:     4,
+			},
+		},
+	}
+	rl := NewRateLimiter(storage, config)
+	ctx := context.Background()
+
+	// Test: IP bucket allows a burst up to its capacity
+	for i := 1; i <= 11; i++ {
+		allowed, err := rl.AllowIP(ctx, "192.168.1.1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if allowed != (i <= 10) {
+			t.Fatalf("IP request %d: expected allowed=%v, got %v", i, i <= 10, allowed)
+		}
+	}
+
+	// Test: Token-specific algorithm overrides the default
+	for i := 1; i <= 5; i++ {
+		allowed, err := rl.AllowToken(ctx, "bucket")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if allowed != (i <= 4) {
+			t.Fatalf("Token request %d: expected allowed=%v, got %v", i, i <= 4, allowed)
+		}
+	}
+}
+
+func TestRateLimiter_UnsupportedAlgorithm(t *testing.T) {
+	rl := NewRateLimiter(NewMockStorage(), Config{
+		IPLimit:     5,
+		IPAlgorithm: TokenBucket,
+	})
+
+	// Test: Storages without Evaluate only support the fixed window
+	_, err := rl.AllowIP(context.Background(), "192.168.1.1")
+	if !errors.Is(err, storage.ErrUnsupportedAlgorithm) {
+		t.Fatalf("Expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Algorithm
+		wantErr bool
+	}{
+		{"", FixedWindow, false},
+		{"fixed_window", FixedWindow, false},
+		{"token_bucket", TokenBucket, false},
+		{"sliding_window_counter", SlidingWindowCounter, false},
+		{"sliding_window_log", SlidingWindowLog, false},
+		{"gcra", GCRA, false},
+		{"leaky_bucket", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAlgorithm(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("ParseAlgorithm(%q): expected error=%v, got %v", tt.name, tt.wantErr, err)
+		}
+		if got != tt.want {
+			t.Fatalf("ParseAlgorithm(%q): expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRateLimiter_SetConfig(t *testing.T) {
+	store := NewEvaluatorStorage()
+	rl := NewRateLimiter(store, Config{IPLimit: 3, IPPeriod: time.Minute, DefaultTokenLimit: 1})
+	ctx := context.Background()
+
+	rl.AllowIP(ctx, "192.168.1.1")
+	rl.AllowIP(ctx, "192.168.1.1")
+
+	// Test: New limits apply to the existing counters
+	rl.SetConfig(Config{
+		IPLimit:  2,
+		IPPeriod: time.Minute,
+		TokenLimits: map[string]TokenConfig{
+			"abc123": {Limit: 2},
+		},
+	})
+	if allowed, _ := rl.AllowIP(ctx, "192.168.1.1"); allowed {
+		t.Fatal("Expected rejection after lowering the limit below the current usage")
+	}
+	if rl.Config().IPLimit != 2 {
+		t.Fatalf("Expected IP limit 2, got %d", rl.Config().IPLimit)
+	}
+
+	// Test: New tokens are applied
+	decision, err := rl.CheckToken(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if decision.Rule != RuleToken || decision.Limit != 2 {
+		t.Fatalf("Expected the abc123 token rule with limit 2, got %+v", decision)
+	}
+}
+
+func TestRateLimiter_ConcurrentSetConfig(t *testing.T) {
+	rl := NewRateLimiter(NewEvaluatorStorage(), Config{IPLimit: 1000})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i == 0 {
+					rl.SetConfig(Config{IPLimit: 1000 + j})
+					continue
+				}
+				if _, err := rl.CheckIP(ctx, "192.168.1.1"); err != nil {
+					t.Errorf("Expected no error, got %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// failingStrategy fails every call as an unavailable storage would
+type failingStrategy struct{}
+
+func (failingStrategy) Allow(ctx context.Context, key string, window Window, cost int) (storage.Result, error) {
+	return storage.Result{}, errors.New("connection refused")
+}
+
+func TestRateLimiter_StorageFailures(t *testing.T) {
+	rl := NewRateLimiter(NewMockStorage(), Config{DefaultTokenLimit: 10})
+	rl.RegisterStrategy(FixedWindow, failingStrategy{})
+
+	// Test: The storage error is returned wrapped, for the caller to log
+	_, err := rl.CheckToken(context.Background(), "secret-token")
+	if err == nil || !strings.Contains(err.Error(), "token rate limit") || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("Expected the wrapped storage error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("Expected the error not to contain the token, got %v", err)
+	}
+}
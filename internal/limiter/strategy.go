@@ -0,0 +1,99 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/allis/rate-limiter/internal/storage"
+)
+
+// Strategy applies a rate limiting algorithm to a key
+type Strategy interface {
+	// Allow counts a request of cost units for key against the limit of
+	// window and blocks the key for window.BlockDuration once the limit is
+	// exceeded
+	Allow(ctx context.Context, key string, window Window, cost int) (storage.Result, error)
+}
+
+// algorithms lists the built-in algorithms
+var algorithms = []Algorithm{FixedWindow, TokenBucket, SlidingWindowCounter, SlidingWindowLog, GCRA}
+
+// newStrategies returns the built-in strategies supported by a storage.
+// Storages implementing storage.Evaluator support every algorithm, others
+// only the fixed window.
+func newStrategies(store storage.Storage) map[Algorithm]Strategy {
+	evaluator, ok := store.(storage.Evaluator)
+	if !ok {
+		return map[Algorithm]Strategy{
+			FixedWindow: &counterStrategy{storage: store},
+		}
+	}
+
+	strategies := make(map[Algorithm]Strategy, len(algorithms))
+	for _, algorithm := range algorithms {
+		strategies[algorithm] = &evaluatorStrategy{algorithm: algorithm, evaluator: evaluator}
+	}
+	return strategies
+}
+
+// evaluatorStrategy evaluates an algorithm atomically in the storage
+type evaluatorStrategy struct {
+	algorithm Algorithm
+	evaluator storage.Evaluator
+}
+
+// Allow evaluates the request in a single storage call
+func (s *evaluatorStrategy) Allow(ctx context.Context, key string, window Window, cost int) (storage.Result, error) {
+	return s.evaluator.Evaluate(ctx, storage.Request{
+		Algorithm:     s.algorithm,
+		Key:           key,
+		Limit:         int64(window.Limit),
+		Window:        window.Period,
+		Burst:         int64(window.Burst),
+		BlockDuration: window.BlockDuration,
+		Cost:          int64(cost),
+	})
+}
+
+// counterStrategy implements the fixed window with separate check,
+// increment and block calls for storages without storage.Evaluator
+type counterStrategy struct {
+	storage storage.Storage
+}
+
+// Allow checks the block, increments the counter and blocks the key
+func (s *counterStrategy) Allow(ctx context.Context, key string, window Window, cost int) (storage.Result, error) {
+	// Check if key is blocked
+	blocked, err := s.storage.IsBlocked(ctx, key)
+	if err != nil {
+		return storage.Result{}, fmt.Errorf("failed to check block: %w", err)
+	}
+	if blocked {
+		ttl, err := s.storage.TTL(ctx, key)
+		if err != nil {
+			return storage.Result{}, fmt.Errorf("failed to get block TTL: %w", err)
+		}
+		return storage.Result{Blocked: true, ResetAfter: ttl, RetryAfter: ttl}, nil
+	}
+
+	// Increment counter
+	count, err := s.storage.IncrementBy(ctx, key, int64(cost), window.Period)
+	if err != nil {
+		return storage.Result{}, fmt.Errorf("failed to increment counter: %w", err)
+	}
+
+	// Check if limit exceeded
+	if count > int64(window.Limit) {
+		// Block the key
+		if err := s.storage.SetBlock(ctx, key, window.BlockDuration); err != nil {
+			return storage.Result{}, fmt.Errorf("failed to set block: %w", err)
+		}
+		return storage.Result{Blocked: true, ResetAfter: window.BlockDuration, RetryAfter: window.BlockDuration}, nil
+	}
+
+	return storage.Result{
+		Allowed:    true,
+		Remaining:  int64(window.Limit) - count,
+		ResetAfter: window.Period,
+	}, nil
+}
@@ -0,0 +1,145 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/storage"
+)
+
+// StaticStrategy is a custom strategy that always returns the same result
+type StaticStrategy struct {
+	result storage.Result
+	keys   []string
+}
+
+func (s *StaticStrategy) Allow(ctx context.Context, key string, window Window, cost int) (storage.Result, error) {
+	s.keys = append(s.keys, key)
+	return s.result, nil
+}
+
+// SlowStrategy waits for the context to be done, as a hung storage would,
+// and then fails with a network error
+type SlowStrategy struct {
+	calls int
+}
+
+func (s *SlowStrategy) Allow(ctx context.Context, key string, window Window, cost int) (storage.Result, error) {
+	s.calls++
+	<-ctx.Done()
+	return storage.Result{}, errors.New("i/o timeout")
+}
+
+func TestRateLimiter_StrategyDispatch(t *testing.T) {
+	config := Config{
+		IPLimit:               3,
+		IPAlgorithm:           SlidingWindowLog,
+		DefaultTokenLimit:     3,
+		DefaultTokenAlgorithm: SlidingWindowCounter,
+		TokenLimits: map[string]TokenConfig{
+			"gcra": {Limit: 3, Algorithm: GCRA},
+		},
+	}
+	rl := NewRateLimiter(NewEvaluatorStorage(), config)
+	ctx := context.Background()
+
+	// Test: Each strategy enforces the limit within the window
+	for i := 1; i <= 4; i++ {
+		ipAllowed, err := rl.AllowIP(ctx, "192.168.1.1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		tokenAllowed, err := rl.AllowToken(ctx, "standard")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		gcraAllowed, err := rl.AllowToken(ctx, "gcra")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if ipAllowed != (i <= 3) || tokenAllowed != (i <= 3) || gcraAllowed != (i <= 3) {
+			t.Fatalf("Request %d: expected allowed=%v, got IP=%v token=%v gcra=%v", i, i <= 3, ipAllowed, tokenAllowed, gcraAllowed)
+		}
+	}
+}
+
+func TestRateLimiter_RegisterStrategy(t *testing.T) {
+	strategy := &StaticStrategy{result: storage.Result{Allowed: false}}
+	rl := NewRateLimiter(NewMockStorage(), Config{
+		IPLimit:     100,
+		IPAlgorithm: "custom",
+	})
+	rl.RegisterStrategy("custom", strategy)
+
+	allowed, err := rl.AllowIP(context.Background(), "192.168.1.1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if allowed {
+		t.Fatal("Expected custom strategy to reject the request")
+	}
+	if len(strategy.keys) != 1 || strategy.keys[0] != "ip:192.168.1.1" {
+		t.Fatalf("Expected custom strategy to receive key ip:192.168.1.1, got %v", strategy.keys)
+	}
+}
+
+func TestCounterStrategy_Allow(t *testing.T) {
+	strategy := &counterStrategy{storage: NewMockStorage()}
+	window := Window{Limit: 2, Period: time.Second, BlockDuration: 5 * time.Second}
+	ctx := context.Background()
+
+	for i := int64(1); i <= 2; i++ {
+		result, err := strategy.Allow(ctx, "ip:1", window, 1)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 2-i, result)
+		}
+	}
+
+	// Test: Exceeding the limit blocks the key
+	result, _ := strategy.Allow(ctx, "ip:1", window, 1)
+	if result.Allowed || !result.Blocked || result.RetryAfter != 5*time.Second {
+		t.Fatalf("Expected blocked result with 5s retry after, got %+v", result)
+	}
+
+	// Test: Blocked key reports the remaining block duration
+	result, _ = strategy.Allow(ctx, "ip:1", window, 1)
+	if !result.Blocked || result.RetryAfter <= 0 || result.RetryAfter > 5*time.Second {
+		t.Fatalf("Expected blocked result with retry after up to 5s, got %+v", result)
+	}
+}
+
+func TestRateLimiter_Timeout(t *testing.T) {
+	rl := NewRateLimiter(NewEvaluatorStorage(), Config{IPLimit: 5})
+	slow := &SlowStrategy{}
+	rl.RegisterStrategy(FixedWindow, slow)
+
+	// Test: Deadline reported as a network error is a timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := rl.CheckIP(ctx, "192.168.1.1")
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+
+	// Test: Expired context does not reach the strategy
+	_, err = rl.CheckIP(ctx, "192.168.1.1")
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected ErrTimeout wrapping the deadline, got %v", err)
+	}
+	if slow.calls != 1 {
+		t.Fatalf("Expected 1 strategy call, got %d", slow.calls)
+	}
+
+	// Test: Cancellation is not a timeout
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = rl.CheckIP(ctx, "192.168.1.1")
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
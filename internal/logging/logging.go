@@ -0,0 +1,142 @@
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// maxSampledMessages bounds the number of messages whose counters are kept
+// between ticks
+const maxSampledMessages = 4096
+
+// HashIdentity returns a short hash of an IP or token, so records can be
+// correlated per client without writing the token, a secret, to the logs
+func HashIdentity(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// SamplingHandler wraps a slog.Handler limiting the records of the same
+// level and message, e.g. one per rejected request, to first per tick and
+// then one in thereafter. The next record written after some were dropped
+// carries their number in a "dropped" attribute.
+type SamplingHandler struct {
+	handler slog.Handler
+	sampler *sampler
+}
+
+// sampler holds the counters shared by a SamplingHandler and the handlers
+// derived from it
+type sampler struct {
+	first      int
+	thereafter int
+	tick       time.Duration
+	now        func() time.Time
+
+	mu       sync.Mutex
+	counters map[samplingKey]*samplingCounter
+}
+
+// samplingKey identifies the records sampled together
+type samplingKey struct {
+	level   slog.Level
+	message string
+}
+
+// samplingCounter counts the records of a key within the current tick
+type samplingCounter struct {
+	resetAt time.Time
+	count   int
+	dropped int
+}
+
+// NewSamplingHandler wraps handler writing, per tick, the first records of
+// each level and message and then one in thereafter, none if thereafter is
+// zero. The handler is returned as is if first is not positive.
+func NewSamplingHandler(handler slog.Handler, first, thereafter int, tick time.Duration) slog.Handler {
+	if first <= 0 {
+		return handler
+	}
+	return &SamplingHandler{
+		handler: handler,
+		sampler: &sampler{
+			first:      first,
+			thereafter: thereafter,
+			tick:       tick,
+			now:        time.Now,
+			counters:   make(map[samplingKey]*samplingCounter),
+		},
+	}
+}
+
+// Enabled reports whether the wrapped handler handles records of level
+func (h *SamplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+// Handle writes the record if sampled
+func (h *SamplingHandler) Handle(ctx context.Context, record slog.Record) error {
+	dropped, ok := h.sampler.sample(samplingKey{level: record.Level, message: record.Message})
+	if !ok {
+		return nil
+	}
+	if dropped > 0 {
+		record = record.Clone()
+		record.AddAttrs(slog.Int("dropped", dropped))
+	}
+	return h.handler.Handle(ctx, record)
+}
+
+// WithAttrs returns a handler sharing the counters with h
+func (h *SamplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &SamplingHandler{handler: h.handler.WithAttrs(attrs), sampler: h.sampler}
+}
+
+// WithGroup returns a handler sharing the counters with h
+func (h *SamplingHandler) WithGroup(name string) slog.Handler {
+	return &SamplingHandler{handler: h.handler.WithGroup(name), sampler: h.sampler}
+}
+
+// sample counts a record of key and reports whether it is written, along
+// with the number of records of key dropped since the last one written
+func (s *sampler) sample(key samplingKey) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	c, ok := s.counters[key]
+	if !ok {
+		if len(s.counters) >= maxSampledMessages {
+			s.prune(now)
+		}
+		c = &samplingCounter{}
+		s.counters[key] = c
+	}
+	if !now.Before(c.resetAt) {
+		c.resetAt = now.Add(s.tick)
+		c.count = 0
+	}
+	c.count++
+
+	if c.count <= s.first || (s.thereafter > 0 && (c.count-s.first)%s.thereafter == 0) {
+		dropped := c.dropped
+		c.dropped = 0
+		return dropped, true
+	}
+	c.dropped++
+	return 0, false
+}
+
+// prune removes the counters of past ticks without dropped records, e.g.
+// of messages formatted with variable values. The caller must hold the lock.
+func (s *sampler) prune(now time.Time) {
+	for key, c := range s.counters {
+		if !now.Before(c.resetAt) && c.dropped == 0 {
+			delete(s.counters, key)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSamplingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewSamplingHandler(slog.NewTextHandler(&buf, nil), 2, 3, time.Second).(*SamplingHandler)
+	now := time.Now()
+	handler.sampler.now = func() time.Time { return now }
+	logger := slog.New(handler)
+
+	// Test: First 2 records, then 1 in 3
+	for i := 1; i <= 8; i++ {
+		logger.With("route", "login").Info("Request rejected", "n", i)
+	}
+	logger.Warn("Request rejected", "n", 0)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []string{"n=1", "n=2", "n=5 dropped=2", "n=8 dropped=2", "level=WARN"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d records, got:\n%s", len(expected), buf.String())
+	}
+	for i, want := range expected {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("Record %d: expected %q, got %s", i, want, lines[i])
+		}
+	}
+
+	// Test: Counters reset every tick
+	buf.Reset()
+	now = now.Add(time.Second)
+	logger.Info("Request rejected", "n", 9)
+	if !strings.Contains(buf.String(), "n=9") {
+		t.Errorf("Expected a record in the next tick, got %q", buf.String())
+	}
+}
+
+func TestNewSamplingHandler_Disabled(t *testing.T) {
+	inner := slog.NewTextHandler(&bytes.Buffer{}, nil)
+	if handler := NewSamplingHandler(inner, 0, 100, time.Second); handler != inner {
+		t.Errorf("Expected the handler as is without sampling, got %T", handler)
+	}
+}
+
+func TestHashIdentity(t *testing.T) {
+	hash := HashIdentity("secret-token")
+	if len(hash) != 16 || strings.Contains(hash, "secret") {
+		t.Errorf("Expected a 16 character hash, got %q", hash)
+	}
+	if HashIdentity("secret-token") != hash || HashIdentity("other-token") == hash {
+		t.Error("Expected the hash to identify the token")
+	}
+}
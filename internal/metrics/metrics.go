@@ -0,0 +1,297 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+	"github.com/allis/rate-limiter/internal/middleware"
+	"github.com/allis/rate-limiter/internal/storage"
+)
+
+// contentType is the content type of the Prometheus text exposition format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// StorageBuckets are the upper bounds, in seconds, of the storage call
+// latency histogram buckets
+var StorageBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// decisionLabels identifies a series of the decisions counter
+type decisionLabels struct {
+	outcome middleware.Outcome
+	keyType string
+	rule    string
+	route   string
+}
+
+// histogram counts observations per bucket of StorageBuckets, the last
+// count being the observations above every bucket
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Metrics collects the rate limit decisions, the storage call latency and
+// the fail-open events, and serves them with the number of blocked keys in
+// the Prometheus text exposition format. It is safe for concurrent use.
+type Metrics struct {
+	mu            sync.Mutex
+	decisions     map[decisionLabels]uint64
+	failOpen      map[middleware.Outcome]uint64
+	storage       map[string]*histogram
+	storageErrors map[string]uint64
+	// blocked are the blocked keys by key type of the last count, nil
+	// before the first one or after a failed one
+	blocked map[string]int
+}
+
+// New creates an empty set of metrics
+func New() *Metrics {
+	return &Metrics{
+		decisions: make(map[decisionLabels]uint64),
+		failOpen: map[middleware.Outcome]uint64{
+			middleware.OutcomeError:   0,
+			middleware.OutcomeTimeout: 0,
+		},
+		storage:       make(map[string]*histogram),
+		storageErrors: make(map[string]uint64),
+	}
+}
+
+// Observer returns a middleware observer counting the decisions by outcome,
+// key type, rule and route pattern, empty for the default limits. failOpen
+// tells whether the middleware lets requests through when the check fails
+// or times out, see middleware.WithFailOpen, so those outcomes are counted
+// as fail-open events too.
+func (m *Metrics) Observer(failOpen bool) middleware.Observer {
+	return func(r *http.Request, outcome middleware.Outcome, decision limiter.Decision) {
+		labels := decisionLabels{
+			outcome: outcome,
+			keyType: middleware.KeyType(r),
+			rule:    decision.Rule,
+			route:   middleware.RoutePattern(r),
+		}
+		if decision.Rule != "" {
+			labels.keyType = limiter.RuleIP
+			if decision.Rule != limiter.RuleIP {
+				labels.keyType = limiter.RuleToken
+			}
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.decisions[labels]++
+		if failOpen && (outcome == middleware.OutcomeError || outcome == middleware.OutcomeTimeout) {
+			m.failOpen[outcome]++
+		}
+	}
+}
+
+// ObserveStorage records the duration and error of a storage call, see
+// storage.NewInstrumentedStorage
+func (m *Metrics) ObserveStorage(operation string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.storage[operation]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(StorageBuckets)+1)}
+		m.storage[operation] = h
+	}
+	seconds := duration.Seconds()
+	h.counts[sort.SearchFloat64s(StorageBuckets, seconds)]++
+	h.count++
+	h.sum += seconds
+
+	if err != nil {
+		m.storageErrors[operation]++
+	}
+}
+
+// Handler serves the metrics along with the number of blocked keys of the
+// last CountBlocked call, omitted if there was none or it failed
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		m.write(&buf)
+		w.Header().Set("Content-Type", contentType)
+		w.Write(buf.Bytes())
+	})
+}
+
+// CountBlocked counts the keys currently blocked in store for the blocked
+// keys gauge. Listing them scans the whole keyspace on Redis, so it is
+// called periodically by WatchBlocked rather than on every scrape.
+func (m *Metrics) CountBlocked(ctx context.Context, store storage.Storage) error {
+	keys, err := store.ListBlocked(ctx, "")
+	var blocked map[string]int
+	if err == nil {
+		blocked = countBlocked(keys)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blocked = blocked
+	return err
+}
+
+// WatchBlocked counts the blocked keys in store right away and then every
+// interval until ctx is done, logging the failed counts to logger
+func (m *Metrics) WatchBlocked(ctx context.Context, store storage.Storage, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.CountBlocked(ctx, store); err != nil && ctx.Err() == nil {
+			logger.WarnContext(ctx, "Failed to count the blocked keys for metrics", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// countBlocked counts the blocked keys by key type, e.g. "ip:10.0.0.1" and
+// "route:login:ip:10.0.0.1:1h0m0s" as limiter.RuleIP
+func countBlocked(keys []string) map[string]int {
+	blocked := map[string]int{limiter.RuleIP: 0, limiter.RuleToken: 0}
+	for _, key := range keys {
+		if rest, ok := strings.CutPrefix(key, "route:"); ok {
+			// Skip the route name, which may contain ':' too
+			ip, token := strings.Index(rest, ":ip:"), strings.Index(rest, ":token:")
+			switch {
+			case ip >= 0 && (token < 0 || ip < token):
+				key = rest[ip+1:]
+			case token >= 0:
+				key = rest[token+1:]
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(key, limiter.RuleIP+":"):
+			blocked[limiter.RuleIP]++
+		case strings.HasPrefix(key, limiter.RuleToken+":"):
+			blocked[limiter.RuleToken]++
+		}
+	}
+	return blocked
+}
+
+// write writes the metrics in the Prometheus text exposition format
+func (m *Metrics) write(buf *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(buf, "ratelimiter_decisions_total", "counter", "Rate limit checks by outcome, key type, rule and route.")
+	decisions := make([]decisionLabels, 0, len(m.decisions))
+	for labels := range m.decisions {
+		decisions = append(decisions, labels)
+	}
+	sort.Slice(decisions, func(i, j int) bool {
+		a, b := decisions[i], decisions[j]
+		if a.outcome != b.outcome {
+			return a.outcome < b.outcome
+		}
+		if a.keyType != b.keyType {
+			return a.keyType < b.keyType
+		}
+		if a.rule != b.rule {
+			return a.rule < b.rule
+		}
+		return a.route < b.route
+	})
+	for _, labels := range decisions {
+		writeSample(buf, "ratelimiter_decisions_total", float64(m.decisions[labels]),
+			"outcome", string(labels.outcome), "key_type", labels.keyType, "rule", labels.rule, "route", labels.route)
+	}
+
+	writeHeader(buf, "ratelimiter_fail_open_total", "counter", "Requests let through because the rate limit check failed or timed out.")
+	for _, outcome := range sortedKeys(m.failOpen) {
+		writeSample(buf, "ratelimiter_fail_open_total", float64(m.failOpen[outcome]), "outcome", string(outcome))
+	}
+
+	writeHeader(buf, "ratelimiter_storage_duration_seconds", "histogram", "Latency of the storage calls by operation.")
+	for _, operation := range sortedKeys(m.storage) {
+		h := m.storage[operation]
+		var cumulative uint64
+		for i, bound := range StorageBuckets {
+			cumulative += h.counts[i]
+			writeSample(buf, "ratelimiter_storage_duration_seconds_bucket", float64(cumulative),
+				"operation", operation, "le", formatFloat(bound))
+		}
+		writeSample(buf, "ratelimiter_storage_duration_seconds_bucket", float64(h.count), "operation", operation, "le", "+Inf")
+		writeSample(buf, "ratelimiter_storage_duration_seconds_sum", h.sum, "operation", operation)
+		writeSample(buf, "ratelimiter_storage_duration_seconds_count", float64(h.count), "operation", operation)
+	}
+
+	writeHeader(buf, "ratelimiter_storage_errors_total", "counter", "Failed storage calls by operation.")
+	for _, operation := range sortedKeys(m.storageErrors) {
+		writeSample(buf, "ratelimiter_storage_errors_total", float64(m.storageErrors[operation]), "operation", operation)
+	}
+
+	if m.blocked != nil {
+		writeHeader(buf, "ratelimiter_blocked_keys", "gauge", "Storage keys blocked at the last count, by key type.")
+		for _, keyType := range sortedKeys(m.blocked) {
+			writeSample(buf, "ratelimiter_blocked_keys", float64(m.blocked[keyType]), "key_type", keyType)
+		}
+	}
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeSample writes a sample with its label name and value pairs
+func writeSample(buf *bytes.Buffer, name string, value float64, labels ...string) {
+	buf.WriteString(name)
+	if len(labels) > 0 {
+		buf.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		buf.WriteByte('}')
+	}
+	fmt.Fprintf(buf, " %s\n", formatFloat(value))
+}
+
+// labelEscaper escapes the characters special to label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// formatFloat formats a sample value or bucket bound
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
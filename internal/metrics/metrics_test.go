@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+	"github.com/allis/rate-limiter/internal/middleware"
+	"github.com/allis/rate-limiter/internal/storage"
+)
+
+// scrape returns the metrics served by the handler
+func scrape(t *testing.T, h http.Handler) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("Expected a 200 text exposition, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	return rec.Body.String()
+}
+
+// expectLines fails unless every line is in the exposition
+func expectLines(t *testing.T, exposition string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(exposition, "\n"+line+"\n") {
+			t.Errorf("Expected line %q in:\n%s", line, exposition)
+		}
+	}
+}
+
+func TestMetrics_Decisions(t *testing.T) {
+	m := New()
+	store := storage.NewInstrumentedStorage(storage.NewMemoryStorage(0), m.ObserveStorage)
+	rl := limiter.NewRateLimiter(store, limiter.Config{
+		IPLimit:           2,
+		IPBlockDuration:   time.Minute,
+		DefaultTokenLimit: 10,
+	})
+	handler := middleware.RateLimiterMiddleware(rl, middleware.WithObserver(m.Observer(true)))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("API_KEY", "abc123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Test: Failed checks are counted by the key type of the request
+	m.Observer(true)(req, middleware.OutcomeError, limiter.Decision{})
+	m.Observer(false)(req, middleware.OutcomeTimeout, limiter.Decision{})
+
+	if err := m.CountBlocked(context.Background(), store); err != nil {
+		t.Fatalf("CountBlocked failed: %v", err)
+	}
+	exposition := scrape(t, m.Handler())
+	expectLines(t, exposition,
+		`ratelimiter_decisions_total{outcome="allowed",key_type="ip",rule="ip",route=""} 2`,
+		`ratelimiter_decisions_total{outcome="allowed",key_type="token",rule="token_default",route=""} 1`,
+		`ratelimiter_decisions_total{outcome="error",key_type="token",rule="",route=""} 1`,
+		`ratelimiter_decisions_total{outcome="rejected",key_type="ip",rule="ip",route=""} 1`,
+		`ratelimiter_decisions_total{outcome="timeout",key_type="token",rule="",route=""} 1`,
+		`ratelimiter_fail_open_total{outcome="error"} 1`,
+		`ratelimiter_fail_open_total{outcome="timeout"} 0`,
+		`ratelimiter_storage_duration_seconds_count{operation="evaluate"} 4`,
+		`ratelimiter_storage_duration_seconds_bucket{operation="evaluate",le="+Inf"} 4`,
+		`ratelimiter_blocked_keys{key_type="ip"} 1`,
+		`ratelimiter_blocked_keys{key_type="token"} 0`,
+	)
+	if !strings.Contains(exposition, "# TYPE ratelimiter_storage_duration_seconds histogram\n") {
+		t.Errorf("Expected the histogram type, got:\n%s", exposition)
+	}
+}
+
+func TestMetrics_Routes(t *testing.T) {
+	m := New()
+	store := storage.NewMemoryStorage(0)
+	defer store.Close()
+	login := limiter.NewRateLimiter(store, limiter.Config{
+		IPLimit:         1,
+		IPBlockDuration: time.Minute,
+		KeyPrefix:       limiter.RouteKeyPrefix("login"),
+	})
+	handler := middleware.RateLimiterMiddleware(
+		limiter.NewRateLimiter(store, limiter.Config{IPLimit: 10}),
+		middleware.WithObserver(m.Observer(false)),
+		middleware.WithRoutes(middleware.Route{Pattern: "POST /login", Limiter: login}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, target := range []string{"/login", "/login", "/products"} {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Test: Route limits are counted apart from the default limits
+	expectLines(t, scrape(t, m.Handler()),
+		`ratelimiter_decisions_total{outcome="allowed",key_type="ip",rule="ip",route=""} 1`,
+		`ratelimiter_decisions_total{outcome="allowed",key_type="ip",rule="ip",route="POST /login"} 1`,
+		`ratelimiter_decisions_total{outcome="rejected",key_type="ip",rule="ip",route="POST /login"} 1`,
+	)
+}
+
+func TestMetrics_Storage(t *testing.T) {
+	m := New()
+	m.ObserveStorage("get", 3*time.Millisecond, nil)
+	m.ObserveStorage("get", 2*time.Second, errors.New("connection refused"))
+	m.ObserveStorage("get", 5*time.Second, context.DeadlineExceeded)
+
+	exposition := scrape(t, m.Handler())
+	expectLines(t, exposition,
+		`ratelimiter_storage_duration_seconds_bucket{operation="get",le="0.0025"} 0`,
+		`ratelimiter_storage_duration_seconds_bucket{operation="get",le="0.005"} 1`,
+		`ratelimiter_storage_duration_seconds_bucket{operation="get",le="2.5"} 2`,
+		`ratelimiter_storage_duration_seconds_bucket{operation="get",le="+Inf"} 3`,
+		`ratelimiter_storage_duration_seconds_sum{operation="get"} 7.003`,
+		`ratelimiter_storage_duration_seconds_count{operation="get"} 3`,
+		`ratelimiter_storage_errors_total{operation="get"} 2`,
+	)
+
+	// Test: The blocked keys are omitted before they are counted
+	if strings.Contains(exposition, "ratelimiter_blocked_keys") {
+		t.Errorf("Expected no blocked keys before a count, got:\n%s", exposition)
+	}
+}
+
+// failingStorage fails to list the blocked keys
+type failingStorage struct {
+	storage.Storage
+	listed chan struct{}
+}
+
+func (s failingStorage) ListBlocked(context.Context, string) ([]string, error) {
+	s.listed <- struct{}{}
+	return nil, errors.New("connection refused")
+}
+
+func TestMetrics_WatchBlocked(t *testing.T) {
+	m := New()
+	store := storage.NewMemoryStorage(0)
+	defer store.Close()
+	store.SetBlock(context.Background(), "ip:10.0.0.1", time.Minute)
+
+	// Test: The blocked keys are counted right away, not on scrape
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.WatchBlocked(ctx, store, time.Hour, slog.Default())
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(scrape(t, m.Handler()), `ratelimiter_blocked_keys{key_type="ip"} 1`) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the blocked keys to be counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	store.SetBlock(context.Background(), "ip:10.0.0.2", time.Minute)
+	expectLines(t, scrape(t, m.Handler()), `ratelimiter_blocked_keys{key_type="ip"} 1`)
+	cancel()
+	<-done
+
+	// Test: A failed count is logged and drops the gauge
+	var buf bytes.Buffer
+	failing := failingStorage{listed: make(chan struct{})}
+	ctx, cancel = context.WithCancel(context.Background())
+	done = make(chan struct{})
+	go func() {
+		m.WatchBlocked(ctx, failing, time.Hour, slog.New(slog.NewTextHandler(&buf, nil)))
+		close(done)
+	}()
+	<-failing.listed
+	cancel()
+	<-done
+	if !strings.Contains(buf.String(), `msg="Failed to count the blocked keys for metrics"`) {
+		t.Errorf("Expected the failure logged, got %q", buf.String())
+	}
+	if exposition := scrape(t, m.Handler()); strings.Contains(exposition, "ratelimiter_blocked_keys") {
+		t.Errorf("Expected no blocked keys after a failed count, got:\n%s", exposition)
+	}
+}
+
+func TestCountBlocked(t *testing.T) {
+	blocked := countBlocked([]string{
+		"ip:10.0.0.1",
+		"ip:::1:1h0m0s",
+		"token:abc:ip:x",
+		"route:login:ip:10.0.0.1",
+		"route:a:b:token:abc",
+	})
+	if blocked[limiter.RuleIP] != 3 || blocked[limiter.RuleToken] != 2 {
+		t.Errorf("Expected 3 IPs and 2 tokens, got %v", blocked)
+	}
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Client IP headers
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// DefaultIPHeaders are the client IP headers read by default. Only
+// X-Forwarded-For is read, as most proxies append to it but pass the
+// Forwarded and X-Real-IP headers of clients through untouched; proxies
+// setting those must be configured explicitly.
+var DefaultIPHeaders = []string{HeaderXForwardedFor}
+
+// ipExtractor resolves the client IP of a request. Forwarding headers are
+// only honored when the request comes from a trusted proxy, and their chains
+// are walked right to left skipping trusted hops, so clients cannot spoof
+// their address by sending the headers themselves.
+type ipExtractor struct {
+	trustedProxies []netip.Prefix
+	headers        []string
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or single IPs
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if strings.Contains(part, "/") {
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", part, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", part, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// clientIP returns the client IP of a request, or an empty string if it
+// cannot be determined
+func (e *ipExtractor) clientIP(r *http.Request) string {
+	remote, ok := parseHost(r.RemoteAddr)
+	if !ok {
+		return ""
+	}
+	if !e.trusted(remote) {
+		return remote.String()
+	}
+
+	for _, header := range e.headers {
+		var chain []string
+		switch http.CanonicalHeaderKey(header) {
+		case HeaderForwarded:
+			chain = forwardedFor(r.Header.Values(HeaderForwarded))
+		case http.CanonicalHeaderKey(HeaderXRealIP):
+			chain = r.Header.Values(header)
+		default:
+			chain = splitList(r.Header.Values(header))
+		}
+
+		if ip, ok := e.walk(chain); ok {
+			return ip.String()
+		}
+	}
+
+	return remote.String()
+}
+
+// walk returns the rightmost untrusted address of a forwarding chain, or the
+// leftmost one if every hop is trusted. It fails on an empty chain or when
+// the hop to return is not a valid address.
+func (e *ipExtractor) walk(chain []string) (netip.Addr, bool) {
+	if len(chain) == 0 {
+		return netip.Addr{}, false
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip, ok := parseHost(chain[i])
+		if !ok {
+			return netip.Addr{}, false
+		}
+		if !e.trusted(ip) || i == 0 {
+			return ip, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// trusted reports whether an address belongs to a trusted proxy
+func (e *ipExtractor) trusted(ip netip.Addr) bool {
+	for _, prefix := range e.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHost parses an address with an optional port, in brackets for IPv6
+func parseHost(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// splitList splits comma-separated header values into a single list
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+	}
+	return list
+}
+
+// forwardedFor returns the for= parameters of RFC 7239 Forwarded header
+// values, one per forwarded element, in order
+func forwardedFor(values []string) []string {
+	var chain []string
+	for _, element := range splitList(values) {
+		forNode := ""
+		for _, pair := range strings.Split(element, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if strings.EqualFold(name, "for") {
+				forNode = strings.Trim(value, `"`)
+			}
+		}
+		chain = append(chain, forNode)
+	}
+	return chain
+}
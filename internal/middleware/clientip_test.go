@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.10,::1, 2001:db8::/32")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+
+	want := []string{"10.0.0.0/8", "192.168.1.10/32", "::1/128", "2001:db8::/32"}
+	if len(prefixes) != len(want) {
+		t.Fatalf("Expected %d prefixes, got %v", len(want), prefixes)
+	}
+	for i, prefix := range prefixes {
+		if prefix.String() != want[i] {
+			t.Errorf("Prefix %d: expected %s, got %s", i, want[i], prefix)
+		}
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+	if _, err := ParseTrustedProxies("proxy.local"); err == nil {
+		t.Error("Expected error for hostname")
+	}
+}
+
+func TestIPExtractor_ClientIP(t *testing.T) {
+	trusted, _ := ParseTrustedProxies("10.0.0.0/8, 2001:db8:ffff::/48")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		precedence []string
+		want       string
+	}{
+		{
+			name:       "no headers",
+			remoteAddr: "203.0.113.7:5000",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "spoofed X-Forwarded-For from untrusted client",
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "spoofed X-Real-IP from untrusted client",
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string][]string{"X-Real-IP": {"1.2.3.4"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "spoofed Forwarded from untrusted client",
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string][]string{"Forwarded": {"for=1.2.3.4"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "X-Forwarded-For through trusted proxy",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "spoofed leftmost entry is skipped",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.7"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "trusted hops are skipped right to left",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.7, 10.0.0.2, 10.0.0.3"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "chain split across header lines",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4", "203.0.113.7, 10.0.0.2"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "all hops trusted uses leftmost",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.5, 10.0.0.2"}},
+			want:       "10.0.0.5",
+		},
+		{
+			name:       "invalid hop falls back to remote address",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7, not-an-ip"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "X-Real-IP through trusted proxy",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Real-IP": {"203.0.113.7"}},
+			precedence: []string{"X-Real-IP"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "X-Real-IP is ignored by default",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Real-IP": {"1.2.3.4"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "Forwarded with IPv6, port and quotes",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"Forwarded": {`for=1.2.3.4, for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.2;by=10.0.0.1`}},
+			precedence: []string{"Forwarded"},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "Forwarded obfuscated node falls back to next header",
+			remoteAddr: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"Forwarded":       {"for=_hidden"},
+				"X-Forwarded-For": {"203.0.113.7"},
+			},
+			precedence: []string{"Forwarded", "X-Forwarded-For"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "forged Forwarded passed through a trusted proxy is ignored by default",
+			remoteAddr: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"Forwarded":       {"for=198.51.100.1"},
+				"X-Forwarded-For": {"203.0.113.7"},
+			},
+			want: "203.0.113.7",
+		},
+		{
+			name:       "configured precedence",
+			remoteAddr: "10.0.0.1:5000",
+			headers: map[string][]string{
+				"Forwarded":       {"for=198.51.100.1"},
+				"X-Forwarded-For": {"203.0.113.7"},
+			},
+			precedence: []string{"X-Forwarded-For", "Forwarded"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "headers not in precedence are ignored",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			precedence: []string{"X-Real-IP"},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "custom header",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string][]string{"CF-Connecting-IP": {"203.0.113.7"}},
+			precedence: []string{"CF-Connecting-IP"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "IPv6 trusted proxy",
+			remoteAddr: "[2001:db8:ffff::1]:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "IPv4-mapped IPv6 remote address",
+			remoteAddr: "[::ffff:10.0.0.1]:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:       "203.0.113.7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			precedence := tt.precedence
+			if precedence == nil {
+				precedence = DefaultIPHeaders
+			}
+			extractor := &ipExtractor{trustedProxies: trusted, headers: precedence}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+
+			if got := extractor.clientIP(req); got != tt.want {
+				t.Errorf("Expected client IP %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
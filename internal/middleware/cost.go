@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultCostHeader is the response header handlers set to raise the cost
+// of a request with WithCostHeader
+const DefaultCostHeader = "X-RateLimit-Cost"
+
+// CostFunc returns the number of units of the limit a request consumes.
+// Values below 1 count as 1.
+type CostFunc func(r *http.Request) int
+
+// RouteCost is the cost of the requests matching a method and a path prefix
+type RouteCost struct {
+	// Method matches the request method, any method if empty
+	Method string
+	// Path matches the request path and the paths below it, e.g. /export
+	// and /export/ match /export and /export/users but not /exports
+	Path string
+	// Cost is the number of units consumed by a matching request
+	Cost int
+}
+
+// matches reports whether a rule applies to a request
+func (rc RouteCost) matches(r *http.Request) bool {
+	if rc.Method != "" && !strings.EqualFold(rc.Method, r.Method) {
+		return false
+	}
+	prefix := strings.TrimSuffix(rc.Path, "/")
+	return r.URL.Path == rc.Path || r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/")
+}
+
+// RouteCosts returns a CostFunc using the rule with the longest matching
+// path, preferring rules with a method on equal paths. Requests matching no
+// rule cost 1 unit.
+func RouteCosts(rules []RouteCost) CostFunc {
+	return func(r *http.Request) int {
+		var best *RouteCost
+		for i := range rules {
+			rule := &rules[i]
+			if !rule.matches(r) {
+				continue
+			}
+			if best == nil || len(rule.Path) > len(best.Path) ||
+				(len(rule.Path) == len(best.Path) && best.Method == "" && rule.Method != "") {
+				best = rule
+			}
+		}
+		if best == nil {
+			return 1
+		}
+		return best.Cost
+	}
+}
+
+// ParseRouteCosts parses a comma-separated list of [METHOD ]PATH=COST
+// rules, e.g. "POST /export=50, /search=5"
+func ParseRouteCosts(s string) ([]RouteCost, error) {
+	var rules []RouteCost
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		route, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid route cost %q: expected [METHOD ]PATH=COST", part)
+		}
+		cost, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || cost < 1 {
+			return nil, fmt.Errorf("invalid route cost %q: cost must be a positive integer", part)
+		}
+
+		rule := RouteCost{Path: strings.TrimSpace(route), Cost: cost}
+		if method, path, ok := strings.Cut(rule.Path, " "); ok {
+			rule.Method = strings.ToUpper(method)
+			rule.Path = strings.TrimSpace(path)
+		}
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("invalid route cost %q: path must start with /", part)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// costWriter removes the cost header set by the handler from the response
+// and keeps its value
+type costWriter struct {
+	http.ResponseWriter
+	header string
+	cost   int
+	done   bool
+}
+
+// take reads and removes the cost header, once
+func (cw *costWriter) take() {
+	if cw.done {
+		return
+	}
+	cw.done = true
+
+	h := cw.ResponseWriter.Header()
+	if value := h.Get(cw.header); value != "" {
+		cw.cost, _ = strconv.Atoi(strings.TrimSpace(value))
+		h.Del(cw.header)
+	}
+}
+
+// WriteHeader removes the cost header before the headers are sent
+func (cw *costWriter) WriteHeader(code int) {
+	cw.take()
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// Write removes the cost header before the headers are sent
+func (cw *costWriter) Write(b []byte) (int, error) {
+	cw.take()
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends buffered data to the client if the underlying writer supports it
+func (cw *costWriter) Flush() {
+	cw.take()
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (cw *costWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
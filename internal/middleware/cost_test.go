@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+)
+
+func TestParseRouteCosts(t *testing.T) {
+	rules, err := ParseRouteCosts("post /export=50, /search=5,/=1")
+	if err != nil {
+		t.Fatalf("ParseRouteCosts failed: %v", err)
+	}
+
+	want := []RouteCost{
+		{Method: "POST", Path: "/export", Cost: 50},
+		{Path: "/search", Cost: 5},
+		{Path: "/", Cost: 1},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("Expected %d rules, got %v", len(want), rules)
+	}
+	for i, rule := range rules {
+		if rule != want[i] {
+			t.Errorf("Rule %d: expected %+v, got %+v", i, want[i], rule)
+		}
+	}
+
+	for _, invalid := range []string{"/export", "/export=0", "/export=abc", "export=5"} {
+		if _, err := ParseRouteCosts(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestRouteCosts(t *testing.T) {
+	cost := RouteCosts([]RouteCost{
+		{Path: "/export", Cost: 10},
+		{Method: "POST", Path: "/export", Cost: 50},
+		{Path: "/export/small", Cost: 2},
+		{Path: "/search/", Cost: 5},
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{"GET", "/export", 10},
+		{"POST", "/export", 50},
+		{"POST", "/export/users", 50},
+		{"POST", "/export/small", 2},
+		{"GET", "/exports", 1},
+		{"GET", "/search", 5},
+		{"GET", "/search/users", 5},
+		{"GET", "/", 1},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := cost(req); got != tt.want {
+			t.Errorf("%s %s: expected cost %d, got %d", tt.method, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestRateLimiterMiddleware_Cost(t *testing.T) {
+	rl := limiter.NewRateLimiter(NewMockStorage(), limiter.Config{
+		IPLimit:         100,
+		IPBlockDuration: 5 * time.Second,
+	})
+
+	handler := RateLimiterMiddleware(rl,
+		WithCost(RouteCosts([]RouteCost{{Path: "/search", Cost: 5}})),
+		WithCostHeader(DefaultCostHeader),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/export" {
+			w.Header().Set(DefaultCostHeader, "50")
+		}
+		w.Write([]byte("OK"))
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test: Route cost is checked before the request is handled
+	w := serve("/search")
+	if w.Code != http.StatusOK || w.Header().Get(headerRateLimitRemaining) != "95" {
+		t.Fatalf("Expected status 200 with 95 remaining, got %d with %q", w.Code, w.Header().Get(headerRateLimitRemaining))
+	}
+
+	// Test: Handler-set cost is charged after the response and not exposed
+	w = serve("/export")
+	if w.Code != http.StatusOK || w.Header().Get(DefaultCostHeader) != "" {
+		t.Fatalf("Expected status 200 without the cost header, got %d with %q", w.Code, w.Header().Get(DefaultCostHeader))
+	}
+
+	w = serve("/")
+	if w.Header().Get(headerRateLimitRemaining) != "44" {
+		t.Fatalf("Expected 44 remaining after the export, got %q", w.Header().Get(headerRateLimitRemaining))
+	}
+
+	// Test: Next export exceeds the remaining units
+	serve("/export")
+	if w = serve("/"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 after the second export, got %d", w.Code)
+	}
+}
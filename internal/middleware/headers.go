@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+)
+
+// Rate limit response headers
+const (
+	headerRetryAfter         = "Retry-After"
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+	headerXRateLimitLimit    = "X-RateLimit-Limit"
+	headerXRateLimitRemain   = "X-RateLimit-Remaining"
+	headerXRateLimitReset    = "X-RateLimit-Reset"
+)
+
+// writeRateLimitHeaders sets the IETF RateLimit headers for a decision and,
+// if legacy is true, the X-RateLimit headers with the reset as a Unix time
+func writeRateLimitHeaders(h http.Header, decision limiter.Decision, now time.Time, legacy bool) {
+	remaining := decision.Remaining
+	if remaining < 0 {
+		remaining = 0
+	}
+	reset := ceilSeconds(decision.ResetAt.Sub(now))
+
+	h.Set(headerRateLimitLimit, strconv.Itoa(decision.Limit))
+	h.Set(headerRateLimitRemaining, strconv.Itoa(remaining))
+	h.Set(headerRateLimitReset, strconv.FormatInt(reset, 10))
+
+	if legacy {
+		h.Set(headerXRateLimitLimit, strconv.Itoa(decision.Limit))
+		h.Set(headerXRateLimitRemain, strconv.Itoa(remaining))
+		h.Set(headerXRateLimitReset, strconv.FormatInt(now.Unix()+reset, 10))
+	}
+}
+
+// writeRetryAfter sets the Retry-After header in seconds for a rejected
+// decision, falling back to the reset time when no retry delay is known
+func writeRetryAfter(h http.Header, decision limiter.Decision, now time.Time) {
+	h.Set(headerRetryAfter, strconv.FormatInt(retryAfterSeconds(decision, now), 10))
+}
+
+// retryAfterSeconds returns how many seconds the client of a rejected
+// request should wait, at least 1
+func retryAfterSeconds(decision limiter.Decision, now time.Time) int64 {
+	retryAfter := decision.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = decision.ResetAt.Sub(now)
+	}
+
+	seconds := ceilSeconds(retryAfter)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// ceilSeconds rounds a duration up to whole seconds, never below zero
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// WriteRateLimitHeaders sets the headers the middleware responds with for a
+// decision: the RateLimit headers, the X-RateLimit headers if legacy is
+// true, and Retry-After if the request was rejected. It lets other front
+// ends, e.g. the Envoy rate limit service, respond like the middleware.
+func WriteRateLimitHeaders(h http.Header, decision limiter.Decision, now time.Time, legacy bool) {
+	writeRateLimitHeaders(h, decision, now, legacy)
+	if !decision.Allowed {
+		writeRetryAfter(h, decision, now)
+	}
+}
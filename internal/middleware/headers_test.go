@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+)
+
+func TestWriteRateLimitHeaders(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	decision := limiter.Decision{
+		Allowed:   true,
+		Limit:     10,
+		Remaining: 7,
+		ResetAt:   now.Add(1500 * time.Millisecond),
+	}
+
+	tests := []struct {
+		name   string
+		legacy bool
+		want   map[string]string
+	}{
+		{
+			name: "ietf",
+			want: map[string]string{
+				"RateLimit-Limit":     "10",
+				"RateLimit-Remaining": "7",
+				"RateLimit-Reset":     "2",
+				"X-RateLimit-Limit":   "",
+			},
+		},
+		{
+			name:   "legacy",
+			legacy: true,
+			want: map[string]string{
+				"RateLimit-Limit":       "10",
+				"X-RateLimit-Limit":     "10",
+				"X-RateLimit-Remaining": "7",
+				"X-RateLimit-Reset":     "1700000002",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			writeRateLimitHeaders(h, decision, now, tt.legacy)
+			for name, want := range tt.want {
+				if got := h.Get(name); got != want {
+					t.Errorf("%s: expected %q, got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteRetryAfter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name     string
+		decision limiter.Decision
+		want     string
+	}{
+		{"rounds up", limiter.Decision{RetryAfter: 250 * time.Millisecond}, "1"},
+		{"block ttl", limiter.Decision{Blocked: true, RetryAfter: 299*time.Second + time.Millisecond}, "300"},
+		{"falls back to reset", limiter.Decision{ResetAt: now.Add(3 * time.Second)}, "3"},
+		{"at least one second", limiter.Decision{}, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			writeRetryAfter(h, tt.decision, now)
+			if got := h.Get("Retry-After"); got != tt.want {
+				t.Errorf("Expected Retry-After %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddleware_Headers(t *testing.T) {
+	storage := NewMockStorage()
+	config := limiter.Config{
+		IPLimit:         2,
+		IPBlockDuration: 60 * time.Second,
+	}
+	rl := limiter.NewRateLimiter(storage, config)
+
+	handler := RateLimiterMiddleware(rl, WithLegacyHeaders())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 1; i <= 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Limit") != "2" {
+			t.Fatalf("Request %d: expected limit headers, got %v", i, w.Header())
+		}
+
+		switch i {
+		case 1, 2:
+			if w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != strconv.Itoa(2-i) {
+				t.Fatalf("Request %d: expected 200 with remaining %d, got %d %v", i, 2-i, w.Code, w.Header())
+			}
+			if w.Header().Get("Retry-After") != "" {
+				t.Fatalf("Request %d: expected no Retry-After on allowed response", i)
+			}
+		case 3:
+			if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+				t.Fatalf("Expected 429 with Retry-After 60, got %d %v", w.Code, w.Header())
+			}
+		}
+	}
+}
@@ -2,94 +2,446 @@ package middleware
 
 import (
 	"context"
-	"net"
+	"errors"
+	"log/slog"
 	"net/http"
-	"strings"
+	"net/netip"
+	"time"
 
 	"github.com/allis/rate-limiter/internal/limiter"
+	"github.com/allis/rate-limiter/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	apiKeyHeader     = "API_KEY"
 	rateLimitMessage = "you have reached the maximum number of requests or actions allowed within a certain time frame"
+	tracerName       = "github.com/allis/rate-limiter/internal/middleware"
 )
 
+// Option configures the rate limiter middleware
+type Option func(*options)
+
+// options holds the middleware settings
+type options struct {
+	legacyHeaders  bool
+	responder      Responder
+	trustedProxies []netip.Prefix
+	ipHeaders      []string
+	failOpen       bool
+	timeout        time.Duration
+	observer       Observer
+	cost           CostFunc
+	costHeader     string
+	routes         []Route
+	bypass         []string
+	routeTable     *RouteTable
+	logger         *slog.Logger
+	tracerProvider trace.TracerProvider
+	now            func() time.Time
+}
+
+// Outcome is the result of the rate limit check of a request
+type Outcome string
+
+// Rate limit check outcomes
+const (
+	// OutcomeAllowed means the request was within the limit
+	OutcomeAllowed Outcome = "allowed"
+	// OutcomeRejected means the request exceeded the limit or was blocked
+	OutcomeRejected Outcome = "rejected"
+	// OutcomeError means the check failed, e.g. the storage is unavailable
+	OutcomeError Outcome = "error"
+	// OutcomeTimeout means the check did not complete within the timeout
+	OutcomeTimeout Outcome = "timeout"
+	// OutcomeCanceled means the client went away during the check
+	OutcomeCanceled Outcome = "canceled"
+	// OutcomeNoClientIP means the request was rejected without a check, as
+	// it has neither a token nor a client IP
+	OutcomeNoClientIP Outcome = "no_client_ip"
+)
+
+// Observer is called with the outcome of the rate limit check of every
+// request, e.g. to count outcomes. The decision is empty unless the outcome
+// is allowed or rejected.
+type Observer func(r *http.Request, outcome Outcome, decision limiter.Decision)
+
+// WithLegacyHeaders also emits the X-RateLimit-Limit, X-RateLimit-Remaining
+// and X-RateLimit-Reset headers
+func WithLegacyHeaders() Option {
+	return func(o *options) {
+		o.legacyHeaders = true
+	}
+}
+
+// WithResponder sets the responder that writes rejected requests, plain
+// text with the default message if not set
+func WithResponder(responder Responder) Option {
+	return func(o *options) {
+		o.responder = responder
+	}
+}
+
+// WithTrustedProxies sets the proxies whose forwarding headers are trusted.
+// Without trusted proxies the client IP is always the connection address.
+func WithTrustedProxies(prefixes []netip.Prefix) Option {
+	return func(o *options) {
+		o.trustedProxies = prefixes
+	}
+}
+
+// WithIPHeaders sets the headers read from trusted proxies, in order of
+// precedence. Defaults to DefaultIPHeaders.
+func WithIPHeaders(headers []string) Option {
+	return func(o *options) {
+		o.ipHeaders = headers
+	}
+}
+
+// WithFailOpen lets requests through and logs the error when the rate
+// limiter fails, e.g. because the storage is unavailable. By default such
+// requests fail closed with 500 Internal Server Error.
+func WithFailOpen() Option {
+	return func(o *options) {
+		o.failOpen = true
+	}
+}
+
+// WithTimeout bounds each rate limit check, including its storage calls, to
+// timeout. Checks that time out respond 503 Service Unavailable, or let the
+// request through with WithFailOpen. Zero means no timeout besides the
+// request context.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithObserver sets a function called with the outcome of every check
+func WithObserver(observer Observer) Option {
+	return func(o *options) {
+		o.observer = observer
+	}
+}
+
+// WithCost sets the function resolving the number of units each request
+// consumes, e.g. RouteCosts. Every request costs 1 unit if not set.
+func WithCost(cost CostFunc) Option {
+	return func(o *options) {
+		o.cost = cost
+	}
+}
+
+// WithCostHeader lets handlers raise the cost of a request by setting the
+// named response header, e.g. DefaultCostHeader: 50 for a bulk export whose
+// size is only known once handled. The header is removed from the response
+// and the units above the cost already checked are charged to the same
+// client once the handler returns, so they count against its next requests.
+func WithCostHeader(name string) Option {
+	return func(o *options) {
+		o.costHeader = name
+	}
+}
+
+// WithRoutes applies the limits of the matching route instead of the
+// default rate limiter. Requests match the most specific pattern, following
+// the rules of http.ServeMux.
+func WithRoutes(routes ...Route) Option {
+	return func(o *options) {
+		o.routes = append(o.routes, routes...)
+	}
+}
+
+// WithBypass excludes the requests matching any of the http.ServeMux
+// patterns from rate limiting, e.g. "GET /health" or "/metrics"
+func WithBypass(patterns ...string) Option {
+	return func(o *options) {
+		o.bypass = append(o.bypass, patterns...)
+	}
+}
+
+// WithRouteTable applies the routes and bypassed patterns of a table that
+// can be updated while serving, instead of WithRoutes and WithBypass
+func WithRouteTable(table *RouteTable) Option {
+	return func(o *options) {
+		o.routeTable = table
+	}
+}
+
+// WithLogger sets the logger every rejected request and failed check is
+// written to, slog.Default() if not set. Wrap its handler with
+// logging.NewSamplingHandler so a flood of rejections does not drown the logs.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithTracerProvider sets the provider of the "ratelimit.check" span created
+// for every check, otel.GetTracerProvider() if not set. The storage calls of
+// the check run within the span.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = provider
+	}
+}
+
 // RateLimiterMiddleware creates a middleware that applies rate limiting
-func RateLimiterMiddleware(rateLimiter *limiter.RateLimiter) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx := context.Background()
+// with rateLimiter to the requests matching no route. It panics on invalid
+// or conflicting route and bypass patterns.
+func RateLimiterMiddleware(rateLimiter *limiter.RateLimiter, opts ...Option) func(http.Handler) http.Handler {
+	o := options{
+		responder: PlainResponder(rateLimitMessage),
+		ipHeaders: DefaultIPHeaders,
+		logger:    slog.Default(),
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.tracerProvider == nil {
+		o.tracerProvider = otel.GetTracerProvider()
+	}
+	tracer := o.tracerProvider.Tracer(tracerName)
 
-			// Check for API_KEY in header
-			token := r.Header.Get(apiKeyHeader)
+	extractor := &ipExtractor{
+		trustedProxies: o.trustedProxies,
+		headers:        o.ipHeaders,
+	}
 
-			// If token is present, use token-based rate limiting
-			if token != "" {
-				allowed, err := rateLimiter.AllowToken(ctx, token)
-				if err != nil {
-					http.Error(w, "Internal server error", http.StatusInternalServerError)
-					return
-				}
+	routes := o.routeTable
+	if routes == nil && (len(o.routes) > 0 || len(o.bypass) > 0) {
+		var err error
+		if routes, err = NewRouteTable(o.routes, o.bypass); err != nil {
+			panic(err)
+		}
+	}
 
-				if !allowed {
-					w.WriteHeader(http.StatusTooManyRequests)
-					w.Write([]byte(rateLimitMessage))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rl := rateLimiter
+			if routes != nil {
+				var pattern string
+				if rl, pattern = routes.match(r, rateLimiter); rl == nil {
+					next.ServeHTTP(w, r)
 					return
 				}
-
-				// Token is allowed, proceed with request
-				next.ServeHTTP(w, r)
-				return
+				r = withRoute(r, pattern)
 			}
 
-			// No token, use IP-based rate limiting
-			ip := getIP(r)
-			if ip == "" {
+			cost := o.requestCost(r)
+			ctx, span := tracer.Start(r.Context(), "ratelimit.check",
+				trace.WithAttributes(
+					attribute.String("ratelimit.key_type", KeyType(r)),
+					attribute.Int("ratelimit.cost", cost)))
+			decision, err := o.check(ctx, r, rl, extractor, cost)
+			endCheckSpan(span, checkOutcome(r, decision, err), decision, err)
+
+			switch {
+			case err == nil:
+			case errors.Is(err, errNoClientIP):
+				o.observe(r, OutcomeNoClientIP, limiter.Decision{})
+				o.logger.WarnContext(r.Context(), "Unable to determine client IP, rejecting request",
+					"remote_addr", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
 				http.Error(w, "Unable to determine IP address", http.StatusBadRequest)
 				return
-			}
-
-			allowed, err := rateLimiter.AllowIP(ctx, ip)
-			if err != nil {
+			case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+				// The client went away, there is no one to respond to
+				o.observe(r, OutcomeCanceled, limiter.Decision{})
+				return
+			case errors.Is(err, limiter.ErrTimeout):
+				o.observe(r, OutcomeTimeout, limiter.Decision{})
+				if o.failOpen {
+					o.logFailure(r, extractor, slog.LevelWarn, "Rate limit check timed out, allowing request", err)
+					o.serve(next, w, r, nil)
+					return
+				}
+				o.logFailure(r, extractor, slog.LevelWarn, "Rate limit check timed out, rejecting request", err)
+				w.Header().Set(headerRetryAfter, "1")
+				http.Error(w, "Rate limit check timed out", http.StatusServiceUnavailable)
+				return
+			default:
+				o.observe(r, OutcomeError, limiter.Decision{})
+				if o.failOpen {
+					o.logFailure(r, extractor, slog.LevelError, "Rate limiter unavailable, allowing request", err)
+					o.serve(next, w, r, nil)
+					return
+				}
+				o.logFailure(r, extractor, slog.LevelError, "Rate limiter unavailable, rejecting request", err)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 				return
 			}
 
-			if !allowed {
-				w.WriteHeader(http.StatusTooManyRequests)
-				w.Write([]byte(rateLimitMessage))
+			now := o.now()
+			writeRateLimitHeaders(w.Header(), decision, now, o.legacyHeaders)
+
+			if !decision.Allowed {
+				o.observe(r, OutcomeRejected, decision)
+				o.logRejection(r, extractor, decision, now)
+				writeRetryAfter(w.Header(), decision, now)
+				o.responder.Respond(w, r, decision)
 				return
 			}
 
 			// Request is allowed, proceed
-			next.ServeHTTP(w, r)
+			o.observe(r, OutcomeAllowed, decision)
+			o.serve(next, w, r, func(cost int) {
+				if extra := cost - decision.Cost; extra > 0 {
+					o.charge(r, rl, extractor, extra)
+				}
+			})
 		})
 	}
 }
 
-// getIP extracts the IP address from the request
-func getIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		// Take the first IP in the list
-		ips := strings.Split(forwarded, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
+// errNoClientIP is returned by check when the client IP cannot be determined
+var errNoClientIP = errors.New("unable to determine client IP")
+
+// check runs the rate limit check of a request consuming n units within
+// ctx, bounded by the configured timeout
+func (o *options) check(ctx context.Context, r *http.Request, rateLimiter *limiter.RateLimiter, extractor *ipExtractor, n int) (limiter.Decision, error) {
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	keyType, id := identify(r, extractor)
+	switch {
+	case keyType == limiter.RuleToken:
+		return rateLimiter.CheckTokenN(ctx, id, n)
+	case id == "":
+		return limiter.Decision{}, errNoClientIP
+	default:
+		return rateLimiter.CheckIPN(ctx, id, n)
 	}
+}
+
+// checkOutcome returns the outcome of a check of a request that returned
+// decision and err
+func checkOutcome(r *http.Request, decision limiter.Decision, err error) Outcome {
+	switch {
+	case err == nil && decision.Allowed:
+		return OutcomeAllowed
+	case err == nil:
+		return OutcomeRejected
+	case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+		return OutcomeCanceled
+	case errors.Is(err, limiter.ErrTimeout):
+		return OutcomeTimeout
+	case errors.Is(err, errNoClientIP):
+		return OutcomeNoClientIP
+	default:
+		return OutcomeError
+	}
+}
+
+// endCheckSpan ends the span of a check with its outcome and decision. Failed
+// checks set the span status to error, rejected requests do not.
+func endCheckSpan(span trace.Span, outcome Outcome, decision limiter.Decision, err error) {
+	span.SetAttributes(attribute.String("ratelimit.decision", string(outcome)))
+	if err == nil {
+		span.SetAttributes(
+			attribute.String("ratelimit.rule", decision.Rule),
+			attribute.Int("ratelimit.limit", decision.Limit),
+			attribute.Int("ratelimit.remaining", decision.Remaining),
+			attribute.Bool("ratelimit.blocked", decision.Blocked))
+	} else if outcome != OutcomeCanceled {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// identify returns the key type and the token or IP a request is limited
+// by: the API_KEY header if present, the client IP otherwise, empty if it
+// cannot be determined
+func identify(r *http.Request, extractor *ipExtractor) (keyType, id string) {
+	if token := r.Header.Get(apiKeyHeader); token != "" {
+		return limiter.RuleToken, token
+	}
+	return limiter.RuleIP, extractor.clientIP(r)
+}
+
+// KeyType returns the kind of key the requests are limited by,
+// limiter.RuleToken with an API_KEY header and limiter.RuleIP otherwise
+func KeyType(r *http.Request) string {
+	if r.Header.Get(apiKeyHeader) != "" {
+		return limiter.RuleToken
+	}
+	return limiter.RuleIP
+}
 
-	// Check X-Real-IP header
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
+// requestCost returns the number of units a request consumes before it is
+// handled
+func (o *options) requestCost(r *http.Request) int {
+	if o.cost == nil {
+		return 1
 	}
+	if cost := o.cost(r); cost > 1 {
+		return cost
+	}
+	return 1
+}
 
-	// Use RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+// serve calls next and, with WithCostHeader, removes the cost header from
+// the response and passes its value to charge, if not nil
+func (o *options) serve(next http.Handler, w http.ResponseWriter, r *http.Request, charge func(cost int)) {
+	if o.costHeader == "" {
+		next.ServeHTTP(w, r)
+		return
 	}
 
-	return ip
+	cw := &costWriter{ResponseWriter: w, header: o.costHeader}
+	next.ServeHTTP(cw, r)
+	cw.take()
+	if charge != nil && cw.cost > 0 {
+		charge(cw.cost)
+	}
+}
+
+// charge counts n more units for the client of a request that was already
+// handled. It runs even if the client went away, and the result only
+// affects the next requests of the client.
+func (o *options) charge(r *http.Request, rateLimiter *limiter.RateLimiter, extractor *ipExtractor, n int) {
+	if _, err := o.check(context.WithoutCancel(r.Context()), r, rateLimiter, extractor, n); err != nil {
+		o.logFailure(r, extractor, slog.LevelWarn, "Failed to charge the request cost", err)
+	}
+}
+
+// logRejection logs a rejected request with its client hashed, so tokens
+// are not written to the logs
+func (o *options) logRejection(r *http.Request, extractor *ipExtractor, decision limiter.Decision, now time.Time) {
+	keyType, id := identify(r, extractor)
+	o.logger.InfoContext(r.Context(), "Request rejected",
+		"key_type", keyType,
+		"identity", logging.HashIdentity(id),
+		"rule", decision.Rule,
+		"retry_after", retryAfterSeconds(decision, now),
+		"blocked", decision.Blocked,
+		"method", r.Method,
+		"path", r.URL.Path)
+}
+
+// logFailure logs a failed rate limit check of a request, the only record
+// of the failure, as the limiter returns storage failures without logging
+// them. Like rejections, the client is hashed.
+func (o *options) logFailure(r *http.Request, extractor *ipExtractor, level slog.Level, msg string, err error) {
+	keyType, id := identify(r, extractor)
+	o.logger.Log(r.Context(), level, msg,
+		"key_type", keyType,
+		"identity", logging.HashIdentity(id),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"error", err)
+}
+
+// observe reports the outcome of a check to the observer, if any
+func (o *options) observe(r *http.Request, outcome Outcome, decision limiter.Decision) {
+	if o.observer != nil {
+		o.observer(r, outcome, decision)
+	}
 }
@@ -1,13 +1,26 @@
 package middleware
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/allis/rate-limiter/internal/limiter"
+	"github.com/allis/rate-limiter/internal/logging"
+	"github.com/allis/rate-limiter/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // MockStorage for testing
@@ -28,6 +41,11 @@ func (m *MockStorage) Increment(ctx context.Context, key string, expiration time
 	return m.counters[key], nil
 }
 
+func (m *MockStorage) IncrementBy(ctx context.Context, key string, n int64, expiration time.Duration) (int64, error) {
+	m.counters[key] += n
+	return m.counters[key], nil
+}
+
 func (m *MockStorage) Get(ctx context.Context, key string) (int64, error) {
 	return m.counters[key], nil
 }
@@ -51,10 +69,56 @@ func (m *MockStorage) TTL(ctx context.Context, key string) (time.Duration, error
 	return 0, nil
 }
 
+func (m *MockStorage) SetBlockWithReason(ctx context.Context, key string, duration time.Duration, reason string) error {
+	return m.SetBlock(ctx, key, duration)
+}
+
+func (m *MockStorage) BlockReason(ctx context.Context, key string) (string, error) {
+	return "", nil
+}
+
+func (m *MockStorage) Unblock(ctx context.Context, key string) error {
+	delete(m.blocks, key)
+	return nil
+}
+
+func (m *MockStorage) Delete(ctx context.Context, key string) error {
+	delete(m.counters, key)
+	delete(m.blocks, key)
+	return nil
+}
+
+func (m *MockStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (m *MockStorage) ListBlocked(ctx context.Context, prefix string) ([]string, error) {
+	return nil, nil
+}
+
 func (m *MockStorage) Close() error {
 	return nil
 }
 
+// FailingStorage fails every call as an unavailable backend would
+type FailingStorage struct {
+	MockStorage
+}
+
+var errStorageUnavailable = errors.New("connection refused")
+
+func (f *FailingStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	return false, errStorageUnavailable
+}
+
+// HangingStrategy never answers before the context is done
+type HangingStrategy struct{}
+
+func (HangingStrategy) Allow(ctx context.Context, key string, window limiter.Window, cost int) (storage.Result, error) {
+	<-ctx.Done()
+	return storage.Result{}, ctx.Err()
+}
+
 func TestRateLimiterMiddleware_IP(t *testing.T) {
 	storage := NewMockStorage()
 	config := limiter.Config{
@@ -147,8 +211,9 @@ func TestRateLimiterMiddleware_XForwardedFor(t *testing.T) {
 		IPBlockDuration: 5 * time.Second,
 	}
 	rl := limiter.NewRateLimiter(storage, config)
+	trusted, _ := ParseTrustedProxies("10.0.0.0/8")
 
-	handler := RateLimiterMiddleware(rl)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := RateLimiterMiddleware(rl, WithTrustedProxies(trusted))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	}))
@@ -179,3 +244,310 @@ func TestRateLimiterMiddleware_XForwardedFor(t *testing.T) {
 		t.Fatalf("Expected status 429, got %d", w.Code)
 	}
 }
+
+func TestRateLimiterMiddleware_ForgedForwarded(t *testing.T) {
+	storage := NewMockStorage()
+	config := limiter.Config{
+		IPLimit:         2,
+		IPBlockDuration: 5 * time.Second,
+	}
+	rl := limiter.NewRateLimiter(storage, config)
+	trusted, _ := ParseTrustedProxies("10.0.0.0/8")
+
+	handler := RateLimiterMiddleware(rl, WithTrustedProxies(trusted))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Test: The trusted proxy only appends X-Forwarded-For, passing the
+	// Forwarded header of the client through, so changing it must not
+	// change the IP the client is limited as
+	for i := 1; i <= 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:12345"
+		req.Header.Set("Forwarded", fmt.Sprintf("for=198.51.100.%d", i))
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		want := http.StatusOK
+		if i > 2 {
+			want = http.StatusTooManyRequests
+		}
+		if w.Code != want {
+			t.Fatalf("Request %d: expected status %d, got %d", i, want, w.Code)
+		}
+	}
+}
+
+func TestRateLimiterMiddleware_FailureMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantStatus int
+	}{
+		{"fail closed", nil, http.StatusInternalServerError},
+		{"fail open", []Option{WithFailOpen()}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := limiter.NewRateLimiter(&FailingStorage{}, limiter.Config{IPLimit: 1})
+			handler := RateLimiterMiddleware(rl, tt.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "192.168.1.1:12345"
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddleware_Timeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantStatus int
+	}{
+		{"fail closed", nil, http.StatusServiceUnavailable},
+		{"fail open", []Option{WithFailOpen()}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := limiter.NewRateLimiter(NewMockStorage(), limiter.Config{IPLimit: 1})
+			rl.RegisterStrategy(limiter.FixedWindow, HangingStrategy{})
+
+			var outcomes []Outcome
+			opts := append([]Option{
+				WithTimeout(10 * time.Millisecond),
+				WithObserver(func(r *http.Request, outcome Outcome, decision limiter.Decision) {
+					outcomes = append(outcomes, outcome)
+				}),
+			}, tt.opts...)
+			handler := RateLimiterMiddleware(rl, opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "192.168.1.1:12345"
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if len(outcomes) != 1 || outcomes[0] != OutcomeTimeout {
+				t.Fatalf("Expected a single timeout outcome, got %v", outcomes)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddleware_ClientCanceled(t *testing.T) {
+	rl := limiter.NewRateLimiter(NewMockStorage(), limiter.Config{IPLimit: 1})
+	rl.RegisterStrategy(limiter.FixedWindow, HangingStrategy{})
+
+	var outcomes []Outcome
+	handler := RateLimiterMiddleware(rl, WithObserver(func(r *http.Request, outcome Outcome, decision limiter.Decision) {
+		outcomes = append(outcomes, outcome)
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("Expected canceled request not to reach the handler")
+	}))
+
+	// Test: Request context reaches the storage calls
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	req.RemoteAddr = "192.168.1.1:12345"
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if len(outcomes) != 1 || outcomes[0] != OutcomeCanceled {
+		t.Fatalf("Expected a single canceled outcome, got %v", outcomes)
+	}
+}
+
+func TestRateLimiterMiddleware_Observer(t *testing.T) {
+	rl := limiter.NewRateLimiter(NewMockStorage(), limiter.Config{IPLimit: 1, IPBlockDuration: time.Second})
+
+	var outcomes []Outcome
+	handler := RateLimiterMiddleware(rl, WithObserver(func(r *http.Request, outcome Outcome, decision limiter.Decision) {
+		outcomes = append(outcomes, outcome)
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if len(outcomes) != 2 || outcomes[0] != OutcomeAllowed || outcomes[1] != OutcomeRejected {
+		t.Fatalf("Expected allowed then rejected outcomes, got %v", outcomes)
+	}
+
+	// Test: Requests without a client IP are observed before the 400
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "not-an-address"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || len(outcomes) != 3 || outcomes[2] != OutcomeNoClientIP {
+		t.Fatalf("Expected a 400 observed as %s, got %d and %v", OutcomeNoClientIP, w.Code, outcomes)
+	}
+}
+
+// logRecords decodes the JSON records written to buf
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Expected JSON records, got %q: %v", buf.String(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRateLimiterMiddleware_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	rl := limiter.NewRateLimiter(NewMockStorage(), limiter.Config{DefaultTokenLimit: 1, DefaultTokenBlockDuration: time.Minute})
+	handler := RateLimiterMiddleware(rl, WithLogger(logger))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/orders", nil)
+		req.Header.Set("API_KEY", "secret-token")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Test: One record per rejected request, with the token hashed
+	records := logRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected a single rejection record, got %v", records)
+	}
+	want := map[string]any{
+		"msg":         "Request rejected",
+		"key_type":    "token",
+		"identity":    logging.HashIdentity("secret-token"),
+		"rule":        limiter.RuleDefaultToken,
+		"retry_after": float64(60),
+		"path":        "/orders",
+	}
+	for key, value := range want {
+		if records[0][key] != value {
+			t.Errorf("Expected %s=%v, got %v", key, value, records[0][key])
+		}
+	}
+	if strings.Contains(buf.String(), "secret-token") {
+		t.Errorf("Expected the token to be hashed, got %s", buf.String())
+	}
+
+	// Test: Failed checks are logged once with the error instead of swallowed
+	buf.Reset()
+	rl = limiter.NewRateLimiter(&FailingStorage{}, limiter.Config{IPLimit: 1})
+	handler = RateLimiterMiddleware(rl, WithLogger(logger))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	records = logRecords(t, &buf)
+	if len(records) != 1 || records[0]["msg"] != "Rate limiter unavailable, rejecting request" || records[0]["level"] != "ERROR" {
+		t.Fatalf("Expected a single rejection record, got %v", records)
+	}
+	if !strings.Contains(records[0]["error"].(string), errStorageUnavailable.Error()) {
+		t.Errorf("Expected the storage error, got %v", records[0]["error"])
+	}
+	if records[0]["identity"] != logging.HashIdentity("192.0.2.1") {
+		t.Errorf("Expected the hashed client IP, got %v", records[0]["identity"])
+	}
+}
+
+// spanAttributes returns the attributes of span by key
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestRateLimiterMiddleware_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	rl := limiter.NewRateLimiter(NewMockStorage(), limiter.Config{DefaultTokenLimit: 2, DefaultTokenBlockDuration: time.Minute})
+
+	var handlerSpan trace.SpanContext
+	handler := RateLimiterMiddleware(rl, WithTracerProvider(provider))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+	}))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		req.Header.Set("API_KEY", "abc123")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("Expected 3 check spans and the request span, got %d", len(spans))
+	}
+
+	// Test: Check spans are children of the request span, which the handler keeps
+	if spans[0].Name() != "ratelimit.check" || spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("Expected a check span child of the request span, got %q", spans[0].Name())
+	}
+	if handlerSpan.SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Expected the handler to run within the request span")
+	}
+
+	attrs := spanAttributes(spans[1])
+	if attrs["ratelimit.decision"].AsString() != "allowed" || attrs["ratelimit.key_type"].AsString() != "token" ||
+		attrs["ratelimit.rule"].AsString() != limiter.RuleDefaultToken || attrs["ratelimit.remaining"].AsInt64() != 0 {
+		t.Errorf("Expected the allowed decision of the token, got %v", attrs)
+	}
+
+	// Test: Rejected requests are not errors
+	attrs = spanAttributes(spans[2])
+	if attrs["ratelimit.decision"].AsString() != "rejected" || !attrs["ratelimit.blocked"].AsBool() {
+		t.Errorf("Expected a rejected decision, got %v", attrs)
+	}
+	if spans[2].Status().Code != codes.Unset {
+		t.Errorf("Expected a rejected request not to be an error, got %v", spans[2].Status())
+	}
+}
+
+func TestRateLimiterMiddleware_TracingFailure(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	rl := limiter.NewRateLimiter(&FailingStorage{}, limiter.Config{IPLimit: 1})
+	handler := RateLimiterMiddleware(rl, WithTracerProvider(provider), WithFailOpen())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.168.1.1:12345"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected a check span, got %d", len(spans))
+	}
+	if spans[0].Status().Code != codes.Error || spanAttributes(spans[0])["ratelimit.decision"].AsString() != "error" {
+		t.Errorf("Expected an error span, got %v %v", spans[0].Status(), spans[0].Attributes())
+	}
+	if len(spans[0].Events()) != 1 || spans[0].Events()[0].Name != "exception" {
+		t.Errorf("Expected the error recorded, got %v", spans[0].Events())
+	}
+}
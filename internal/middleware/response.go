@@ -0,0 +1,268 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+)
+
+// Media types of the built-in responders
+const (
+	mediaTypePlain   = "text/plain"
+	mediaTypeHTML    = "text/html"
+	mediaTypeJSON    = "application/json"
+	mediaTypeProblem = "application/problem+json"
+)
+
+// ResponseFormat names a built-in rejection response format
+type ResponseFormat string
+
+// Supported response formats
+const (
+	FormatPlain     ResponseFormat = "plain"
+	FormatJSON      ResponseFormat = "json"
+	FormatProblem   ResponseFormat = "problem"
+	FormatHTML      ResponseFormat = "html"
+	FormatNegotiate ResponseFormat = "negotiate"
+)
+
+// Responder writes the response for a request rejected by the rate limiter.
+// The rate limit and Retry-After headers are already set when it is called.
+type Responder interface {
+	Respond(w http.ResponseWriter, r *http.Request, decision limiter.Decision)
+}
+
+// ResponderFunc adapts a function to the Responder interface
+type ResponderFunc func(w http.ResponseWriter, r *http.Request, decision limiter.Decision)
+
+// Respond calls f(w, r, decision)
+func (f ResponderFunc) Respond(w http.ResponseWriter, r *http.Request, decision limiter.Decision) {
+	f(w, r, decision)
+}
+
+// rejection holds the fields exposed by the JSON and template responders
+type rejection struct {
+	Message    string `json:"message"`
+	Limit      int    `json:"limit"`
+	Remaining  int    `json:"remaining"`
+	RetryAfter int64  `json:"retry_after"`
+	Window     int64  `json:"window,omitempty"`
+	Rule       string `json:"rule,omitempty"`
+	Path       string `json:"-"`
+}
+
+// newRejection builds the response fields for a rejected request, reading
+// the retry delay from the Retry-After header already set on the response
+func newRejection(w http.ResponseWriter, r *http.Request, decision limiter.Decision) rejection {
+	retryAfter, _ := strconv.ParseInt(w.Header().Get(headerRetryAfter), 10, 64)
+	return rejection{
+		Message:    rateLimitMessage,
+		Limit:      decision.Limit,
+		Remaining:  decision.Remaining,
+		RetryAfter: retryAfter,
+		Window:     ceilSeconds(decision.Window),
+		Rule:       decision.Rule,
+		Path:       r.URL.Path,
+	}
+}
+
+// PlainResponder writes message as text/plain
+func PlainResponder(message string) Responder {
+	return ResponderFunc(func(w http.ResponseWriter, r *http.Request, decision limiter.Decision) {
+		w.Header().Set("Content-Type", mediaTypePlain+"; charset=utf-8")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(message))
+	})
+}
+
+// JSONResponder writes the message, limit and retry delay as application/json
+func JSONResponder() Responder {
+	return ResponderFunc(func(w http.ResponseWriter, r *http.Request, decision limiter.Decision) {
+		writeJSON(w, mediaTypeJSON, newRejection(w, r, decision))
+	})
+}
+
+// problem is an RFC 9457 problem details object with rate limit extensions
+type problem struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail"`
+	Instance   string `json:"instance,omitempty"`
+	RetryAfter int64  `json:"retry_after"`
+	Limit      int    `json:"limit"`
+	Window     int64  `json:"window,omitempty"`
+	Rule       string `json:"rule,omitempty"`
+}
+
+// ProblemResponder writes an RFC 9457 application/problem+json body with the
+// given problem type URI, "about:blank" if empty
+func ProblemResponder(typeURI string) Responder {
+	if typeURI == "" {
+		typeURI = "about:blank"
+	}
+
+	return ResponderFunc(func(w http.ResponseWriter, r *http.Request, decision limiter.Decision) {
+		rej := newRejection(w, r, decision)
+		writeJSON(w, mediaTypeProblem, problem{
+			Type:       typeURI,
+			Title:      http.StatusText(http.StatusTooManyRequests),
+			Status:     http.StatusTooManyRequests,
+			Detail:     rej.Message,
+			Instance:   rej.Path,
+			RetryAfter: rej.RetryAfter,
+			Limit:      rej.Limit,
+			Window:     rej.Window,
+			Rule:       rej.Rule,
+		})
+	})
+}
+
+// defaultHTMLTemplate is used by HTMLResponder when no template is given
+var defaultHTMLTemplate = template.Must(template.New("rejection").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Too Many Requests</title></head>
+<body>
+<h1>Too Many Requests</h1>
+<p>{{.Message}}</p>
+<p>Please retry in {{.RetryAfter}} seconds.</p>
+</body>
+</html>
+`))
+
+// HTMLResponder renders tmpl as text/html, or a default page if tmpl is nil.
+// The template receives Message, Limit, Remaining, RetryAfter, Window (in
+// seconds), Rule and Path.
+func HTMLResponder(tmpl *template.Template) Responder {
+	if tmpl == nil {
+		tmpl = defaultHTMLTemplate
+	}
+
+	return ResponderFunc(func(w http.ResponseWriter, r *http.Request, decision limiter.Decision) {
+		w.Header().Set("Content-Type", mediaTypeHTML+"; charset=utf-8")
+		w.WriteHeader(http.StatusTooManyRequests)
+		tmpl.Execute(w, newRejection(w, r, decision))
+	})
+}
+
+// NegotiatingResponder picks a responder by media type from the request
+// Accept header, using fallback when nothing acceptable is available
+func NegotiatingResponder(responders map[string]Responder, fallback Responder) Responder {
+	return ResponderFunc(func(w http.ResponseWriter, r *http.Request, decision limiter.Decision) {
+		responder := negotiate(r.Header.Get("Accept"), responders)
+		if responder == nil {
+			responder = fallback
+		}
+		w.Header().Add("Vary", "Accept")
+		responder.Respond(w, r, decision)
+	})
+}
+
+// NewResponder returns the built-in responder for a format. The negotiating
+// format serves problem+json, JSON, HTML and plain text, defaulting to plain
+// text. htmlTemplate and problemType may be empty to use the defaults.
+func NewResponder(format ResponseFormat, htmlTemplate *template.Template, problemType string) (Responder, error) {
+	switch format {
+	case FormatPlain, "":
+		return PlainResponder(rateLimitMessage), nil
+	case FormatJSON:
+		return JSONResponder(), nil
+	case FormatProblem:
+		return ProblemResponder(problemType), nil
+	case FormatHTML:
+		return HTMLResponder(htmlTemplate), nil
+	case FormatNegotiate:
+		plain := PlainResponder(rateLimitMessage)
+		return NegotiatingResponder(map[string]Responder{
+			mediaTypeProblem: ProblemResponder(problemType),
+			mediaTypeJSON:    JSONResponder(),
+			mediaTypeHTML:    HTMLResponder(htmlTemplate),
+			mediaTypePlain:   plain,
+		}, plain), nil
+	default:
+		return nil, fmt.Errorf("unknown response format %q", format)
+	}
+}
+
+// writeJSON writes v as a 429 response with the given content type
+func writeJSON(w http.ResponseWriter, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(v)
+}
+
+// acceptRange is a media range from an Accept header with its quality
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+// negotiate returns the responder for the most preferred acceptable media
+// type, or nil if none of the responders is acceptable or any is
+func negotiate(accept string, responders map[string]Responder) Responder {
+	ranges := parseAccept(accept)
+	for _, ar := range ranges {
+		if ar.quality <= 0 {
+			continue
+		}
+		if responder, ok := responders[ar.mediaType]; ok {
+			return responder
+		}
+		if ar.mediaType == "*/*" {
+			return nil
+		}
+		if prefix, ok := strings.CutSuffix(ar.mediaType, "/*"); ok {
+			for _, mediaType := range sortedKeys(responders) {
+				if strings.HasPrefix(mediaType, prefix+"/") {
+					return responders[mediaType]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseAccept parses an Accept header into media ranges ordered by
+// decreasing quality, keeping the header order for equal qualities
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, quality: quality})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	return ranges
+}
+
+// sortedKeys returns the media types of responders in a stable order
+func sortedKeys(responders map[string]Responder) []string {
+	keys := make([]string, 0, len(responders))
+	for key := range responders {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+)
+
+// respond runs a responder for a rejected request as the middleware does
+func respond(t *testing.T, responder Responder, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "/api/export", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	decision := limiter.Decision{Limit: 10, Window: time.Minute, Rule: limiter.RuleIP, RetryAfter: 30 * time.Second}
+	writeRetryAfter(w.Header(), decision, time.Now())
+
+	responder.Respond(w, req, decision)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	return w
+}
+
+func TestPlainResponder(t *testing.T) {
+	w := respond(t, PlainResponder(rateLimitMessage), "")
+
+	if w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+	if w.Body.String() != rateLimitMessage {
+		t.Errorf("Expected message %q, got %q", rateLimitMessage, w.Body.String())
+	}
+}
+
+func TestJSONResponder(t *testing.T) {
+	w := respond(t, JSONResponder(), "")
+
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON body: %v", err)
+	}
+	if body["message"] != rateLimitMessage || body["limit"] != 10.0 || body["retry_after"] != 30.0 || body["window"] != 60.0 || body["rule"] != "ip" {
+		t.Errorf("Unexpected body %v", body)
+	}
+}
+
+func TestProblemResponder(t *testing.T) {
+	w := respond(t, ProblemResponder("https://example.com/problems/rate-limit"), "")
+
+	if w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("Unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON body: %v", err)
+	}
+	want := map[string]interface{}{
+		"type":        "https://example.com/problems/rate-limit",
+		"title":       "Too Many Requests",
+		"status":      429.0,
+		"detail":      rateLimitMessage,
+		"instance":    "/api/export",
+		"retry_after": 30.0,
+		"limit":       10.0,
+		"window":      60.0,
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, body[key])
+		}
+	}
+}
+
+func TestHTMLResponder(t *testing.T) {
+	tmpl := template.Must(template.New("custom").Parse(`<p>Slow down, retry in {{.RetryAfter}}s ({{.Path}})</p>`))
+	w := respond(t, HTMLResponder(tmpl), "")
+
+	if w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("Unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+	if w.Body.String() != "<p>Slow down, retry in 30s (/api/export)</p>" {
+		t.Errorf("Unexpected body %q", w.Body.String())
+	}
+
+	// Test: Default template
+	w = respond(t, HTMLResponder(nil), "")
+	if !strings.Contains(w.Body.String(), "Please retry in 30 seconds.") {
+		t.Errorf("Unexpected default body %q", w.Body.String())
+	}
+}
+
+func TestNegotiatingResponder(t *testing.T) {
+	responder, err := NewResponder(FormatNegotiate, nil, "")
+	if err != nil {
+		t.Fatalf("NewResponder failed: %v", err)
+	}
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "text/plain; charset=utf-8"},
+		{"*/*", "text/plain; charset=utf-8"},
+		{"application/json", "application/json"},
+		{"application/problem+json, application/json;q=0.9", "application/problem+json"},
+		{"application/json;q=0.5, application/problem+json", "application/problem+json"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8"},
+		{"application/xml", "text/plain; charset=utf-8"},
+		{"application/json;q=0, text/html", "text/html; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			w := respond(t, responder, tt.accept)
+			if got := w.Header().Get("Content-Type"); got != tt.want {
+				t.Errorf("Expected content type %q, got %q", tt.want, got)
+			}
+			if w.Header().Get("Vary") != "Accept" {
+				t.Errorf("Expected Vary: Accept header")
+			}
+		})
+	}
+}
+
+func TestNewResponder_UnknownFormat(t *testing.T) {
+	if _, err := NewResponder("xml", nil, ""); err == nil {
+		t.Fatal("Expected error for unknown format")
+	}
+}
+
+func TestRateLimiterMiddleware_Responder(t *testing.T) {
+	rl := limiter.NewRateLimiter(NewMockStorage(), limiter.Config{
+		IPLimit:         1,
+		IPBlockDuration: 5 * time.Second,
+	})
+
+	handler := RateLimiterMiddleware(rl, WithResponder(ProblemResponder("")))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+	}
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("Expected 429 problem+json response, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), `"retry_after":5`) {
+		t.Errorf("Expected retry_after in body, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+)
+
+// Route is a rate limit rule for the requests matching a pattern
+type Route struct {
+	// Pattern is an http.ServeMux pattern with an optional method and host,
+	// e.g. "POST /login", "/api/" for every path below /api/ or
+	// "GET /users/{id}"
+	Pattern string
+	// Limiter applies the limits of the route. Routes sharing a storage
+	// need distinct limiter.Config.KeyPrefix values to be counted apart.
+	Limiter *limiter.RateLimiter
+}
+
+// routeHandler is registered for each pattern of a router to get the rule
+// of a request back from http.ServeMux, it never serves requests
+type routeHandler struct {
+	limiter *limiter.RateLimiter
+	bypass  bool
+}
+
+func (routeHandler) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// RouteTable holds the routes and bypassed patterns of a middleware, which
+// can be replaced while it serves requests, e.g. to reload the
+// configuration
+type RouteTable struct {
+	router atomic.Pointer[router]
+}
+
+// NewRouteTable creates a route table, returning an error for invalid or
+// conflicting patterns
+func NewRouteTable(routes []Route, bypass []string) (*RouteTable, error) {
+	t := &RouteTable{}
+	if err := t.Update(routes, bypass); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Update atomically replaces the routes and bypassed patterns. On invalid
+// or conflicting patterns it returns an error and keeps the previous ones.
+func (t *RouteTable) Update(routes []Route, bypass []string) error {
+	patterns := append([]string(nil), bypass...)
+	for _, route := range routes {
+		patterns = append(patterns, route.Pattern)
+	}
+	if err := CheckPatterns(patterns...); err != nil {
+		return err
+	}
+
+	t.router.Store(newRouter(routes, bypass))
+	return nil
+}
+
+// RouteMatch describes the route or bypassed pattern matching a request
+type RouteMatch struct {
+	// Pattern is the matching route or bypass pattern, empty if none
+	// matches and the default limits apply
+	Pattern string
+	// Limiter is the rate limiter of the matching route, nil if none
+	// matches or the request bypasses rate limiting
+	Limiter *limiter.RateLimiter
+	// Bypass reports whether the request is never rate limited
+	Bypass bool
+}
+
+// Lookup returns the current route or bypassed pattern matching a request,
+// e.g. to explain which limits apply to it
+func (t *RouteTable) Lookup(r *http.Request) RouteMatch {
+	h, pattern := t.router.Load().handler(r)
+	route, ok := h.(routeHandler)
+	if !ok {
+		return RouteMatch{}
+	}
+	return RouteMatch{Pattern: pattern, Limiter: route.limiter, Bypass: route.bypass}
+}
+
+// match returns the rate limiter and pattern of the current route matching
+// a request, see router.match
+func (t *RouteTable) match(r *http.Request, defaultLimiter *limiter.RateLimiter) (*limiter.RateLimiter, string) {
+	return t.router.Load().match(r, defaultLimiter)
+}
+
+// router matches requests to routes and bypassed patterns with the
+// precedence of http.ServeMux, so the most specific pattern wins, e.g.
+// "POST /login" over "/login" and "/login" over "/"
+type router struct {
+	mux *http.ServeMux
+}
+
+// newRouter registers the routes and the bypassed patterns. It panics on
+// invalid or conflicting patterns, as http.ServeMux does.
+func newRouter(routes []Route, bypass []string) *router {
+	rt := &router{mux: http.NewServeMux()}
+	for _, route := range routes {
+		rt.mux.Handle(route.Pattern, routeHandler{limiter: route.Limiter})
+	}
+	for _, pattern := range bypass {
+		rt.mux.Handle(pattern, routeHandler{bypass: true})
+	}
+	return rt
+}
+
+// match returns the rate limiter and pattern of the route matching a
+// request, or defaultLimiter and an empty pattern if none does. It returns
+// a nil rate limiter if the request bypasses rate limiting.
+func (rt *router) match(r *http.Request, defaultLimiter *limiter.RateLimiter) (*limiter.RateLimiter, string) {
+	h, pattern := rt.handler(r)
+	route, ok := h.(routeHandler)
+	switch {
+	case !ok:
+		return defaultLimiter, ""
+	case route.bypass:
+		return nil, pattern
+	default:
+		return route.limiter, pattern
+	}
+}
+
+// routeKey is the context key of the pattern of the route applied to a
+// request
+type routeKey struct{}
+
+// withRoute returns a request carrying the pattern of the route applied to
+// it, r itself for the default limits
+func withRoute(r *http.Request, pattern string) *http.Request {
+	if pattern == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, pattern))
+}
+
+// RoutePattern returns the pattern of the route whose limits the middleware
+// applied to a request, e.g. "POST /login", or an empty string for the
+// default limits. Observers and the next handler get the request it is set
+// on.
+func RoutePattern(r *http.Request) string {
+	pattern, _ := r.Context().Value(routeKey{}).(string)
+	return pattern
+}
+
+// handler returns the handler and pattern matching a request by its clean
+// path. http.ServeMux answers non-canonical paths like "//login" or
+// "/x/../login" with a redirect handler, which would apply the default
+// limits to requests a handler further down may still serve as "/login".
+func (rt *router) handler(r *http.Request) (http.Handler, string) {
+	if clean := CleanPath(r.URL.Path); clean != r.URL.Path {
+		r = WithPath(r, clean)
+	}
+	return rt.mux.Handler(r)
+}
+
+// CleanPath returns the canonical form of a request path as http.ServeMux
+// matches it, with "." and ".." elements and repeated slashes removed and
+// the trailing slash kept, e.g. "/login" for "//login" or "/x/../login"
+func CleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	clean := path.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return clean
+}
+
+// WithPath returns a shallow copy of a request with its URL path replaced
+func WithPath(r *http.Request, p string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	u.Path, u.RawPath = p, ""
+	r2.URL = &u
+	return r2
+}
+
+// CheckPatterns reports invalid or conflicting route and bypass patterns,
+// which make RateLimiterMiddleware panic
+func CheckPatterns(patterns ...string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	for _, pattern := range patterns {
+		mux.Handle(pattern, routeHandler{})
+	}
+	return nil
+}
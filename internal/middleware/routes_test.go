@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+)
+
+func TestRouter_Match(t *testing.T) {
+	storage := NewMockStorage()
+	defaultLimiter := limiter.NewRateLimiter(storage, limiter.Config{})
+	login := limiter.NewRateLimiter(storage, limiter.Config{KeyPrefix: "route:login:"})
+	api := limiter.NewRateLimiter(storage, limiter.Config{KeyPrefix: "route:api:"})
+	user := limiter.NewRateLimiter(storage, limiter.Config{KeyPrefix: "route:user:"})
+
+	rt := newRouter([]Route{
+		{Pattern: "POST /login", Limiter: login},
+		{Pattern: "/api/", Limiter: api},
+		{Pattern: "GET /api/users/{id}", Limiter: user},
+	}, []string{"GET /health", "/metrics"})
+
+	tests := []struct {
+		method string
+		path   string
+		want   *limiter.RateLimiter
+	}{
+		{"POST", "/login", login},
+		{"GET", "/login", defaultLimiter},
+		{"POST", "/login/reset", defaultLimiter},
+		{"GET", "/api/products", api},
+		{"DELETE", "/api/users/42", api},
+		{"GET", "/api/users/42", user},
+		{"HEAD", "/api/users/42", user},
+		{"GET", "/health", nil},
+		{"POST", "/health", defaultLimiter},
+		{"POST", "/metrics", nil},
+		{"GET", "/", defaultLimiter},
+		{"POST", "//login", login},
+		{"POST", "/./login", login},
+		{"POST", "/x/../login", login},
+		{"GET", "/api//users/42", user},
+		{"GET", "//health", nil},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if got, _ := rt.match(req, defaultLimiter); got != tt.want {
+			t.Errorf("%s %s: matched the wrong rate limiter", tt.method, tt.path)
+		}
+	}
+}
+
+func TestCheckPatterns(t *testing.T) {
+	if err := CheckPatterns("POST /login", "/api/", "GET /health"); err != nil {
+		t.Fatalf("Expected valid patterns, got %v", err)
+	}
+	if err := CheckPatterns("/login", "/login"); err == nil {
+		t.Error("Expected error for duplicate patterns")
+	}
+	if err := CheckPatterns("GET /users/{id}", "GET /users/{name}"); err == nil {
+		t.Error("Expected error for conflicting patterns")
+	}
+	if err := CheckPatterns("login"); err == nil {
+		t.Error("Expected error for pattern without a leading slash")
+	}
+}
+
+func TestRateLimiterMiddleware_Routes(t *testing.T) {
+	storage := NewMockStorage()
+	defaultLimiter := limiter.NewRateLimiter(storage, limiter.Config{IPLimit: 3, IPBlockDuration: 5 * time.Second})
+	login := limiter.NewRateLimiter(storage, limiter.Config{
+		IPLimit:         1,
+		IPPeriod:        time.Minute,
+		IPBlockDuration: time.Minute,
+		KeyPrefix:       "route:login:",
+	})
+
+	handler := RateLimiterMiddleware(defaultLimiter,
+		WithRoutes(Route{Pattern: "POST /login", Limiter: login}),
+		WithBypass("GET /health"),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test: Route applies its own limit
+	if w := serve("POST", "/login"); w.Code != http.StatusOK || w.Header().Get(headerRateLimitLimit) != "1" {
+		t.Fatalf("Expected status 200 with limit 1, got %d with %q", w.Code, w.Header().Get(headerRateLimitLimit))
+	}
+	if w := serve("POST", "/login"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 for the second login, got %d", w.Code)
+	}
+
+	// Test: Non-canonical paths of the route share its limit and block
+	for _, path := range []string{"//login", "/./login", "/x/../login"} {
+		if w := serve("POST", path); w.Code != http.StatusTooManyRequests || w.Header().Get(headerRateLimitLimit) != "1" {
+			t.Fatalf("%s: expected status 429 with limit 1, got %d with %q", path, w.Code, w.Header().Get(headerRateLimitLimit))
+		}
+	}
+
+	// Test: Other routes are counted apart with the default limit
+	for i := 1; i <= 3; i++ {
+		if w := serve("GET", "/products"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d", i, w.Code)
+		}
+	}
+
+	// Test: Bypassed patterns are never limited
+	for i := 1; i <= 5; i++ {
+		w := serve("GET", "/health")
+		if w.Code != http.StatusOK || w.Header().Get(headerRateLimitLimit) != "" {
+			t.Fatalf("Request %d: expected status 200 without rate limit headers, got %d", i, w.Code)
+		}
+	}
+
+	if blocked, _ := storage.IsBlocked(context.Background(), "route:login:ip:192.168.1.1"); !blocked {
+		t.Error("Expected the login route key to be blocked")
+	}
+	if blocked, _ := storage.IsBlocked(context.Background(), "ip:192.168.1.1"); blocked {
+		t.Error("Expected the default key not to be blocked")
+	}
+}
+
+func TestRouteTable_Update(t *testing.T) {
+	storage := NewMockStorage()
+	defaultLimiter := limiter.NewRateLimiter(storage, limiter.Config{IPLimit: 100})
+	login := limiter.NewRateLimiter(storage, limiter.Config{IPLimit: 1, KeyPrefix: "route:login:"})
+
+	table, err := NewRouteTable(nil, []string{"GET /health"})
+	if err != nil {
+		t.Fatalf("NewRouteTable failed: %v", err)
+	}
+	handler := RateLimiterMiddleware(defaultLimiter, WithRouteTable(table))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("POST", "/login"); w.Header().Get(headerRateLimitLimit) != "100" {
+		t.Fatalf("Expected the default limit before the update, got %q", w.Header().Get(headerRateLimitLimit))
+	}
+
+	// Test: Updated routes apply to the next requests
+	if err := table.Update([]Route{{Pattern: "POST /login", Limiter: login}}, nil); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if w := serve("POST", "/login"); w.Header().Get(headerRateLimitLimit) != "1" {
+		t.Fatalf("Expected the login limit after the update, got %q", w.Header().Get(headerRateLimitLimit))
+	}
+	if w := serve("GET", "/health"); w.Header().Get(headerRateLimitLimit) != "100" {
+		t.Fatalf("Expected /health to be limited once removed from the bypass, got %q", w.Header().Get(headerRateLimitLimit))
+	}
+
+	// Test: Invalid update keeps the previous routes
+	if err := table.Update([]Route{{Pattern: "/login"}}, []string{"/login"}); err == nil {
+		t.Fatal("Expected error for conflicting patterns")
+	}
+	if w := serve("POST", "/login"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the login route to be kept, got status %d", w.Code)
+	}
+}
+
+func TestRouteTable_Lookup(t *testing.T) {
+	login := limiter.NewRateLimiter(NewMockStorage(), limiter.Config{IPLimit: 1})
+	table, err := NewRouteTable([]Route{{Pattern: "POST /login", Limiter: login}}, []string{"GET /health"})
+	if err != nil {
+		t.Fatalf("NewRouteTable failed: %v", err)
+	}
+
+	tests := []struct {
+		method, path string
+		want         RouteMatch
+	}{
+		{"POST", "/login", RouteMatch{Pattern: "POST /login", Limiter: login}},
+		{"GET", "/health", RouteMatch{Pattern: "GET /health", Bypass: true}},
+		{"GET", "/login", RouteMatch{}},
+		{"POST", "//login", RouteMatch{Pattern: "POST /login", Limiter: login}},
+	}
+	for _, tt := range tests {
+		if got := table.Lookup(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("%s %s: expected %+v, got %+v", tt.method, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"", "/"},
+		{"/", "/"},
+		{"/login", "/login"},
+		{"//login", "/login"},
+		{"/./login", "/login"},
+		{"/x/../login", "/login"},
+		{"/api//users/", "/api/users/"},
+		{"/../..", "/"},
+		{"login", "/login"},
+	}
+	for _, tt := range tests {
+		if got := CleanPath(tt.path); got != tt.want {
+			t.Errorf("CleanPath(%q): expected %q, got %q", tt.path, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/allis/rate-limiter/internal/middleware"
+)
+
+// Forwarding headers set on the requests to the upstreams
+const (
+	headerForwarded       = "Forwarded"
+	headerXForwardedFor   = "X-Forwarded-For"
+	headerXForwardedHost  = "X-Forwarded-Host"
+	headerXForwardedProto = "X-Forwarded-Proto"
+)
+
+// Upstream is a service the requests below a path prefix are forwarded to
+type Upstream struct {
+	// Prefix matches the request path and the paths below it, e.g. /api
+	// matches /api and /api/users but not /apis. "/" matches every path.
+	Prefix string
+	// URL is the base URL of the service. Its path, if any, is prepended to
+	// the request path.
+	URL *url.URL
+}
+
+// String formats the upstream as parsed by ParseUpstreams
+func (u Upstream) String() string {
+	return u.Prefix + "=" + u.URL.Redacted()
+}
+
+// Option configures a Proxy
+type Option func(*Proxy)
+
+// WithTrustedProxies sets the proxies whose X-Forwarded-Host and
+// X-Forwarded-Proto headers are passed on to the upstreams. The headers of
+// other clients are replaced with the host and scheme of their request.
+func WithTrustedProxies(prefixes []netip.Prefix) Option {
+	return func(p *Proxy) {
+		p.trustedProxies = prefixes
+	}
+}
+
+// WithTransport sets the transport of the requests to the upstreams,
+// http.DefaultTransport if not set
+func WithTransport(transport http.RoundTripper) Option {
+	return func(p *Proxy) {
+		p.transport = transport
+	}
+}
+
+// WithLogger sets the logger the failed upstream requests are written to,
+// slog.Default() if not set
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *Proxy) {
+		p.logger = logger
+	}
+}
+
+// Proxy is an http.Handler forwarding each request to the upstream with the
+// longest prefix matching its path. Paths are cleaned first, as the rate
+// limit routes match them, so "//login" and "/api/../admin" are matched and
+// forwarded as "/login" and "/admin". The X-Forwarded-For and Forwarded
+// chains of the request are kept and the client is appended to them.
+// Requests matching no upstream get 404 Not Found, and requests the
+// upstream fails to answer 502 Bad Gateway.
+type Proxy struct {
+	upstreams      []*upstreamProxy
+	trustedProxies []netip.Prefix
+	transport      http.RoundTripper
+	logger         *slog.Logger
+}
+
+// upstreamProxy is an upstream with its reverse proxy
+type upstreamProxy struct {
+	Upstream
+	proxy *httputil.ReverseProxy
+}
+
+// New creates a Proxy forwarding to upstreams, returning an error if there
+// are none, their URLs are not absolute HTTP URLs or prefixes are repeated
+func New(upstreams []Upstream, opts ...Option) (*Proxy, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("no upstream configured")
+	}
+
+	p := &Proxy{logger: slog.Default()}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	seen := make(map[string]bool, len(upstreams))
+	for _, upstream := range upstreams {
+		if err := checkUpstream(upstream); err != nil {
+			return nil, err
+		}
+		prefix := normalizePrefix(upstream.Prefix)
+		if seen[prefix] {
+			return nil, fmt.Errorf("duplicate upstream prefix %q", upstream.Prefix)
+		}
+		seen[prefix] = true
+
+		u := &upstreamProxy{Upstream: Upstream{Prefix: prefix, URL: upstream.URL}}
+		u.proxy = &httputil.ReverseProxy{
+			Rewrite:      func(pr *httputil.ProxyRequest) { p.rewrite(pr, u.URL) },
+			Transport:    p.transport,
+			ErrorLog:     slog.NewLogLogger(p.logger.Handler(), slog.LevelWarn),
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) { p.handleError(w, r, u, err) },
+		}
+		p.upstreams = append(p.upstreams, u)
+	}
+
+	// Longest prefixes first, so the first match is the most specific
+	sort.SliceStable(p.upstreams, func(i, j int) bool {
+		return len(p.upstreams[i].Prefix) > len(p.upstreams[j].Prefix)
+	})
+	return p, nil
+}
+
+// ServeHTTP forwards a request to its upstream
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if clean := middleware.CleanPath(r.URL.Path); clean != r.URL.Path {
+		r = middleware.WithPath(r, clean)
+	}
+	u := p.match(r.URL.Path)
+	if u == nil {
+		http.Error(w, "No upstream for this path", http.StatusNotFound)
+		return
+	}
+	u.proxy.ServeHTTP(w, r)
+}
+
+// Upstreams returns the upstreams, the most specific prefix first
+func (p *Proxy) Upstreams() []Upstream {
+	upstreams := make([]Upstream, len(p.upstreams))
+	for i, u := range p.upstreams {
+		upstreams[i] = u.Upstream
+	}
+	return upstreams
+}
+
+// match returns the upstream with the longest prefix matching path, nil if
+// none matches
+func (p *Proxy) match(path string) *upstreamProxy {
+	for _, u := range p.upstreams {
+		if u.Prefix == "/" || path == u.Prefix || strings.HasPrefix(path, u.Prefix+"/") {
+			return u
+		}
+	}
+	return nil
+}
+
+// rewrite directs the request to target and sets its forwarding headers
+func (p *Proxy) rewrite(pr *httputil.ProxyRequest, target *url.URL) {
+	pr.SetURL(target)
+
+	// SetXForwarded appends the client to the chain of the inbound request
+	// and sets the host and scheme, which are kept from trusted proxies
+	pr.Out.Header[headerXForwardedFor] = pr.In.Header[headerXForwardedFor]
+	pr.SetXForwarded()
+	if p.trustedPeer(pr.In) {
+		for _, header := range []string{headerXForwardedHost, headerXForwardedProto} {
+			if values := pr.In.Header.Values(header); len(values) > 0 {
+				pr.Out.Header[header] = values
+			}
+		}
+	}
+
+	forwarded := append([]string(nil), pr.In.Header.Values(headerForwarded)...)
+	pr.Out.Header[headerForwarded] = append(forwarded, forwardedElement(pr.In))
+}
+
+// trustedPeer reports whether a request comes from a trusted proxy
+func (p *Proxy) trustedPeer(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range p.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleError responds 502 Bad Gateway to a request the upstream failed to
+// answer
+func (p *Proxy) handleError(w http.ResponseWriter, r *http.Request, u *upstreamProxy, err error) {
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		// The client went away, there is no one to respond to
+		return
+	}
+	p.logger.WarnContext(r.Context(), "Upstream request failed",
+		"upstream", u.URL.Redacted(),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"error", err)
+	http.Error(w, "Bad gateway", http.StatusBadGateway)
+}
+
+// forwardedElement returns the RFC 7239 Forwarded element describing the
+// client, host and scheme of a request
+func forwardedElement(r *http.Request) string {
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	element := "for=" + forwardedNode(r.RemoteAddr)
+	if r.Host != "" {
+		element += ";host=" + quoteForwarded(r.Host)
+	}
+	return element + ";proto=" + proto
+}
+
+// forwardedNode formats the address of a client as a Forwarded node, with
+// IPv6 addresses in brackets and quoted
+func forwardedNode(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	switch {
+	case err != nil:
+		return "unknown"
+	case ip.Unmap().Is4():
+		return ip.Unmap().String()
+	default:
+		return `"[` + ip.String() + `]"`
+	}
+}
+
+// quoteForwarded quotes a Forwarded parameter value if it is not a token,
+// e.g. a host with a port
+func quoteForwarded(value string) string {
+	if strings.ContainsAny(value, `:[]"; ,=`) {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
+
+// checkUpstream reports an invalid prefix or URL
+func checkUpstream(u Upstream) error {
+	if !strings.HasPrefix(u.Prefix, "/") {
+		return fmt.Errorf("invalid upstream prefix %q: must start with /", u.Prefix)
+	}
+	if u.URL == nil || (u.URL.Scheme != "http" && u.URL.Scheme != "https") || u.URL.Host == "" {
+		return fmt.Errorf("invalid upstream URL for %q: must be an absolute http or https URL", u.Prefix)
+	}
+	return nil
+}
+
+// normalizePrefix removes the trailing slash of a prefix, except for "/"
+func normalizePrefix(prefix string) string {
+	if prefix == "/" {
+		return prefix
+	}
+	return strings.TrimSuffix(prefix, "/")
+}
+
+// ParseUpstreams parses a comma-separated list of PREFIX=URL upstreams,
+// e.g. "/api=http://api:8080, /auth=http://auth:9000"
+func ParseUpstreams(s string) ([]Upstream, error) {
+	var upstreams []Upstream
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		prefix, rawURL, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid upstream %q: expected PREFIX=URL", part)
+		}
+		upstream, err := ParseUpstream(strings.TrimSpace(prefix), strings.TrimSpace(rawURL))
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	return upstreams, nil
+}
+
+// ParseUpstream parses the URL of the upstream of prefix
+func ParseUpstream(prefix, rawURL string) (Upstream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Upstream{}, fmt.Errorf("invalid upstream URL for %q: %w", prefix, err)
+	}
+	upstream := Upstream{Prefix: prefix, URL: u}
+	if err := checkUpstream(upstream); err != nil {
+		return Upstream{}, err
+	}
+	return upstream, nil
+}
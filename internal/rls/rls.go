@@ -0,0 +1,367 @@
+package rls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+	"github.com/allis/rate-limiter/internal/logging"
+	"github.com/allis/rate-limiter/internal/middleware"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Descriptor entry keys. KeyRemoteAddress is set by the remote_address
+// action of Envoy, the others by request_headers actions with the
+// descriptor_key, e.g. ":path" as "path".
+const (
+	// KeyRemoteAddress limits the descriptor by client IP
+	KeyRemoteAddress = "remote_address"
+	// KeyPath selects the route whose limits apply, with KeyMethod
+	KeyPath = "path"
+	// KeyMethod is the request method matched against the route patterns,
+	// GET if not set
+	KeyMethod = "method"
+	// DefaultTokenKey limits the descriptor by token, e.g. the value of the
+	// API_KEY header
+	DefaultTokenKey = "api_key"
+)
+
+// Option configures a Service
+type Option func(*Service)
+
+// WithDomain only accepts the requests of domain, the domain configured in
+// the rate limit filter of Envoy. Requests of any domain are accepted if
+// not set.
+func WithDomain(domain string) Option {
+	return func(s *Service) {
+		s.domain = domain
+	}
+}
+
+// WithTokenKey sets the key of the descriptor entries holding a token,
+// DefaultTokenKey if not set
+func WithTokenKey(key string) Option {
+	return func(s *Service) {
+		s.tokenKey = key
+	}
+}
+
+// WithRouteTable applies the limits of the route matching the path and
+// method entries of a descriptor, as the middleware does for a request
+func WithRouteTable(table *middleware.RouteTable) Option {
+	return func(s *Service) {
+		s.routes = table
+	}
+}
+
+// WithLegacyHeaders also returns the X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset headers
+func WithLegacyHeaders() Option {
+	return func(s *Service) {
+		s.legacyHeaders = true
+	}
+}
+
+// WithTimeout bounds each request, including its storage calls, to
+// timeout. Zero means no timeout besides the deadline set by Envoy.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Service) {
+		s.timeout = timeout
+	}
+}
+
+// WithLogger sets the logger the failed checks are written to,
+// slog.Default() if not set
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// Service implements the envoy.service.ratelimit.v3.RateLimitService gRPC
+// API with a rate limiter. Each descriptor is checked against the IP or
+// token rule of its entries, and the request is over the limit if any
+// descriptor is. Descriptors with neither a token nor a remote address are
+// not limited. A request is charged once per rate limiter and identity, so
+// descriptors sharing them, e.g. several with the same remote_address, get
+// the decision of the first one, and descriptors after one over the limit
+// are not charged.
+type Service struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+
+	rateLimiter   *limiter.RateLimiter
+	domain        string
+	tokenKey      string
+	routes        *middleware.RouteTable
+	legacyHeaders bool
+	timeout       time.Duration
+	logger        *slog.Logger
+	now           func() time.Time
+}
+
+// NewService creates a rate limit service applying the limits of
+// rateLimiter
+func NewService(rateLimiter *limiter.RateLimiter, opts ...Option) *Service {
+	s := &Service{
+		rateLimiter: rateLimiter,
+		tokenKey:    DefaultTokenKey,
+		logger:      slog.Default(),
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register registers the service on a gRPC server
+func (s *Service) Register(server *grpc.Server) {
+	rlsv3.RegisterRateLimitServiceServer(server, s)
+}
+
+// ShouldRateLimit checks every descriptor of a request. Storage failures
+// and timeouts are returned as Unavailable and DeadlineExceeded errors, so
+// Envoy applies its failure_mode_deny setting.
+func (s *Service) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	if s.domain != "" && req.GetDomain() != s.domain {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown rate limit domain %q", req.GetDomain())
+	}
+	if len(req.GetDescriptors()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "rate limit descriptor list must not be empty")
+	}
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	resp := &rlsv3.RateLimitResponse{OverallCode: rlsv3.RateLimitResponse_OK}
+	var limiting *limiter.Decision
+	checked := make(map[target]limiter.Decision)
+	for _, descriptor := range req.GetDescriptors() {
+		t, limited := s.target(descriptor)
+		if !limited {
+			resp.Statuses = append(resp.Statuses, &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK})
+			continue
+		}
+
+		decision, seen := checked[t]
+		if !seen {
+			if resp.OverallCode == rlsv3.RateLimitResponse_OVER_LIMIT {
+				// The request is rejected anyway, do not charge it
+				resp.Statuses = append(resp.Statuses, &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_UNKNOWN})
+				continue
+			}
+			var err error
+			if decision, err = t.check(ctx, hits(req, descriptor)); err != nil {
+				s.logFailure(ctx, t, err)
+				return nil, checkError(err)
+			}
+			checked[t] = decision
+		}
+
+		descriptorStatus := newDescriptorStatus(decision, s.now())
+		if !decision.Allowed {
+			resp.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+		resp.Statuses = append(resp.Statuses, descriptorStatus)
+		if limiting == nil || moreLimiting(decision, *limiting) {
+			limiting = &decision
+		}
+	}
+
+	if limiting != nil {
+		resp.ResponseHeadersToAdd = s.headers(*limiting)
+	}
+	return resp, nil
+}
+
+// target is the rate limiter and the token or IP a descriptor is checked
+// against
+type target struct {
+	limiter *limiter.RateLimiter
+	token   string
+	ip      string
+}
+
+// target returns the target of a descriptor, reporting false if none of
+// its entries is limited
+func (s *Service) target(descriptor *ratelimitv3.RateLimitDescriptor) (target, bool) {
+	entries := make(map[string]string, len(descriptor.GetEntries()))
+	for _, entry := range descriptor.GetEntries() {
+		if _, exists := entries[entry.GetKey()]; !exists {
+			entries[entry.GetKey()] = entry.GetValue()
+		}
+	}
+
+	rl := s.rateLimiter
+	if path, ok := entries[KeyPath]; ok && s.routes != nil {
+		match := s.routes.Lookup(routeRequest(entries[KeyMethod], path))
+		if match.Bypass {
+			return target{}, false
+		}
+		if match.Limiter != nil {
+			rl = match.Limiter
+		}
+	}
+
+	if token := entries[s.tokenKey]; token != "" {
+		return target{limiter: rl, token: token}, true
+	}
+	if ip := entries[KeyRemoteAddress]; ip != "" {
+		return target{limiter: rl, ip: ip}, true
+	}
+	return target{}, false
+}
+
+// check checks the target consuming n units
+func (t target) check(ctx context.Context, n int) (limiter.Decision, error) {
+	if t.token != "" {
+		return t.limiter.CheckTokenN(ctx, t.token, n)
+	}
+	return t.limiter.CheckIPN(ctx, t.ip, n)
+}
+
+// logFailure logs a failed check of a target, the only record of the
+// failure, as the limiter returns storage failures without logging them.
+// Checks canceled by Envoy did not fail.
+func (s *Service) logFailure(ctx context.Context, t target, err error) {
+	level, msg := slog.LevelError, "Rate limiter unavailable"
+	switch {
+	case errors.Is(err, context.Canceled):
+		return
+	case errors.Is(err, limiter.ErrTimeout):
+		level, msg = slog.LevelWarn, "Rate limit check timed out"
+	}
+
+	keyType, id := limiter.RuleIP, t.ip
+	if t.token != "" {
+		keyType, id = limiter.RuleToken, t.token
+	}
+	s.logger.Log(ctx, level, msg,
+		"key_type", keyType,
+		"identity", logging.HashIdentity(id),
+		"error", err)
+}
+
+// headers returns the rate limit headers of the decision limiting the
+// request, as set by the middleware
+func (s *Service) headers(decision limiter.Decision) []*corev3.HeaderValue {
+	h := make(http.Header)
+	middleware.WriteRateLimitHeaders(h, decision, s.now(), s.legacyHeaders)
+
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := make([]*corev3.HeaderValue, 0, len(names))
+	for _, name := range names {
+		headers = append(headers, &corev3.HeaderValue{Key: name, Value: h.Get(name)})
+	}
+	return headers
+}
+
+// routeRequest builds the request matched against the route patterns from
+// the method and path, with its query, of a descriptor. The path is cleaned
+// as the middleware does, so "//login" gets the limits of "/login".
+func routeRequest(method, path string) *http.Request {
+	if method == "" {
+		method = http.MethodGet
+	}
+	path, _, _ = strings.Cut(path, "?")
+	path = middleware.CleanPath(path)
+	return &http.Request{Method: method, URL: &url.URL{Path: path}, Header: make(http.Header)}
+}
+
+// hits returns the number of units a descriptor consumes: its own
+// hits_addend if set, the one of the request otherwise, at least 1
+func hits(req *rlsv3.RateLimitRequest, descriptor *ratelimitv3.RateLimitDescriptor) int {
+	n := uint64(req.GetHitsAddend())
+	if addend := descriptor.GetHitsAddend(); addend != nil {
+		n = addend.GetValue()
+	}
+	switch {
+	case n < 1:
+		return 1
+	case n > math.MaxInt32:
+		return math.MaxInt32
+	default:
+		return int(n)
+	}
+}
+
+// newDescriptorStatus returns the status of a limited descriptor
+func newDescriptorStatus(decision limiter.Decision, now time.Time) *rlsv3.RateLimitResponse_DescriptorStatus {
+	code := rlsv3.RateLimitResponse_OK
+	if !decision.Allowed {
+		code = rlsv3.RateLimitResponse_OVER_LIMIT
+	}
+	resetAfter := decision.ResetAt.Sub(now)
+	if resetAfter < 0 {
+		resetAfter = 0
+	}
+
+	return &rlsv3.RateLimitResponse_DescriptorStatus{
+		Code: code,
+		CurrentLimit: &rlsv3.RateLimitResponse_RateLimit{
+			Name:            decision.Rule,
+			RequestsPerUnit: uint32(max(decision.Limit, 0)),
+			Unit:            unit(decision.Window),
+		},
+		LimitRemaining:     uint32(max(decision.Remaining, 0)),
+		DurationUntilReset: durationpb.New(resetAfter),
+	}
+}
+
+// units maps the window lengths with an Envoy unit to it
+var units = map[time.Duration]rlsv3.RateLimitResponse_RateLimit_Unit{
+	time.Second:        rlsv3.RateLimitResponse_RateLimit_SECOND,
+	time.Minute:        rlsv3.RateLimitResponse_RateLimit_MINUTE,
+	time.Hour:          rlsv3.RateLimitResponse_RateLimit_HOUR,
+	24 * time.Hour:     rlsv3.RateLimitResponse_RateLimit_DAY,
+	7 * 24 * time.Hour: rlsv3.RateLimitResponse_RateLimit_WEEK,
+}
+
+// unit returns the Envoy unit of a window, UNKNOWN for windows of another
+// length, e.g. 5 minutes
+func unit(window time.Duration) rlsv3.RateLimitResponse_RateLimit_Unit {
+	return units[window]
+}
+
+// moreLimiting reports whether decision a limits the request more than b:
+// rejected over allowed, then fewer remaining units
+func moreLimiting(a, b limiter.Decision) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	return a.Remaining < b.Remaining
+}
+
+// checkError converts a failed check to a gRPC status error
+func checkError(err error) error {
+	switch {
+	case errors.Is(err, limiter.ErrTimeout):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	default:
+		return status.Error(codes.Unavailable, fmt.Sprintf("rate limiter unavailable: %v", err))
+	}
+}
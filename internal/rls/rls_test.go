@@ -0,0 +1,337 @@
+package rls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+	"github.com/allis/rate-limiter/internal/logging"
+	"github.com/allis/rate-limiter/internal/middleware"
+	"github.com/allis/rate-limiter/internal/storage"
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newClient serves service in-process and returns a client connected to it
+func newClient(t *testing.T, service *Service) rlsv3.RateLimitServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	service.Register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return rlsv3.NewRateLimitServiceClient(conn)
+}
+
+func newRateLimiter(t *testing.T, config limiter.Config) *limiter.RateLimiter {
+	t.Helper()
+	store := storage.NewMemoryStorage(time.Minute)
+	t.Cleanup(func() { store.Close() })
+	return limiter.NewRateLimiter(store, config)
+}
+
+// descriptor builds a descriptor of key/value pairs
+func descriptor(pairs ...string) *ratelimitv3.RateLimitDescriptor {
+	d := &ratelimitv3.RateLimitDescriptor{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		d.Entries = append(d.Entries, &ratelimitv3.RateLimitDescriptor_Entry{Key: pairs[i], Value: pairs[i+1]})
+	}
+	return d
+}
+
+func headers(resp *rlsv3.RateLimitResponse) map[string]string {
+	h := make(map[string]string)
+	for _, header := range resp.GetResponseHeadersToAdd() {
+		h[header.GetKey()] = header.GetValue()
+	}
+	return h
+}
+
+func TestShouldRateLimit_IP(t *testing.T) {
+	rl := newRateLimiter(t, limiter.Config{IPLimit: 2, IPBlockDuration: time.Minute})
+	client := newClient(t, NewService(rl, WithDomain("edge")))
+
+	req := &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor(KeyRemoteAddress, "192.168.1.1")},
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := client.ShouldRateLimit(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ShouldRateLimit failed: %v", err)
+		}
+		if resp.GetOverallCode() != rlsv3.RateLimitResponse_OK {
+			t.Fatalf("Request %d: expected OK, got %v", i+1, resp.GetOverallCode())
+		}
+	}
+
+	resp, err := client.ShouldRateLimit(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ShouldRateLimit failed: %v", err)
+	}
+	if resp.GetOverallCode() != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Fatalf("Expected OVER_LIMIT, got %v", resp.GetOverallCode())
+	}
+
+	if len(resp.GetStatuses()) != 1 {
+		t.Fatalf("Expected 1 status, got %d", len(resp.GetStatuses()))
+	}
+	st := resp.GetStatuses()[0]
+	if st.GetCode() != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("Expected OVER_LIMIT status, got %v", st.GetCode())
+	}
+	if st.GetCurrentLimit().GetName() != limiter.RuleIP || st.GetCurrentLimit().GetRequestsPerUnit() != 2 ||
+		st.GetCurrentLimit().GetUnit() != rlsv3.RateLimitResponse_RateLimit_SECOND {
+		t.Errorf("Unexpected current limit %v", st.GetCurrentLimit())
+	}
+	if st.GetLimitRemaining() != 0 {
+		t.Errorf("Expected 0 remaining, got %d", st.GetLimitRemaining())
+	}
+
+	h := headers(resp)
+	if h["Ratelimit-Limit"] != "2" || h["Ratelimit-Remaining"] != "0" {
+		t.Errorf("Unexpected rate limit headers %v", h)
+	}
+	if h["Retry-After"] == "" {
+		t.Error("Expected Retry-After header")
+	}
+}
+
+func TestShouldRateLimit_Token(t *testing.T) {
+	rl := newRateLimiter(t, limiter.Config{
+		IPLimit:     1,
+		TokenLimits: map[string]limiter.TokenConfig{"abc123": {Limit: 5, Period: time.Minute}},
+	})
+	client := newClient(t, NewService(rl, WithLegacyHeaders()))
+
+	req := &rlsv3.RateLimitRequest{
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor(KeyRemoteAddress, "10.0.0.1", DefaultTokenKey, "abc123")},
+		HitsAddend:  2,
+	}
+	resp, err := client.ShouldRateLimit(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ShouldRateLimit failed: %v", err)
+	}
+	if resp.GetOverallCode() != rlsv3.RateLimitResponse_OK {
+		t.Fatalf("Expected the token limit to apply, got %v", resp.GetOverallCode())
+	}
+
+	st := resp.GetStatuses()[0]
+	if st.GetCurrentLimit().GetName() != limiter.RuleToken || st.GetCurrentLimit().GetUnit() != rlsv3.RateLimitResponse_RateLimit_MINUTE {
+		t.Errorf("Unexpected current limit %v", st.GetCurrentLimit())
+	}
+	if st.GetLimitRemaining() != 3 {
+		t.Errorf("Expected 3 remaining after 2 hits, got %d", st.GetLimitRemaining())
+	}
+	if h := headers(resp); h["X-Ratelimit-Remaining"] != "3" {
+		t.Errorf("Expected legacy headers, got %v", h)
+	}
+}
+
+func TestShouldRateLimit_Descriptors(t *testing.T) {
+	rl := newRateLimiter(t, limiter.Config{IPLimit: 1})
+	client := newClient(t, NewService(rl))
+
+	req := &rlsv3.RateLimitRequest{
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{
+			descriptor("generic_key", "anything"),
+			descriptor(KeyRemoteAddress, "10.0.0.2"),
+		},
+	}
+	if _, err := client.ShouldRateLimit(context.Background(), req); err != nil {
+		t.Fatalf("ShouldRateLimit failed: %v", err)
+	}
+	resp, err := client.ShouldRateLimit(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ShouldRateLimit failed: %v", err)
+	}
+
+	if resp.GetOverallCode() != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Fatalf("Expected OVER_LIMIT, got %v", resp.GetOverallCode())
+	}
+	statuses := resp.GetStatuses()
+	if len(statuses) != 2 {
+		t.Fatalf("Expected a status per descriptor, got %d", len(statuses))
+	}
+	if statuses[0].GetCode() != rlsv3.RateLimitResponse_OK || statuses[0].GetCurrentLimit() != nil {
+		t.Errorf("Expected the unknown descriptor not to be limited, got %v", statuses[0])
+	}
+	if statuses[1].GetCode() != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("Expected the IP descriptor over the limit, got %v", statuses[1].GetCode())
+	}
+}
+
+func TestShouldRateLimit_SharedDescriptors(t *testing.T) {
+	rl := newRateLimiter(t, limiter.Config{IPLimit: 2, DefaultTokenLimit: 5})
+	client := newClient(t, NewService(rl))
+
+	shouldRateLimit := func(descriptors ...*ratelimitv3.RateLimitDescriptor) *rlsv3.RateLimitResponse {
+		t.Helper()
+		resp, err := client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{Descriptors: descriptors})
+		if err != nil {
+			t.Fatalf("ShouldRateLimit failed: %v", err)
+		}
+		return resp
+	}
+
+	// Test: Descriptors sharing the remote address charge the IP once
+	shared := []*ratelimitv3.RateLimitDescriptor{
+		descriptor(KeyRemoteAddress, "10.0.0.4"),
+		descriptor(KeyRemoteAddress, "10.0.0.4", "generic_key", "checkout"),
+	}
+	for i := 0; i < 2; i++ {
+		resp := shouldRateLimit(shared...)
+		if resp.GetOverallCode() != rlsv3.RateLimitResponse_OK {
+			t.Fatalf("Request %d: expected OK, got %v", i+1, resp.GetOverallCode())
+		}
+		for _, st := range resp.GetStatuses() {
+			if st.GetLimitRemaining() != uint32(1-i) {
+				t.Errorf("Request %d: expected %d remaining, got %d", i+1, 1-i, st.GetLimitRemaining())
+			}
+		}
+	}
+
+	// Test: Descriptors after one over the limit are not charged
+	resp := shouldRateLimit(shared[0], descriptor("api_key", "abc123"))
+	if resp.GetOverallCode() != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Fatalf("Expected OVER_LIMIT, got %v", resp.GetOverallCode())
+	}
+	if code := resp.GetStatuses()[1].GetCode(); code != rlsv3.RateLimitResponse_UNKNOWN {
+		t.Errorf("Expected the token descriptor not to be checked, got %v", code)
+	}
+	if remaining := shouldRateLimit(descriptor("api_key", "abc123")).GetStatuses()[0].GetLimitRemaining(); remaining != 4 {
+		t.Errorf("Expected the token charged once, got %d remaining", remaining)
+	}
+}
+
+func TestShouldRateLimit_Routes(t *testing.T) {
+	rl := newRateLimiter(t, limiter.Config{IPLimit: 10})
+	login := newRateLimiter(t, limiter.Config{IPLimit: 1, KeyPrefix: limiter.RouteKeyPrefix("login")})
+	table, err := middleware.NewRouteTable([]middleware.Route{{Pattern: "POST /login", Limiter: login}}, []string{"GET /health"})
+	if err != nil {
+		t.Fatalf("NewRouteTable failed: %v", err)
+	}
+	client := newClient(t, NewService(rl, WithRouteTable(table)))
+
+	shouldRateLimit := func(method, path string) rlsv3.RateLimitResponse_Code {
+		t.Helper()
+		resp, err := client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+			Descriptors: []*ratelimitv3.RateLimitDescriptor{
+				descriptor(KeyRemoteAddress, "10.0.0.3", KeyMethod, method, KeyPath, path),
+			},
+		})
+		if err != nil {
+			t.Fatalf("ShouldRateLimit failed: %v", err)
+		}
+		return resp.GetOverallCode()
+	}
+
+	if code := shouldRateLimit("POST", "/login?next=/"); code != rlsv3.RateLimitResponse_OK {
+		t.Fatalf("Expected the first login to be allowed, got %v", code)
+	}
+	if code := shouldRateLimit("POST", "/login"); code != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Fatalf("Expected the login route limit to apply, got %v", code)
+	}
+	for _, path := range []string{"//login", "/./login", "/x/../login?next=/"} {
+		if code := shouldRateLimit("POST", path); code != rlsv3.RateLimitResponse_OVER_LIMIT {
+			t.Fatalf("%s: expected the login route limit to apply, got %v", path, code)
+		}
+	}
+	if code := shouldRateLimit("GET", "/orders"); code != rlsv3.RateLimitResponse_OK {
+		t.Errorf("Expected the default limit to apply, got %v", code)
+	}
+	for i := 0; i < 20; i++ {
+		if code := shouldRateLimit("GET", "/health"); code != rlsv3.RateLimitResponse_OK {
+			t.Fatalf("Expected the bypassed path not to be limited, got %v", code)
+		}
+	}
+}
+
+func TestShouldRateLimit_InvalidRequest(t *testing.T) {
+	rl := newRateLimiter(t, limiter.Config{IPLimit: 1})
+	client := newClient(t, NewService(rl, WithDomain("edge")))
+
+	tests := map[string]*rlsv3.RateLimitRequest{
+		"unknown domain": {
+			Domain:      "other",
+			Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor(KeyRemoteAddress, "10.0.0.4")},
+		},
+		"no descriptors": {Domain: "edge"},
+	}
+	for name, req := range tests {
+		_, err := client.ShouldRateLimit(context.Background(), req)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: expected InvalidArgument, got %v", name, err)
+		}
+	}
+}
+
+// failingStrategy fails every check as an unavailable storage would
+type failingStrategy struct{}
+
+func (failingStrategy) Allow(ctx context.Context, key string, window limiter.Window, cost int) (storage.Result, error) {
+	return storage.Result{}, errors.New("connection refused")
+}
+
+// hangingStrategy never answers before the context is done
+type hangingStrategy struct{}
+
+func (hangingStrategy) Allow(ctx context.Context, key string, window limiter.Window, cost int) (storage.Result, error) {
+	<-ctx.Done()
+	return storage.Result{}, ctx.Err()
+}
+
+func TestShouldRateLimit_StorageFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy limiter.Strategy
+		want     codes.Code
+		wantLog  string
+	}{
+		{"unavailable", failingStrategy{}, codes.Unavailable, `level=ERROR msg="Rate limiter unavailable"`},
+		{"timeout", hangingStrategy{}, codes.DeadlineExceeded, `level=WARN msg="Rate limit check timed out"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			rl := newRateLimiter(t, limiter.Config{IPLimit: 1})
+			rl.RegisterStrategy(limiter.FixedWindow, tt.strategy)
+			client := newClient(t, NewService(rl, WithTimeout(50*time.Millisecond), WithLogger(slog.New(slog.NewTextHandler(&buf, nil)))))
+
+			_, err := client.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+				Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor(KeyRemoteAddress, "10.0.0.5")},
+			})
+			if status.Code(err) != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+
+			// Test: The failure is logged once, with the client hashed
+			if got := buf.String(); strings.Count(got, "\n") != 1 || !strings.Contains(got, tt.wantLog) ||
+				!strings.Contains(got, "identity="+logging.HashIdentity("10.0.0.5")) {
+				t.Errorf("Expected a single %s record, got %q", tt.wantLog, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the storage while the circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("storage circuit breaker is open")
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+// Circuit breaker states
+const (
+	// BreakerClosed lets every call through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects every call until the open timeout elapses
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial call through
+	BreakerHalfOpen
+)
+
+// String returns the state name
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker stops calling a failing dependency after a number of
+// consecutive failures, and lets a trial call through once the open timeout
+// elapses to detect recovery
+type CircuitBreaker struct {
+	failureThreshold int
+	openTimeout      time.Duration
+	now              func() time.Time
+
+	// OnStateChange, if set, is called on every state transition. It must
+	// be set before the breaker is used and must not call the breaker.
+	OnStateChange func(from, to BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// NewCircuitBreaker creates a circuit breaker that opens after
+// failureThreshold consecutive failures for openTimeout
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		now:              time.Now,
+	}
+}
+
+// State returns the current state of the breaker
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow reports whether a call may proceed, moving an open breaker to
+// half-open once the open timeout elapsed
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		b.trial = true
+		return true
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a call. Cancellations by
+// the caller are not counted as failures.
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil || errors.Is(err, context.Canceled) {
+		b.failures = 0
+		b.trial = false
+		if b.state != BreakerClosed && err == nil {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	b.trial = false
+	if b.state == BreakerHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = b.now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// setState changes the state and notifies OnStateChange.
+// The caller must hold the lock.
+func (b *CircuitBreaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.OnStateChange != nil {
+		b.OnStateChange(from, state)
+	}
+}
+
+// do runs fn through the breaker
+func (b *CircuitBreaker) do(fn func() error) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := fn()
+	b.record(err)
+	return err
+}
+
+// CircuitBreakerStorage wraps a Storage with a circuit breaker so a dead
+// backend is not hammered with calls
+type CircuitBreakerStorage struct {
+	storage Storage
+	breaker *CircuitBreaker
+}
+
+// circuitBreakerEvaluator also forwards Evaluate for storages implementing Evaluator
+type circuitBreakerEvaluator struct {
+	*CircuitBreakerStorage
+	evaluator Evaluator
+}
+
+// NewCircuitBreakerStorage wraps storage with breaker. The returned storage
+// implements Evaluator if storage does.
+func NewCircuitBreakerStorage(storage Storage, breaker *CircuitBreaker) Storage {
+	cb := &CircuitBreakerStorage{storage: storage, breaker: breaker}
+	if evaluator, ok := storage.(Evaluator); ok {
+		return &circuitBreakerEvaluator{CircuitBreakerStorage: cb, evaluator: evaluator}
+	}
+	return cb
+}
+
+// Evaluate evaluates a request through the breaker
+func (c *circuitBreakerEvaluator) Evaluate(ctx context.Context, req Request) (Result, error) {
+	var result Result
+	err := c.breaker.do(func() (err error) {
+		result, err = c.evaluator.Evaluate(ctx, req)
+		return err
+	})
+	return result, err
+}
+
+// Increment increments the counter for a key through the breaker
+func (c *CircuitBreakerStorage) Increment(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	var count int64
+	err := c.breaker.do(func() (err error) {
+		count, err = c.storage.Increment(ctx, key, expiration)
+		return err
+	})
+	return count, err
+}
+
+// IncrementBy increments the counter for a key by n through the breaker
+func (c *CircuitBreakerStorage) IncrementBy(ctx context.Context, key string, n int64, expiration time.Duration) (int64, error) {
+	var count int64
+	err := c.breaker.do(func() (err error) {
+		count, err = c.storage.IncrementBy(ctx, key, n, expiration)
+		return err
+	})
+	return count, err
+}
+
+// Get returns the current counter value for a key through the breaker
+func (c *CircuitBreakerStorage) Get(ctx context.Context, key string) (int64, error) {
+	var count int64
+	err := c.breaker.do(func() (err error) {
+		count, err = c.storage.Get(ctx, key)
+		return err
+	})
+	return count, err
+}
+
+// SetBlock sets a block for a key through the breaker
+func (c *CircuitBreakerStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	return c.breaker.do(func() error {
+		return c.storage.SetBlock(ctx, key, duration)
+	})
+}
+
+// IsBlocked checks if a key is blocked through the breaker
+func (c *CircuitBreakerStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	var blocked bool
+	err := c.breaker.do(func() (err error) {
+		blocked, err = c.storage.IsBlocked(ctx, key)
+		return err
+	})
+	return blocked, err
+}
+
+// TTL returns the time to live for a key through the breaker
+func (c *CircuitBreakerStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := c.breaker.do(func() (err error) {
+		ttl, err = c.storage.TTL(ctx, key)
+		return err
+	})
+	return ttl, err
+}
+
+// SetBlockWithReason sets a block for a key with a reason through the breaker
+func (c *CircuitBreakerStorage) SetBlockWithReason(ctx context.Context, key string, duration time.Duration, reason string) error {
+	return c.breaker.do(func() error {
+		return c.storage.SetBlockWithReason(ctx, key, duration, reason)
+	})
+}
+
+// BlockReason returns the reason of the block of a key through the breaker
+func (c *CircuitBreakerStorage) BlockReason(ctx context.Context, key string) (string, error) {
+	var reason string
+	err := c.breaker.do(func() (err error) {
+		reason, err = c.storage.BlockReason(ctx, key)
+		return err
+	})
+	return reason, err
+}
+
+// Unblock lifts the block of a key through the breaker
+func (c *CircuitBreakerStorage) Unblock(ctx context.Context, key string) error {
+	return c.breaker.do(func() error {
+		return c.storage.Unblock(ctx, key)
+	})
+}
+
+// Delete removes the state of a key through the breaker
+func (c *CircuitBreakerStorage) Delete(ctx context.Context, key string) error {
+	return c.breaker.do(func() error {
+		return c.storage.Delete(ctx, key)
+	})
+}
+
+// List returns the keys starting with prefix through the breaker
+func (c *CircuitBreakerStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := c.breaker.do(func() (err error) {
+		keys, err = c.storage.List(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+// ListBlocked returns the blocked keys starting with prefix through the breaker
+func (c *CircuitBreakerStorage) ListBlocked(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := c.breaker.do(func() (err error) {
+		keys, err = c.storage.ListBlocked(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+// Close closes the wrapped storage
+func (c *CircuitBreakerStorage) Close() error {
+	return c.storage.Close()
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errUnavailable = errors.New("connection refused")
+
+// flakyStorage wraps a MemoryStorage and fails every call while err is set
+type flakyStorage struct {
+	*MemoryStorage
+	err   error
+	calls int
+}
+
+func (s *flakyStorage) Evaluate(ctx context.Context, req Request) (Result, error) {
+	s.calls++
+	if s.err != nil {
+		return Result{}, s.err
+	}
+	return s.MemoryStorage.Evaluate(ctx, req)
+}
+
+func (s *flakyStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	s.calls++
+	if s.err != nil {
+		return false, s.err
+	}
+	return s.MemoryStorage.IsBlocked(ctx, key)
+}
+
+func TestCircuitBreakerStorage(t *testing.T) {
+	memory, clock := newTestMemoryStorage(t)
+	flaky := &flakyStorage{MemoryStorage: memory, err: errUnavailable}
+
+	breaker := NewCircuitBreaker(3, 10*time.Second)
+	breaker.now = clock.Now
+	var transitions []BreakerState
+	breaker.OnStateChange = func(from, to BreakerState) {
+		transitions = append(transitions, to)
+	}
+
+	store := NewCircuitBreakerStorage(flaky, breaker)
+	evaluator, ok := store.(Evaluator)
+	if !ok {
+		t.Fatal("Expected breaker storage to implement Evaluator")
+	}
+	ctx := context.Background()
+	req := Request{Key: "ip:10.0.0.1", Limit: 5, Window: time.Second}
+
+	// Test: Opens after consecutive failures
+	for i := 0; i < 3; i++ {
+		if _, err := evaluator.Evaluate(ctx, req); !errors.Is(err, errUnavailable) {
+			t.Fatalf("Call %d: expected storage error, got %v", i+1, err)
+		}
+	}
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("Expected open breaker, got %v", breaker.State())
+	}
+
+	// Test: Open breaker fails fast without calling the storage
+	if _, err := evaluator.Evaluate(ctx, req); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if flaky.calls != 3 {
+		t.Fatalf("Expected 3 storage calls, got %d", flaky.calls)
+	}
+
+	// Test: Failed trial call reopens the breaker
+	clock.Advance(10 * time.Second)
+	if _, err := store.IsBlocked(ctx, req.Key); !errors.Is(err, errUnavailable) {
+		t.Fatalf("Expected trial call to reach the storage, got %v", err)
+	}
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("Expected breaker to reopen, got %v", breaker.State())
+	}
+
+	// Test: Successful trial call closes the breaker
+	clock.Advance(10 * time.Second)
+	flaky.err = nil
+	result, err := evaluator.Evaluate(ctx, req)
+	if err != nil || !result.Allowed {
+		t.Fatalf("Expected allowed result, got %+v, %v", result, err)
+	}
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("Expected closed breaker, got %v", breaker.State())
+	}
+
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(transitions) != len(want) {
+		t.Fatalf("Expected transitions %v, got %v", want, transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("Expected transitions %v, got %v", want, transitions)
+		}
+	}
+}
+
+func TestCircuitBreaker_IgnoresCancellation(t *testing.T) {
+	breaker := NewCircuitBreaker(1, time.Second)
+
+	breaker.do(func() error { return context.Canceled })
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("Expected cancellation to keep the breaker closed, got %v", breaker.State())
+	}
+}
+
+func TestFailoverStorage(t *testing.T) {
+	memory, _ := newTestMemoryStorage(t)
+	primary := &flakyStorage{MemoryStorage: memory}
+	fallback, _ := newTestMemoryStorage(t)
+
+	store := NewFailoverStorage(primary, fallback)
+	evaluator, ok := store.(Evaluator)
+	if !ok {
+		t.Fatal("Expected failover storage to implement Evaluator")
+	}
+	ctx := context.Background()
+	req := Request{Key: "ip:10.0.0.1", Limit: 2, Window: time.Second}
+
+	// Test: Primary serves requests while healthy
+	evaluator.Evaluate(ctx, req)
+	if count, _ := memory.Get(ctx, req.Key); count != 1 {
+		t.Fatalf("Expected primary counter 1, got %d", count)
+	}
+
+	// Test: Fallback enforces the same rules while the primary fails
+	primary.err = errUnavailable
+	for i := 1; i <= 3; i++ {
+		result, err := evaluator.Evaluate(ctx, req)
+		if err != nil {
+			t.Fatalf("Expected fallback to serve the request, got %v", err)
+		}
+		if result.Allowed != (i <= 2) {
+			t.Fatalf("Request %d: expected allowed=%v, got %+v", i, i <= 2, result)
+		}
+	}
+
+	// Test: Invalid requests are not retried on the fallback
+	primary.err = ErrUnsupportedAlgorithm
+	if _, err := evaluator.Evaluate(ctx, req); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("Expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
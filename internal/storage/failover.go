@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// FailoverStorage serves calls from a fallback storage whenever the primary
+// storage fails, e.g. a local MemoryStorage while Redis is unavailable.
+// Counters are not shared between the two, so limits are enforced per
+// instance while the fallback is in use.
+type FailoverStorage struct {
+	primary  Storage
+	fallback Storage
+}
+
+// failoverEvaluator also forwards Evaluate when both storages implement Evaluator
+type failoverEvaluator struct {
+	*FailoverStorage
+	primary  Evaluator
+	fallback Evaluator
+}
+
+// NewFailoverStorage creates a storage falling back from primary to fallback.
+// The returned storage implements Evaluator if both storages do.
+func NewFailoverStorage(primary, fallback Storage) Storage {
+	fs := &FailoverStorage{primary: primary, fallback: fallback}
+	primaryEvaluator, ok := primary.(Evaluator)
+	if !ok {
+		return fs
+	}
+	fallbackEvaluator, ok := fallback.(Evaluator)
+	if !ok {
+		return fs
+	}
+	return &failoverEvaluator{FailoverStorage: fs, primary: primaryEvaluator, fallback: fallbackEvaluator}
+}
+
+// shouldFailover reports whether an error of the primary storage must be
+// retried on the fallback. Cancellations by the caller and invalid requests
+// are returned as is.
+func shouldFailover(err error) bool {
+	return err != nil &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, ErrUnsupportedAlgorithm)
+}
+
+// Evaluate evaluates a request in the primary storage or the fallback
+func (f *failoverEvaluator) Evaluate(ctx context.Context, req Request) (Result, error) {
+	result, err := f.primary.Evaluate(ctx, req)
+	if shouldFailover(err) {
+		return f.fallback.Evaluate(ctx, req)
+	}
+	return result, err
+}
+
+// Increment increments the counter for a key in the primary storage or the fallback
+func (f *FailoverStorage) Increment(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	count, err := f.primary.Increment(ctx, key, expiration)
+	if shouldFailover(err) {
+		return f.fallback.Increment(ctx, key, expiration)
+	}
+	return count, err
+}
+
+// IncrementBy increments the counter for a key by n in the primary storage or the fallback
+func (f *FailoverStorage) IncrementBy(ctx context.Context, key string, n int64, expiration time.Duration) (int64, error) {
+	count, err := f.primary.IncrementBy(ctx, key, n, expiration)
+	if shouldFailover(err) {
+		return f.fallback.IncrementBy(ctx, key, n, expiration)
+	}
+	return count, err
+}
+
+// Get returns the current counter value for a key from the primary storage or the fallback
+func (f *FailoverStorage) Get(ctx context.Context, key string) (int64, error) {
+	count, err := f.primary.Get(ctx, key)
+	if shouldFailover(err) {
+		return f.fallback.Get(ctx, key)
+	}
+	return count, err
+}
+
+// SetBlock sets a block for a key in the primary storage or the fallback
+func (f *FailoverStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	err := f.primary.SetBlock(ctx, key, duration)
+	if shouldFailover(err) {
+		return f.fallback.SetBlock(ctx, key, duration)
+	}
+	return err
+}
+
+// IsBlocked checks if a key is blocked in the primary storage or the fallback
+func (f *FailoverStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	blocked, err := f.primary.IsBlocked(ctx, key)
+	if shouldFailover(err) {
+		return f.fallback.IsBlocked(ctx, key)
+	}
+	return blocked, err
+}
+
+// TTL returns the time to live for a key from the primary storage or the fallback
+func (f *FailoverStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := f.primary.TTL(ctx, key)
+	if shouldFailover(err) {
+		return f.fallback.TTL(ctx, key)
+	}
+	return ttl, err
+}
+
+// SetBlockWithReason sets a block for a key with a reason in the primary
+// storage or the fallback
+func (f *FailoverStorage) SetBlockWithReason(ctx context.Context, key string, duration time.Duration, reason string) error {
+	err := f.primary.SetBlockWithReason(ctx, key, duration, reason)
+	if shouldFailover(err) {
+		return f.fallback.SetBlockWithReason(ctx, key, duration, reason)
+	}
+	return err
+}
+
+// BlockReason returns the reason of the block of a key from the primary
+// storage or the fallback
+func (f *FailoverStorage) BlockReason(ctx context.Context, key string) (string, error) {
+	reason, err := f.primary.BlockReason(ctx, key)
+	if shouldFailover(err) {
+		return f.fallback.BlockReason(ctx, key)
+	}
+	return reason, err
+}
+
+// Unblock lifts the block of a key in both storages, so a block imposed
+// while the fallback was in use is lifted as well
+func (f *FailoverStorage) Unblock(ctx context.Context, key string) error {
+	err := f.primary.Unblock(ctx, key)
+	fallbackErr := f.fallback.Unblock(ctx, key)
+	if shouldFailover(err) {
+		return fallbackErr
+	}
+	return errors.Join(err, fallbackErr)
+}
+
+// Delete removes the state of a key in both storages
+func (f *FailoverStorage) Delete(ctx context.Context, key string) error {
+	err := f.primary.Delete(ctx, key)
+	fallbackErr := f.fallback.Delete(ctx, key)
+	if shouldFailover(err) {
+		return fallbackErr
+	}
+	return errors.Join(err, fallbackErr)
+}
+
+// List returns the keys starting with prefix from the primary storage or
+// the fallback
+func (f *FailoverStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := f.primary.List(ctx, prefix)
+	if shouldFailover(err) {
+		return f.fallback.List(ctx, prefix)
+	}
+	return keys, err
+}
+
+// ListBlocked returns the blocked keys starting with prefix from the primary
+// storage or the fallback
+func (f *FailoverStorage) ListBlocked(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := f.primary.ListBlocked(ctx, prefix)
+	if shouldFailover(err) {
+		return f.fallback.ListBlocked(ctx, prefix)
+	}
+	return keys, err
+}
+
+// Close closes both storages
+func (f *FailoverStorage) Close() error {
+	return errors.Join(f.primary.Close(), f.fallback.Close())
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Observe is called with the operation name, the duration and the error of
+// every storage call, e.g. to export latency metrics
+type Observe func(operation string, duration time.Duration, err error)
+
+// InstrumentedStorage wraps a Storage reporting every call to an Observe
+// function. Operations are named after the methods in snake case, e.g.
+// "increment_by" or "evaluate".
+type InstrumentedStorage struct {
+	storage Storage
+	observe Observe
+}
+
+// instrumentedEvaluator also forwards Evaluate for storages implementing Evaluator
+type instrumentedEvaluator struct {
+	*InstrumentedStorage
+	evaluator Evaluator
+}
+
+// NewInstrumentedStorage wraps storage reporting its calls to observe. The
+// returned storage implements Evaluator if storage does.
+func NewInstrumentedStorage(storage Storage, observe Observe) Storage {
+	is := &InstrumentedStorage{storage: storage, observe: observe}
+	if evaluator, ok := storage.(Evaluator); ok {
+		return &instrumentedEvaluator{InstrumentedStorage: is, evaluator: evaluator}
+	}
+	return is
+}
+
+// do runs fn reporting its duration and error as operation
+func (s *InstrumentedStorage) do(operation string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	s.observe(operation, time.Since(start), err)
+	return err
+}
+
+// Evaluate evaluates a request, reporting the call
+func (s *instrumentedEvaluator) Evaluate(ctx context.Context, req Request) (Result, error) {
+	var result Result
+	err := s.do("evaluate", func() (err error) {
+		result, err = s.evaluator.Evaluate(ctx, req)
+		return err
+	})
+	return result, err
+}
+
+// Increment increments the counter for a key, reporting the call
+func (s *InstrumentedStorage) Increment(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	var count int64
+	err := s.do("increment", func() (err error) {
+		count, err = s.storage.Increment(ctx, key, expiration)
+		return err
+	})
+	return count, err
+}
+
+// IncrementBy increments the counter for a key by n, reporting the call
+func (s *InstrumentedStorage) IncrementBy(ctx context.Context, key string, n int64, expiration time.Duration) (int64, error) {
+	var count int64
+	err := s.do("increment_by", func() (err error) {
+		count, err = s.storage.IncrementBy(ctx, key, n, expiration)
+		return err
+	})
+	return count, err
+}
+
+// Get returns the current counter value for a key, reporting the call
+func (s *InstrumentedStorage) Get(ctx context.Context, key string) (int64, error) {
+	var count int64
+	err := s.do("get", func() (err error) {
+		count, err = s.storage.Get(ctx, key)
+		return err
+	})
+	return count, err
+}
+
+// SetBlock sets a block for a key, reporting the call
+func (s *InstrumentedStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	return s.do("set_block", func() error {
+		return s.storage.SetBlock(ctx, key, duration)
+	})
+}
+
+// IsBlocked checks if a key is blocked, reporting the call
+func (s *InstrumentedStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	var blocked bool
+	err := s.do("is_blocked", func() (err error) {
+		blocked, err = s.storage.IsBlocked(ctx, key)
+		return err
+	})
+	return blocked, err
+}
+
+// TTL returns the time to live for a key, reporting the call
+func (s *InstrumentedStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := s.do("ttl", func() (err error) {
+		ttl, err = s.storage.TTL(ctx, key)
+		return err
+	})
+	return ttl, err
+}
+
+// SetBlockWithReason sets a block for a key with a reason, reporting the call
+func (s *InstrumentedStorage) SetBlockWithReason(ctx context.Context, key string, duration time.Duration, reason string) error {
+	return s.do("set_block_with_reason", func() error {
+		return s.storage.SetBlockWithReason(ctx, key, duration, reason)
+	})
+}
+
+// BlockReason returns the reason of the block of a key, reporting the call
+func (s *InstrumentedStorage) BlockReason(ctx context.Context, key string) (string, error) {
+	var reason string
+	err := s.do("block_reason", func() (err error) {
+		reason, err = s.storage.BlockReason(ctx, key)
+		return err
+	})
+	return reason, err
+}
+
+// Unblock lifts the block of a key, reporting the call
+func (s *InstrumentedStorage) Unblock(ctx context.Context, key string) error {
+	return s.do("unblock", func() error {
+		return s.storage.Unblock(ctx, key)
+	})
+}
+
+// Delete removes the state of a key, reporting the call
+func (s *InstrumentedStorage) Delete(ctx context.Context, key string) error {
+	return s.do("delete", func() error {
+		return s.storage.Delete(ctx, key)
+	})
+}
+
+// List returns the keys starting with prefix, reporting the call
+func (s *InstrumentedStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := s.do("list", func() (err error) {
+		keys, err = s.storage.List(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+// ListBlocked returns the blocked keys starting with prefix, reporting the call
+func (s *InstrumentedStorage) ListBlocked(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := s.do("list_blocked", func() (err error) {
+		keys, err = s.storage.ListBlocked(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+// Close closes the wrapped storage
+func (s *InstrumentedStorage) Close() error {
+	return s.storage.Close()
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInstrumentedStorage(t *testing.T) {
+	memory, _ := newTestMemoryStorage(t)
+	flaky := &flakyStorage{MemoryStorage: memory}
+
+	type call struct {
+		operation string
+		err       error
+	}
+	var calls []call
+	store := NewInstrumentedStorage(flaky, func(operation string, duration time.Duration, err error) {
+		if duration < 0 {
+			t.Errorf("%s: negative duration %v", operation, duration)
+		}
+		calls = append(calls, call{operation, err})
+	})
+	evaluator, ok := store.(Evaluator)
+	if !ok {
+		t.Fatal("Expected instrumented storage to implement Evaluator")
+	}
+	ctx := context.Background()
+
+	evaluator.Evaluate(ctx, Request{Key: "ip:10.0.0.1", Limit: 5, Window: time.Second})
+	store.SetBlockWithReason(ctx, "ip:10.0.0.1", time.Minute, "abuse")
+	keys, _ := store.ListBlocked(ctx, "ip:")
+	flaky.err = errUnavailable
+	if _, err := store.IsBlocked(ctx, "ip:10.0.0.1"); !errors.Is(err, errUnavailable) {
+		t.Fatalf("Expected the storage error, got %v", err)
+	}
+
+	if len(keys) != 1 {
+		t.Errorf("Expected 1 blocked key, got %v", keys)
+	}
+	expected := []call{{"evaluate", nil}, {"set_block_with_reason", nil}, {"list_blocked", nil}, {"is_blocked", errUnavailable}}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("Call %d: expected %v, got %v", i, expected[i], calls[i])
+		}
+	}
+}
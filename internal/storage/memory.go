@@ -0,0 +1,536 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const memoryShardCount = 32
+
+// memoryCounter is a counter value with its expiration time
+type memoryCounter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+// memoryBucket is a token bucket state with its expiration time, after which
+// the bucket is full again
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	expiresAt time.Time
+}
+
+// memorySlidingWindow is a sliding window counter state, irrelevant once
+// two windows have passed
+type memorySlidingWindow struct {
+	index     int64
+	current   int64
+	previous  int64
+	expiresAt time.Time
+}
+
+// memoryLog is a sliding window log of allowed request timestamps
+type memoryLog struct {
+	timestamps []time.Time
+	expiresAt  time.Time
+}
+
+// memoryBlock is a block with its expiration time and the reason it was
+// imposed for, if any
+type memoryBlock struct {
+	until  time.Time
+	reason string
+}
+
+// memoryShard holds a partition of the keys guarded by its own lock
+type memoryShard struct {
+	mu       sync.Mutex
+	counters map[string]memoryCounter
+	buckets  map[string]memoryBucket
+	windows  map[string]memorySlidingWindow
+	logs     map[string]memoryLog
+	tats     map[string]time.Time
+	blocks   map[string]memoryBlock
+}
+
+// MemoryStorage implements Storage interface using in-process sharded maps.
+// It is safe for concurrent use but its state is local to a single instance.
+type MemoryStorage struct {
+	shards    [memoryShardCount]*memoryShard
+	now       func() time.Time
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMemoryStorage creates a new in-memory storage instance whose janitor
+// evicts expired keys every cleanupInterval
+func NewMemoryStorage(cleanupInterval time.Duration) *MemoryStorage {
+	m := &MemoryStorage{
+		now:  time.Now,
+		stop: make(chan struct{}),
+	}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{
+			counters: make(map[string]memoryCounter),
+			buckets:  make(map[string]memoryBucket),
+			windows:  make(map[string]memorySlidingWindow),
+			logs:     make(map[string]memoryLog),
+			tats:     make(map[string]time.Time),
+			blocks:   make(map[string]memoryBlock),
+		}
+	}
+
+	if cleanupInterval > 0 {
+		go m.janitor(cleanupInterval)
+	}
+
+	return m
+}
+
+// shard returns the shard responsible for a key
+func (m *MemoryStorage) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return m.shards[h.Sum32()%memoryShardCount]
+}
+
+// Increment increments the counter for a key
+func (m *MemoryStorage) Increment(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	return m.IncrementBy(ctx, key, 1, expiration)
+}
+
+// IncrementBy increments the counter for a key by n
+func (m *MemoryStorage) IncrementBy(ctx context.Context, key string, n int64, expiration time.Duration) (int64, error) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := m.now()
+	counter := s.counter(key, now)
+	counter.value += n
+	counter.expiresAt = now.Add(expiration)
+	s.counters[key] = counter
+
+	return counter.value, nil
+}
+
+// Evaluate atomically checks, applies the algorithm and blocks a key under
+// the shard lock
+func (m *MemoryStorage) Evaluate(ctx context.Context, req Request) (Result, error) {
+	s := m.shard(req.Key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := m.now()
+	if blockedUntil, ok := s.block(req.Key, now); ok {
+		ttl := blockedUntil.Sub(now)
+		return Result{Blocked: true, ResetAfter: ttl, RetryAfter: ttl}, nil
+	}
+
+	var result Result
+	switch req.Algorithm {
+	case FixedWindow, "":
+		result = s.fixedWindow(req, now)
+	case TokenBucket:
+		result = s.tokenBucket(req, now)
+	case SlidingWindowCounter:
+		result = s.slidingWindowCounter(req, now)
+	case SlidingWindowLog:
+		result = s.slidingWindowLog(req, now)
+	case GCRA:
+		result = s.gcra(req, now)
+	default:
+		return Result{}, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, req.Algorithm)
+	}
+
+	if !result.Allowed && req.BlockDuration > 0 {
+		s.blocks[req.Key] = memoryBlock{until: now.Add(req.BlockDuration)}
+		return Result{Blocked: true, ResetAfter: req.BlockDuration, RetryAfter: req.BlockDuration}, nil
+	}
+
+	return result, nil
+}
+
+// fixedWindow counts the cost of a request in the current window.
+// The caller must hold the shard lock.
+func (s *memoryShard) fixedWindow(req Request, now time.Time) Result {
+	cost := cost(req)
+	counter := s.counter(req.Key, now)
+	counter.value += cost
+	if counter.value == cost {
+		counter.expiresAt = now.Add(req.Window)
+	}
+	s.counters[req.Key] = counter
+
+	ttl := counter.expiresAt.Sub(now)
+	if counter.value > req.Limit {
+		return Result{ResetAfter: ttl, RetryAfter: ttl}
+	}
+	return Result{Allowed: true, Remaining: req.Limit - counter.value, ResetAfter: ttl}
+}
+
+// tokenBucket refills the bucket for the elapsed time and takes one token
+// per unit of cost.
+// The caller must hold the shard lock.
+func (s *memoryShard) tokenBucket(req Request, now time.Time) Result {
+	cost := float64(cost(req))
+	capacity := float64(burst(req))
+	rate := float64(req.Limit) / float64(req.Window)
+
+	bucket, ok := s.buckets[req.Key]
+	if !ok || !now.Before(bucket.expiresAt) {
+		bucket = memoryBucket{tokens: capacity, updatedAt: now}
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+float64(now.Sub(bucket.updatedAt))*rate)
+	bucket.updatedAt = now
+
+	allowed := bucket.tokens >= cost
+	if allowed {
+		bucket.tokens -= cost
+	}
+
+	reset := time.Duration(math.Ceil((capacity - bucket.tokens) / rate))
+	bucket.expiresAt = now.Add(reset)
+	s.buckets[req.Key] = bucket
+
+	if !allowed {
+		return Result{ResetAfter: reset, RetryAfter: time.Duration(math.Ceil((cost - bucket.tokens) / rate))}
+	}
+	return Result{Allowed: true, Remaining: int64(bucket.tokens), ResetAfter: reset}
+}
+
+// slidingWindowCounter estimates the requests in the sliding window from the
+// current and the weighted previous window counts.
+// The caller must hold the shard lock.
+func (s *memoryShard) slidingWindowCounter(req Request, now time.Time) Result {
+	window := int64(req.Window)
+	index := now.UnixNano() / window
+
+	state := s.windows[req.Key]
+	if state.index != index {
+		if state.index == index-1 {
+			state.previous = state.current
+		} else {
+			state.previous = 0
+		}
+		state.current = 0
+		state.index = index
+		state.expiresAt = time.Unix(0, (index+2)*window)
+	}
+
+	elapsed := now.UnixNano() - index*window
+	reset := time.Duration(window - elapsed)
+	estimate := float64(state.previous)*float64(window-elapsed)/float64(window) + float64(state.current)
+	limit := float64(req.Limit)
+	cost := cost(req)
+
+	if estimate+float64(cost) <= limit {
+		state.current += cost
+		s.windows[req.Key] = state
+		return Result{Allowed: true, Remaining: int64(limit - estimate - float64(cost)), ResetAfter: reset}
+	}
+	s.windows[req.Key] = state
+
+	var retry time.Duration
+	switch {
+	case state.current+cost <= req.Limit:
+		retry = time.Duration(math.Ceil(float64(window)*(1-(limit-float64(cost+state.current))/float64(state.previous)))) - time.Duration(elapsed)
+	case cost <= req.Limit:
+		retry = reset + time.Duration(math.Ceil(float64(window)*(1-(limit-float64(cost))/float64(state.current))))
+	default:
+		retry = reset
+	}
+	return Result{ResetAfter: reset, RetryAfter: retry}
+}
+
+// slidingWindowLog drops the timestamps that left the window and records
+// one timestamp per unit of cost if they fit within the limit.
+// The caller must hold the shard lock.
+func (s *memoryShard) slidingWindowLog(req Request, now time.Time) Result {
+	cost := cost(req)
+	if cost > req.Limit {
+		return Result{ResetAfter: req.Window, RetryAfter: req.Window}
+	}
+	log := s.logs[req.Key]
+
+	cutoff := now.Add(-req.Window)
+	expired := 0
+	for expired < len(log.timestamps) && !log.timestamps[expired].After(cutoff) {
+		expired++
+	}
+	log.timestamps = log.timestamps[expired:]
+
+	count := int64(len(log.timestamps))
+	if count+cost <= req.Limit {
+		for i := int64(0); i < cost; i++ {
+			log.timestamps = append(log.timestamps, now)
+		}
+		log.expiresAt = now.Add(req.Window)
+		s.logs[req.Key] = log
+		return Result{Allowed: true, Remaining: req.Limit - count - cost, ResetAfter: req.Window}
+	}
+	s.logs[req.Key] = log
+
+	return Result{
+		ResetAfter: log.timestamps[count-1].Add(req.Window).Sub(now),
+		RetryAfter: log.timestamps[count+cost-req.Limit-1].Add(req.Window).Sub(now),
+	}
+}
+
+// gcra applies the generic cell rate algorithm using the theoretical arrival
+// time (TAT) of the next request, which also acts as the state expiration.
+// Each unit of cost advances the TAT by one emission interval.
+// The caller must hold the shard lock.
+func (s *memoryShard) gcra(req Request, now time.Time) Result {
+	if req.Limit <= 0 {
+		return Result{ResetAfter: req.Window, RetryAfter: req.Window}
+	}
+	interval := req.Window / time.Duration(req.Limit)
+	tolerance := interval * time.Duration(burst(req))
+
+	tat := s.tats[req.Key]
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(interval * time.Duration(cost(req)))
+	allowAt := newTat.Add(-tolerance)
+
+	if now.Before(allowAt) {
+		return Result{ResetAfter: tat.Sub(now), RetryAfter: allowAt.Sub(now)}
+	}
+
+	s.tats[req.Key] = newTat
+	return Result{Allowed: true, Remaining: int64(now.Sub(allowAt) / interval), ResetAfter: newTat.Sub(now)}
+}
+
+// Get returns the current counter value for a key
+func (m *MemoryStorage) Get(ctx context.Context, key string) (int64, error) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.counter(key, m.now()).value, nil
+}
+
+// SetBlock sets a block for a key
+func (m *MemoryStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	return m.SetBlockWithReason(ctx, key, duration, "")
+}
+
+// SetBlockWithReason sets a block for a key with the reason it was imposed for
+func (m *MemoryStorage) SetBlockWithReason(ctx context.Context, key string, duration time.Duration, reason string) error {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocks[key] = memoryBlock{until: m.now().Add(duration), reason: reason}
+	return nil
+}
+
+// BlockReason returns the reason of the block of a key
+func (m *MemoryStorage) BlockReason(ctx context.Context, key string) (string, error) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.block(key, m.now()); !ok {
+		return "", nil
+	}
+	return s.blocks[key].reason, nil
+}
+
+// Unblock lifts the block of a key
+func (m *MemoryStorage) Unblock(ctx context.Context, key string) error {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.blocks, key)
+	return nil
+}
+
+// Delete removes the counter, the algorithm state and the block of a key
+func (m *MemoryStorage) Delete(ctx context.Context, key string) error {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.counters, key)
+	delete(s.buckets, key)
+	delete(s.windows, key)
+	delete(s.logs, key)
+	delete(s.tats, key)
+	delete(s.blocks, key)
+	return nil
+}
+
+// List returns the live keys starting with prefix
+func (m *MemoryStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	now := m.now()
+	seen := make(map[string]bool)
+	add := func(key string, expiresAt time.Time) {
+		if strings.HasPrefix(key, prefix) && now.Before(expiresAt) {
+			seen[key] = true
+		}
+	}
+
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for key, counter := range s.counters {
+			add(key, counter.expiresAt)
+		}
+		for key, bucket := range s.buckets {
+			add(key, bucket.expiresAt)
+		}
+		for key, state := range s.windows {
+			add(key, state.expiresAt)
+		}
+		for key, log := range s.logs {
+			add(key, log.expiresAt)
+		}
+		for key, tat := range s.tats {
+			add(key, tat)
+		}
+		for key, block := range s.blocks {
+			add(key, block.until)
+		}
+		s.mu.Unlock()
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ListBlocked returns the blocked keys starting with prefix
+func (m *MemoryStorage) ListBlocked(ctx context.Context, prefix string) ([]string, error) {
+	now := m.now()
+	var keys []string
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for key, block := range s.blocks {
+			if strings.HasPrefix(key, prefix) && now.Before(block.until) {
+				keys = append(keys, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// IsBlocked checks if a key is blocked
+func (m *MemoryStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.block(key, m.now())
+	return ok, nil
+}
+
+// TTL returns the remaining block duration for a key, or zero if it is not blocked
+func (m *MemoryStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := m.now()
+	blockedUntil, ok := s.block(key, now)
+	if !ok {
+		return 0, nil
+	}
+	return blockedUntil.Sub(now), nil
+}
+
+// Close stops the janitor
+func (m *MemoryStorage) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+	})
+	return nil
+}
+
+// janitor periodically evicts expired keys until the storage is closed
+func (m *MemoryStorage) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.evictExpired()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// evictExpired removes expired keys from every shard
+func (m *MemoryStorage) evictExpired() {
+	now := m.now()
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for key, counter := range s.counters {
+			if !now.Before(counter.expiresAt) {
+				delete(s.counters, key)
+			}
+		}
+		for key, bucket := range s.buckets {
+			if !now.Before(bucket.expiresAt) {
+				delete(s.buckets, key)
+			}
+		}
+		for key, state := range s.windows {
+			if !now.Before(state.expiresAt) {
+				delete(s.windows, key)
+			}
+		}
+		for key, log := range s.logs {
+			if !now.Before(log.expiresAt) {
+				delete(s.logs, key)
+			}
+		}
+		for key, tat := range s.tats {
+			if !now.Before(tat) {
+				delete(s.tats, key)
+			}
+		}
+		for key, block := range s.blocks {
+			if !now.Before(block.until) {
+				delete(s.blocks, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// counter returns the live counter for a key, resetting it if expired.
+// The caller must hold the shard lock.
+func (s *memoryShard) counter(key string, now time.Time) memoryCounter {
+	counter, ok := s.counters[key]
+	if !ok || !now.Before(counter.expiresAt) {
+		return memoryCounter{}
+	}
+	return counter
+}
+
+// block returns when the block for a key ends and whether it is still active.
+// The caller must hold the shard lock.
+func (s *memoryShard) block(key string, now time.Time) (time.Time, bool) {
+	block, ok := s.blocks[key]
+	if !ok || !now.Before(block.until) {
+		return time.Time{}, false
+	}
+	return block.until, true
+}
@@ -0,0 +1,522 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for deterministic expiry tests
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestMemoryStorage creates a memory storage driven by a fake clock
+func newTestMemoryStorage(t *testing.T) (*MemoryStorage, *fakeClock) {
+	t.Helper()
+
+	clock := newFakeClock()
+	storage := NewMemoryStorage(0)
+	storage.now = clock.Now
+	t.Cleanup(func() { storage.Close() })
+
+	return storage, clock
+}
+
+func TestMemoryStorage_CounterAndBlock(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	ctx := context.Background()
+	testKey := "ip:192.168.1.1"
+
+	// Test Increment
+	for i := int64(1); i <= 2; i++ {
+		count, err := storage.Increment(ctx, testKey, time.Second)
+		if err != nil {
+			t.Fatalf("Increment failed: %v", err)
+		}
+		if count != i {
+			t.Errorf("Expected count %d, got %d", i, count)
+		}
+	}
+
+	// Test Get
+	value, err := storage.Get(ctx, testKey)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if value != 2 {
+		t.Errorf("Expected value 2, got %d", value)
+	}
+
+	// Test: Counter expires after the window
+	clock.Advance(time.Second)
+	value, _ = storage.Get(ctx, testKey)
+	if value != 0 {
+		t.Errorf("Expected expired counter to be 0, got %d", value)
+	}
+
+	// Test SetBlock, IsBlocked and TTL
+	if err := storage.SetBlock(ctx, testKey, 2*time.Second); err != nil {
+		t.Fatalf("SetBlock failed: %v", err)
+	}
+	blocked, _ := storage.IsBlocked(ctx, testKey)
+	if !blocked {
+		t.Error("Expected key to be blocked")
+	}
+	ttl, _ := storage.TTL(ctx, testKey)
+	if ttl != 2*time.Second {
+		t.Errorf("Expected TTL 2s, got %v", ttl)
+	}
+
+	// Test: Block expires
+	clock.Advance(2 * time.Second)
+	blocked, _ = storage.IsBlocked(ctx, testKey)
+	if blocked {
+		t.Error("Expected key to not be blocked after expiration")
+	}
+}
+
+func TestMemoryStorage_Evaluate(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	ctx := context.Background()
+	req := Request{Key: "token:abc123", Limit: 3, Window: time.Second, BlockDuration: 5 * time.Second}
+
+	for i := int64(1); i <= 3; i++ {
+		result, err := storage.Evaluate(ctx, req)
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		if !result.Allowed || result.Remaining != 3-i {
+			t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 3-i, result)
+		}
+		clock.Advance(100 * time.Millisecond)
+	}
+
+	// Test: Expiry is only set on the first hit
+	result, _ := storage.Evaluate(ctx, req)
+	if result.Allowed || !result.Blocked || result.RetryAfter != 5*time.Second {
+		t.Fatalf("Expected blocked result with 5s retry after, got %+v", result)
+	}
+
+	clock.Advance(time.Second)
+	result, _ = storage.Evaluate(ctx, req)
+	if !result.Blocked || result.RetryAfter != 4*time.Second {
+		t.Fatalf("Expected blocked result with 4s retry after, got %+v", result)
+	}
+
+	// Test: New window after the block expires
+	clock.Advance(4 * time.Second)
+	result, _ = storage.Evaluate(ctx, req)
+	if !result.Allowed || result.Remaining != 2 {
+		t.Fatalf("Expected allowed result with 2 remaining, got %+v", result)
+	}
+}
+
+func TestMemoryStorage_EvaluateConcurrent(t *testing.T) {
+	storage, _ := newTestMemoryStorage(t)
+	ctx := context.Background()
+
+	const limit = 100
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 500; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := storage.Evaluate(ctx, Request{Key: "ip:10.0.0.1", Limit: limit, Window: time.Second})
+			if err != nil {
+				t.Errorf("Evaluate failed: %v", err)
+				return
+			}
+			if result.Allowed {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != limit {
+		t.Fatalf("Expected exactly %d allowed requests, got %d", limit, allowed)
+	}
+}
+
+func TestMemoryStorage_TokenBucket(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	ctx := context.Background()
+	req := Request{Algorithm: TokenBucket, Key: "ip:10.0.0.1", Limit: 10, Window: time.Second, Burst: 5}
+
+	// Test: Burst capacity is available immediately
+	for i := int64(1); i <= 5; i++ {
+		result, _ := storage.Evaluate(ctx, req)
+		if !result.Allowed || result.Remaining != 5-i {
+			t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 5-i, result)
+		}
+	}
+
+	// Test: Empty bucket rejects until a token is refilled
+	result, _ := storage.Evaluate(ctx, req)
+	if result.Allowed || result.RetryAfter != 100*time.Millisecond || result.ResetAfter != 500*time.Millisecond {
+		t.Fatalf("Expected rejection with 100ms retry and 500ms reset, got %+v", result)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	result, _ = storage.Evaluate(ctx, req)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Expected allowed result after refill, got %+v", result)
+	}
+
+	// Test: Refill never exceeds the capacity
+	clock.Advance(time.Minute)
+	for i := 1; i <= 6; i++ {
+		result, _ = storage.Evaluate(ctx, req)
+		if result.Allowed != (i <= 5) {
+			t.Fatalf("Request %d after idle: expected allowed=%v, got %+v", i, i <= 5, result)
+		}
+	}
+}
+
+func TestMemoryStorage_BoundaryBurst(t *testing.T) {
+	// One request at the start of a window, four just before its end and
+	// five just after the boundary: the fixed window admits all of the last
+	// five, the sliding algorithms keep enforcing the limit across the boundary
+	tests := []struct {
+		algorithm    Algorithm
+		wantAllowed  int
+		wantMinRetry time.Duration
+		wantMaxRetry time.Duration
+	}{
+		{FixedWindow, 5, 0, 0},
+		{SlidingWindowCounter, 0, 100 * time.Millisecond, 150 * time.Millisecond},
+		{SlidingWindowLog, 1, 850 * time.Millisecond, 850 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.algorithm), func(t *testing.T) {
+			storage, clock := newTestMemoryStorage(t)
+			ctx := context.Background()
+			req := Request{Algorithm: tt.algorithm, Key: "ip:10.0.0.1", Limit: 5, Window: time.Second}
+
+			evaluate := func(n int) (allowed int, last Result) {
+				for i := 0; i < n; i++ {
+					result, err := storage.Evaluate(ctx, req)
+					if err != nil {
+						t.Fatalf("Evaluate failed: %v", err)
+					}
+					if result.Allowed {
+						allowed++
+					}
+					last = result
+				}
+				return allowed, last
+			}
+
+			if allowed, _ := evaluate(1); allowed != 1 {
+				t.Fatal("Expected first request to be allowed")
+			}
+			clock.Advance(900 * time.Millisecond)
+			if allowed, _ := evaluate(4); allowed != 4 {
+				t.Fatalf("Expected 4 requests before the boundary to be allowed, got %d", allowed)
+			}
+
+			clock.Advance(150 * time.Millisecond)
+			allowed, last := evaluate(5)
+			if allowed != tt.wantAllowed {
+				t.Fatalf("Expected %d requests after the boundary to be allowed, got %d", tt.wantAllowed, allowed)
+			}
+			if !last.Allowed && (last.RetryAfter < tt.wantMinRetry || last.RetryAfter > tt.wantMaxRetry) {
+				t.Fatalf("Expected retry after between %v and %v, got %v", tt.wantMinRetry, tt.wantMaxRetry, last.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestMemoryStorage_SlidingWindowCounter(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	ctx := context.Background()
+	req := Request{Algorithm: SlidingWindowCounter, Key: "ip:10.0.0.1", Limit: 4, Window: time.Second}
+
+	for i := int64(1); i <= 4; i++ {
+		result, _ := storage.Evaluate(ctx, req)
+		if !result.Allowed || result.Remaining != 4-i {
+			t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 4-i, result)
+		}
+	}
+
+	// Test: Current window is exhausted until the next one
+	result, _ := storage.Evaluate(ctx, req)
+	if result.Allowed || result.RetryAfter != 1250*time.Millisecond {
+		t.Fatalf("Expected rejection with 1.25s retry after, got %+v", result)
+	}
+
+	// Test: Previous window weight decays as the window slides
+	clock.Advance(1250 * time.Millisecond)
+	result, _ = storage.Evaluate(ctx, req)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Expected allowed result with 0 remaining, got %+v", result)
+	}
+	result, _ = storage.Evaluate(ctx, req)
+	if result.Allowed {
+		t.Fatalf("Expected rejection, got %+v", result)
+	}
+
+	// Test: State older than two windows is discarded
+	clock.Advance(2 * time.Second)
+	result, _ = storage.Evaluate(ctx, req)
+	if !result.Allowed || result.Remaining != 3 {
+		t.Fatalf("Expected allowed result with 3 remaining, got %+v", result)
+	}
+}
+
+func TestMemoryStorage_SlidingWindowLog(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	ctx := context.Background()
+	req := Request{Algorithm: SlidingWindowLog, Key: "ip:10.0.0.1", Limit: 3, Window: time.Second, BlockDuration: 0}
+
+	for i := 0; i < 3; i++ {
+		result, _ := storage.Evaluate(ctx, req)
+		if !result.Allowed {
+			t.Fatalf("Request %d: expected allowed, got %+v", i+1, result)
+		}
+		clock.Advance(300 * time.Millisecond)
+	}
+
+	// Test: Rejected until the oldest request leaves the window
+	result, _ := storage.Evaluate(ctx, req)
+	if result.Allowed || result.RetryAfter != 100*time.Millisecond || result.ResetAfter != 700*time.Millisecond {
+		t.Fatalf("Expected rejection with 100ms retry and 700ms reset, got %+v", result)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	result, _ = storage.Evaluate(ctx, req)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Expected allowed result with 0 remaining, got %+v", result)
+	}
+
+	// Test: Blocks apply once the log is full
+	req.BlockDuration = time.Minute
+	result, _ = storage.Evaluate(ctx, req)
+	if !result.Blocked || result.RetryAfter != time.Minute {
+		t.Fatalf("Expected blocked result with 1m retry after, got %+v", result)
+	}
+}
+
+func TestMemoryStorage_GCRA(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	ctx := context.Background()
+	req := Request{Algorithm: GCRA, Key: "ip:10.0.0.1", Limit: 5, Window: time.Second, Burst: 3}
+
+	// Test: Burst tolerance allows back-to-back requests
+	for i := int64(1); i <= 3; i++ {
+		result, _ := storage.Evaluate(ctx, req)
+		if !result.Allowed || result.Remaining != 3-i || result.ResetAfter != time.Duration(i)*200*time.Millisecond {
+			t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 3-i, result)
+		}
+	}
+
+	// Test: Retry after is derived from the theoretical arrival time
+	result, _ := storage.Evaluate(ctx, req)
+	if result.Allowed || result.RetryAfter != 200*time.Millisecond || result.ResetAfter != 600*time.Millisecond {
+		t.Fatalf("Expected rejection with 200ms retry and 600ms reset, got %+v", result)
+	}
+
+	clock.Advance(150 * time.Millisecond)
+	result, _ = storage.Evaluate(ctx, req)
+	if result.Allowed || result.RetryAfter != 50*time.Millisecond {
+		t.Fatalf("Expected rejection with 50ms retry, got %+v", result)
+	}
+
+	// Test: Requests are spaced by the emission interval
+	clock.Advance(50 * time.Millisecond)
+	result, _ = storage.Evaluate(ctx, req)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Expected allowed result with 0 remaining, got %+v", result)
+	}
+
+	// Test: Only one timestamp is stored per key and it expires at the TAT
+	if len(storage.shard(req.Key).tats) != 1 {
+		t.Fatal("Expected a single stored TAT")
+	}
+	clock.Advance(600 * time.Millisecond)
+	storage.evictExpired()
+	if len(storage.shard(req.Key).tats) != 0 {
+		t.Fatal("Expected TAT to be evicted once reached")
+	}
+}
+
+// testEvaluateCost checks every algorithm of an evaluator with weighted
+// requests: two requests of cost 4 fit in a limit of 10, a third is rejected
+// and a request of cost 2 then fits, except in the fixed window which also
+// counts rejected requests
+func testEvaluateCost(t *testing.T, evaluator Evaluator, key string) {
+	t.Helper()
+
+	for _, algorithm := range []Algorithm{FixedWindow, TokenBucket, SlidingWindowCounter, SlidingWindowLog, GCRA} {
+		ctx := context.Background()
+		req := Request{Algorithm: algorithm, Key: key + ":" + string(algorithm), Limit: 10, Window: time.Minute, Cost: 4}
+
+		for i, wantRemaining := range []int64{6, 2} {
+			result, err := evaluator.Evaluate(ctx, req)
+			if err != nil {
+				t.Fatalf("%s: Evaluate failed: %v", algorithm, err)
+			}
+			if !result.Allowed || result.Remaining != wantRemaining {
+				t.Fatalf("%s: request %d: expected allowed with %d remaining, got %+v", algorithm, i+1, wantRemaining, result)
+			}
+		}
+
+		result, _ := evaluator.Evaluate(ctx, req)
+		if result.Allowed || result.RetryAfter <= 0 {
+			t.Fatalf("%s: expected rejection with a retry delay, got %+v", algorithm, result)
+		}
+
+		req.Cost = 2
+		result, _ = evaluator.Evaluate(ctx, req)
+		if result.Allowed != (algorithm != FixedWindow) {
+			t.Fatalf("%s: expected allowed=%v for a cost of 2, got %+v", algorithm, algorithm != FixedWindow, result)
+		}
+	}
+}
+
+func TestMemoryStorage_Cost(t *testing.T) {
+	storage, _ := newTestMemoryStorage(t)
+	testEvaluateCost(t, storage, "ip:10.0.0.1")
+
+	// Test: Counters are incremented by n
+	ctx := context.Background()
+	storage.IncrementBy(ctx, "ip:10.0.0.2", 50, time.Second)
+	count, _ := storage.Increment(ctx, "ip:10.0.0.2", time.Second)
+	if count != 51 {
+		t.Fatalf("Expected count 51, got %d", count)
+	}
+}
+
+// testAdminOperations checks listing, blocking with a reason, unblocking and
+// deleting keys below prefix
+func testAdminOperations(t *testing.T, storage Storage, prefix string) {
+	t.Helper()
+	ctx := context.Background()
+	key := prefix + "ip:10.0.0.1"
+	windowKey := key + ":1h0m0s"
+
+	storage.Increment(ctx, key, time.Minute)
+	if _, err := storage.(Evaluator).Evaluate(ctx, Request{Algorithm: GCRA, Key: windowKey, Limit: 10, Window: time.Hour}); err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	storage.Increment(ctx, prefix+"token:abc123", time.Minute)
+
+	keys, err := storage.List(ctx, prefix+"ip:")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(keys) != 2 || keys[0] != key || keys[1] != windowKey {
+		t.Fatalf("Expected %s and %s, got %v", key, windowKey, keys)
+	}
+
+	// Test: Block with a reason
+	if err := storage.SetBlockWithReason(ctx, key, time.Hour, "abuse report"); err != nil {
+		t.Fatalf("SetBlockWithReason failed: %v", err)
+	}
+	blocked, _ := storage.IsBlocked(ctx, key)
+	reason, _ := storage.BlockReason(ctx, key)
+	if !blocked || reason != "abuse report" {
+		t.Fatalf("Expected a block for abuse report, got blocked=%v reason=%q", blocked, reason)
+	}
+	keys, err = storage.ListBlocked(ctx, prefix)
+	if err != nil {
+		t.Fatalf("ListBlocked failed: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != key {
+		t.Fatalf("Expected only %s blocked, got %v", key, keys)
+	}
+
+	// Test: Unblock keeps the counter
+	if err := storage.Unblock(ctx, key); err != nil {
+		t.Fatalf("Unblock failed: %v", err)
+	}
+	blocked, _ = storage.IsBlocked(ctx, key)
+	reason, _ = storage.BlockReason(ctx, key)
+	count, _ := storage.Get(ctx, key)
+	if blocked || reason != "" || count != 1 {
+		t.Fatalf("Expected an unblocked key with count 1, got blocked=%v reason=%q count=%d", blocked, reason, count)
+	}
+
+	// Test: Automatic blocks have no reason
+	storage.SetBlock(ctx, key, time.Hour)
+	if reason, _ := storage.BlockReason(ctx, key); reason != "" {
+		t.Fatalf("Expected no reason, got %q", reason)
+	}
+
+	// Test: Delete removes the counter, state and block
+	for _, k := range []string{key, windowKey} {
+		if err := storage.Delete(ctx, k); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	blocked, _ = storage.IsBlocked(ctx, key)
+	count, _ = storage.Get(ctx, key)
+	keys, _ = storage.List(ctx, prefix)
+	if blocked || count != 0 || len(keys) != 1 || keys[0] != prefix+"token:abc123" {
+		t.Fatalf("Expected only the token key left, got blocked=%v count=%d keys=%v", blocked, count, keys)
+	}
+}
+
+func TestMemoryStorage_Admin(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	testAdminOperations(t, storage, "")
+
+	// Test: Expired keys are not listed
+	clock.Advance(time.Minute)
+	if keys, _ := storage.List(context.Background(), ""); len(keys) != 0 {
+		t.Fatalf("Expected no keys after expiration, got %v", keys)
+	}
+}
+
+func TestMemoryStorage_UnsupportedAlgorithm(t *testing.T) {
+	storage, _ := newTestMemoryStorage(t)
+
+	_, err := storage.Evaluate(context.Background(), Request{Algorithm: "unknown", Key: "ip:1", Limit: 1, Window: time.Second})
+	if !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("Expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
+
+func TestMemoryStorage_Janitor(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	ctx := context.Background()
+
+	storage.Increment(ctx, "ip:1", time.Second)
+	storage.SetBlock(ctx, "ip:1", time.Second)
+	storage.Increment(ctx, "ip:2", time.Minute)
+
+	clock.Advance(time.Second)
+	storage.evictExpired()
+
+	var counters, blocks int
+	for _, s := range storage.shards {
+		counters += len(s.counters)
+		blocks += len(s.blocks)
+	}
+	if counters != 1 || blocks != 0 {
+		t.Fatalf("Expected 1 counter and 0 blocks after eviction, got %d and %d", counters, blocks)
+	}
+}
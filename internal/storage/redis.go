@@ -3,23 +3,34 @@ package storage
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
 const (
-	blockPrefix   = "block:"
-	counterPrefix = "counter:"
+	blockPrefix       = "block:"
+	blockReasonPrefix = "block_reason:"
+	counterPrefix     = "counter:"
+	bucketPrefix      = "bucket:"
+	slidingPrefix     = "sliding:"
+	logPrefix         = "log:"
+	gcraPrefix        = "gcra:"
 )
 
+// keyPrefixes are the prefixes of every Redis key holding the state of a key
+var keyPrefixes = []string{blockPrefix, blockReasonPrefix, counterPrefix, bucketPrefix, slidingPrefix, logPrefix, gcraPrefix}
+
 // RedisStorage implements Storage interface using Redis
 type RedisStorage struct {
 	client *redis.Client
 }
 
 // NewRedisStorage creates a new Redis storage instance
-func NewRedisStorage(addr, password string, db int) (*RedisStorage, error) {
+func NewRedisStorage(addr, password string, db int, opts ...RedisOption) (*RedisStorage, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
@@ -34,17 +45,26 @@ func NewRedisStorage(addr, password string, db int) (*RedisStorage, error) {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	return &RedisStorage{
+	r := &RedisStorage{
 		client: client,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
 }
 
 // Increment increments the counter for a key
 func (r *RedisStorage) Increment(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	return r.IncrementBy(ctx, key, 1, expiration)
+}
+
+// IncrementBy increments the counter for a key by n
+func (r *RedisStorage) IncrementBy(ctx context.Context, key string, n int64, expiration time.Duration) (int64, error) {
 	counterKey := counterPrefix + key
 
 	pipe := r.client.Pipeline()
-	incr := pipe.Incr(ctx, counterKey)
+	incr := pipe.IncrBy(ctx, counterKey, n)
 	pipe.Expire(ctx, counterKey, expiration)
 
 	if _, err := pipe.Exec(ctx); err != nil {
@@ -54,6 +74,49 @@ func (r *RedisStorage) Increment(ctx context.Context, key string, expiration tim
 	return incr.Val(), nil
 }
 
+// Evaluate atomically checks, applies the algorithm and blocks a key in a
+// single round trip
+func (r *RedisStorage) Evaluate(ctx context.Context, req Request) (Result, error) {
+	var cmd *redis.Cmd
+	switch req.Algorithm {
+	case FixedWindow, "":
+		cmd = fixedWindowScript.Run(ctx, r.client,
+			[]string{blockPrefix + req.Key, counterPrefix + req.Key},
+			req.Limit, req.Window.Milliseconds(), req.BlockDuration.Milliseconds(), cost(req))
+	case TokenBucket:
+		cmd = tokenBucketScript.Run(ctx, r.client,
+			[]string{blockPrefix + req.Key, bucketPrefix + req.Key},
+			req.Limit, req.Window.Milliseconds(), burst(req), req.BlockDuration.Milliseconds(), cost(req))
+	case SlidingWindowCounter:
+		cmd = slidingWindowCounterScript.Run(ctx, r.client,
+			[]string{blockPrefix + req.Key, slidingPrefix + req.Key},
+			req.Limit, req.Window.Milliseconds(), req.BlockDuration.Milliseconds(), cost(req))
+	case SlidingWindowLog:
+		cmd = slidingWindowLogScript.Run(ctx, r.client,
+			[]string{blockPrefix + req.Key, logPrefix + req.Key},
+			req.Limit, req.Window.Milliseconds(), req.BlockDuration.Milliseconds(), cost(req))
+	case GCRA:
+		cmd = gcraScript.Run(ctx, r.client,
+			[]string{blockPrefix + req.Key, gcraPrefix + req.Key},
+			req.Limit, req.Window.Milliseconds(), burst(req), req.BlockDuration.Milliseconds(), cost(req))
+	default:
+		return Result{}, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, req.Algorithm)
+	}
+
+	vals, err := cmd.Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to evaluate rate limit: %w", err)
+	}
+
+	return Result{
+		Allowed:    vals[0] == 1,
+		Blocked:    vals[1] == 1,
+		Remaining:  vals[2],
+		ResetAfter: durationFromMillis(vals[3]),
+		RetryAfter: durationFromMillis(vals[4]),
+	}, nil
+}
+
 // Get returns the current counter value for a key
 func (r *RedisStorage) Get(ctx context.Context, key string) (int64, error) {
 	counterKey := counterPrefix + key
@@ -69,13 +132,110 @@ func (r *RedisStorage) Get(ctx context.Context, key string) (int64, error) {
 
 // SetBlock sets a block for a key
 func (r *RedisStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
-	blockKey := blockPrefix + key
-	if err := r.client.Set(ctx, blockKey, "1", duration).Err(); err != nil {
+	return r.SetBlockWithReason(ctx, key, duration, "")
+}
+
+// SetBlockWithReason sets a block for a key with the reason it was imposed
+// for, kept in a key of its own expiring with the block
+func (r *RedisStorage) SetBlockWithReason(ctx context.Context, key string, duration time.Duration, reason string) error {
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, blockPrefix+key, "1", duration)
+	if reason != "" {
+		pipe.Set(ctx, blockReasonPrefix+key, reason, duration)
+	} else {
+		pipe.Del(ctx, blockReasonPrefix+key)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
 		return fmt.Errorf("failed to set block: %w", err)
 	}
 	return nil
 }
 
+// BlockReason returns the reason of the block of a key
+func (r *RedisStorage) BlockReason(ctx context.Context, key string) (string, error) {
+	reason, err := r.client.Get(ctx, blockReasonPrefix+key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get block reason: %w", err)
+	}
+	return reason, nil
+}
+
+// Unblock lifts the block of a key
+func (r *RedisStorage) Unblock(ctx context.Context, key string) error {
+	if err := r.client.Del(ctx, blockPrefix+key, blockReasonPrefix+key).Err(); err != nil {
+		return fmt.Errorf("failed to unblock: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the counter, the algorithm state and the block of a key
+func (r *RedisStorage) Delete(ctx context.Context, key string) error {
+	keys := make([]string, 0, len(keyPrefixes))
+	for _, prefix := range keyPrefixes {
+		keys = append(keys, prefix+key)
+	}
+	if err := r.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+	return nil
+}
+
+// List returns the keys starting with prefix, scanning the Redis keys of
+// every algorithm without blocking the server
+func (r *RedisStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, keyPrefix := range keyPrefixes {
+		iter := r.client.Scan(ctx, 0, keyPrefix+escapeGlob(prefix)+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			seen[strings.TrimPrefix(iter.Val(), keyPrefix)] = true
+		}
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("failed to list keys: %w", err)
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ListBlocked returns the blocked keys starting with prefix, scanning only
+// the block keys
+func (r *RedisStorage) ListBlocked(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	iter := r.client.Scan(ctx, 0, blockPrefix+escapeGlob(prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), blockPrefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list blocked keys: %w", err)
+	}
+
+	// SCAN may return a key more than once
+	sort.Strings(keys)
+	return slices.Compact(keys), nil
+}
+
+// escapeGlob escapes the characters of s special to the Redis MATCH pattern
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
 // IsBlocked checks if a key is blocked
 func (r *RedisStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
 	blockKey := blockPrefix + key
@@ -89,13 +249,18 @@ func (r *RedisStorage) IsBlocked(ctx context.Context, key string) (bool, error)
 	return val == "1", nil
 }
 
-// TTL returns the time to live for a key
+// TTL returns the remaining block duration for a key, or zero if it is not
+// blocked, as the memory storage does
 func (r *RedisStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
 	blockKey := blockPrefix + key
 	ttl, err := r.client.TTL(ctx, blockKey).Result()
 	if err != nil {
 		return 0, fmt.Errorf("failed to get TTL: %w", err)
 	}
+	// Redis replies -2 for a missing key and -1 for a key without expiry
+	if ttl < 0 {
+		return 0, nil
+	}
 	return ttl, nil
 }
 
@@ -103,3 +268,13 @@ func (r *RedisStorage) TTL(ctx context.Context, key string) (time.Duration, erro
 func (r *RedisStorage) Close() error {
 	return r.client.Close()
 }
+
+// durationFromMillis converts a PTTL reply to a duration. The negative
+// values Redis replies for missing keys or keys without expiry, e.g. a
+// block set by hand, are reported as zero, never as a negative wait.
+func durationFromMillis(ms int64) time.Duration {
+	if ms < 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,229 @@
+package storage
+
+import "github.com/go-redis/redis/v8"
+
+// Every evaluation script receives the block key as KEYS[1] and the
+// algorithm state key as KEYS[2], and returns
+// {allowed, blocked, remaining, reset after in ms, retry after in ms}.
+// The last argument is the cost of the request in units.
+
+// fixedWindowScript checks the block key, increments the counter by the
+// cost, sets the window expiry on the first hit and applies the block once
+// the limit is exceeded.
+//
+// ARGV[1] limit, ARGV[2] window in ms, ARGV[3] block duration in ms, ARGV[4] cost
+var fixedWindowScript = redis.NewScript(`
+local blockTTL = redis.call('PTTL', KEYS[1])
+if blockTTL ~= -2 then
+	return {0, 1, 0, blockTTL, blockTTL}
+end
+
+local limit = tonumber(ARGV[1])
+local cost = tonumber(ARGV[4])
+local count = redis.call('INCRBY', KEYS[2], cost)
+if count == cost then
+	redis.call('PEXPIRE', KEYS[2], ARGV[2])
+end
+local ttl = redis.call('PTTL', KEYS[2])
+
+if count > limit then
+	local block = tonumber(ARGV[3])
+	if block > 0 then
+		redis.call('SET', KEYS[1], '1', 'PX', block)
+		return {0, 1, 0, block, block}
+	end
+	return {0, 0, 0, ttl, ttl}
+end
+
+return {1, 0, limit - count, ttl, 0}
+`)
+
+// tokenBucketScript checks the block key, refills the bucket for the time
+// elapsed since the last request using the Redis server clock, takes one
+// token per unit of cost and applies the block when not enough are left.
+//
+// ARGV[1] limit, ARGV[2] window in ms, ARGV[3] burst, ARGV[4] block duration in ms, ARGV[5] cost
+var tokenBucketScript = redis.NewScript(`
+local blockTTL = redis.call('PTTL', KEYS[1])
+if blockTTL ~= -2 then
+	return {0, 1, 0, blockTTL, blockTTL}
+end
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + tonumber(time[2]) / 1000
+local rate = tonumber(ARGV[1]) / tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local cost = tonumber(ARGV[5])
+
+local bucket = redis.call('HMGET', KEYS[2], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+
+local reset = math.ceil((burst - tokens) / rate)
+redis.call('HSET', KEYS[2], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[2], math.max(reset, 1))
+
+if allowed == 1 then
+	return {1, 0, math.floor(tokens), reset, 0}
+end
+
+local block = tonumber(ARGV[4])
+if block > 0 then
+	redis.call('SET', KEYS[1], '1', 'PX', block)
+	return {0, 1, 0, block, block}
+end
+return {0, 0, 0, reset, math.ceil((cost - tokens) / rate)}
+`)
+
+// slidingWindowCounterScript checks the block key and estimates the request
+// count as the current window count plus the previous window count weighted
+// by how much of it still overlaps the sliding window, using the Redis server
+// clock. The state hash holds the current window index and both counts.
+//
+// ARGV[1] limit, ARGV[2] window in ms, ARGV[3] block duration in ms, ARGV[4] cost
+var slidingWindowCounterScript = redis.NewScript(`
+local blockTTL = redis.call('PTTL', KEYS[1])
+if blockTTL ~= -2 then
+	return {0, 1, 0, blockTTL, blockTTL}
+end
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[4])
+local idx = math.floor(now / window)
+
+local state = redis.call('HMGET', KEYS[2], 'idx', 'cur', 'prev')
+local stored = tonumber(state[1])
+local cur = tonumber(state[2]) or 0
+local prev = tonumber(state[3]) or 0
+if stored ~= idx then
+	if stored == idx - 1 then
+		prev = cur
+	else
+		prev = 0
+	end
+	cur = 0
+end
+
+local elapsed = now - idx * window
+local estimate = prev * (window - elapsed) / window + cur
+
+if estimate + cost <= limit then
+	cur = cur + cost
+	redis.call('HSET', KEYS[2], 'idx', idx, 'cur', cur, 'prev', prev)
+	redis.call('PEXPIRE', KEYS[2], 2 * window)
+	return {1, 0, math.floor(limit - estimate - cost), window - elapsed, 0}
+end
+
+redis.call('HSET', KEYS[2], 'idx', idx, 'cur', cur, 'prev', prev)
+redis.call('PEXPIRE', KEYS[2], 2 * window)
+
+local block = tonumber(ARGV[3])
+if block > 0 then
+	redis.call('SET', KEYS[1], '1', 'PX', block)
+	return {0, 1, 0, block, block}
+end
+
+local retry
+if cur + cost <= limit then
+	retry = math.ceil(window * (1 - (limit - cost - cur) / prev)) - elapsed
+elseif cost <= limit then
+	retry = window - elapsed + math.ceil(window * (1 - (limit - cost) / cur))
+else
+	retry = window - elapsed
+end
+return {0, 0, 0, window - elapsed, math.max(retry, 1)}
+`)
+
+// slidingWindowLogScript checks the block key, drops the request timestamps
+// that left the window from a sorted set and records one entry per unit of
+// cost if they fit within the limit, using the Redis server clock.
+//
+// ARGV[1] limit, ARGV[2] window in ms, ARGV[3] block duration in ms, ARGV[4] cost
+var slidingWindowLogScript = redis.NewScript(`
+local blockTTL = redis.call('PTTL', KEYS[1])
+if blockTTL ~= -2 then
+	return {0, 1, 0, blockTTL, blockTTL}
+end
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + tonumber(time[2]) / 1000
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[4])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[2])
+
+if count + cost <= limit then
+	for i = 1, cost do
+		redis.call('ZADD', KEYS[2], now, time[1] .. '.' .. time[2] .. '-' .. (count + i))
+	end
+	redis.call('PEXPIRE', KEYS[2], window)
+	return {1, 0, limit - count - cost, window, 0}
+end
+
+local block = tonumber(ARGV[3])
+if block > 0 then
+	redis.call('SET', KEYS[1], '1', 'PX', block)
+	return {0, 1, 0, block, block}
+end
+
+if cost > limit then
+	return {0, 0, 0, window, window}
+end
+
+local oldest = redis.call('ZRANGE', KEYS[2], count + cost - limit - 1, count + cost - limit - 1, 'WITHSCORES')
+local newest = redis.call('ZRANGE', KEYS[2], -1, -1, 'WITHSCORES')
+local retry = math.ceil(tonumber(oldest[2]) + window - now)
+local reset = math.ceil(tonumber(newest[2]) + window - now)
+return {0, 0, 0, reset, math.max(retry, 1)}
+`)
+
+// gcraScript checks the block key and applies the generic cell rate
+// algorithm, storing only the theoretical arrival time (TAT) of the next
+// request in microseconds of the Redis server clock. A request is allowed
+// when it does not arrive earlier than the TAT minus the burst tolerance,
+// and advances the TAT by one emission interval per unit of cost.
+//
+// ARGV[1] limit, ARGV[2] window in ms, ARGV[3] burst, ARGV[4] block duration in ms, ARGV[5] cost
+var gcraScript = redis.NewScript(`
+local blockTTL = redis.call('PTTL', KEYS[1])
+if blockTTL ~= -2 then
+	return {0, 1, 0, blockTTL, blockTTL}
+end
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local interval = tonumber(ARGV[2]) * 1000 / tonumber(ARGV[1])
+local tolerance = interval * tonumber(ARGV[3])
+
+local tat = tonumber(redis.call('GET', KEYS[2])) or now
+if tat < now then
+	tat = now
+end
+local newTat = tat + interval * tonumber(ARGV[5])
+local allowAt = newTat - tolerance
+
+if now < allowAt then
+	local block = tonumber(ARGV[4])
+	if block > 0 then
+		redis.call('SET', KEYS[1], '1', 'PX', block)
+		return {0, 1, 0, block, block}
+	end
+	return {0, 0, 0, math.ceil((tat - now) / 1000), math.ceil((allowAt - now) / 1000)}
+end
+
+local reset = math.ceil((newTat - now) / 1000)
+redis.call('SET', KEYS[2], string.format('%.0f', newTat), 'PX', reset)
+return {1, 0, math.floor((now - allowAt) / interval), reset, 0}
+`)
@@ -2,9 +2,12 @@ package storage
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
 )
 
@@ -100,3 +103,326 @@ func TestRedisStorage_Integration(t *testing.T) {
 		t.Error("Expected key to not be blocked after expiration")
 	}
 }
+
+// newTestRedisStorage connects to a local Redis instance, or to an
+// in-process miniredis server if it is not available so the Lua scripts
+// always run, and deletes the given keys before and after the test
+func newTestRedisStorage(t *testing.T, keys ...string) *RedisStorage {
+	t.Helper()
+
+	storage, err := NewRedisStorage("localhost:6379", "", 0)
+	if err != nil {
+		storage, err = NewRedisStorage(miniredis.RunT(t).Addr(), "", 0)
+		if err != nil {
+			t.Fatalf("Failed to connect to miniredis: %v", err)
+		}
+	}
+
+	cleanup := func() {
+		for _, key := range keys {
+			storage.Delete(context.Background(), key)
+		}
+	}
+	cleanup()
+	t.Cleanup(func() {
+		cleanup()
+		storage.Close()
+	})
+
+	return storage
+}
+
+func TestRedisStorage_Evaluate(t *testing.T) {
+	testKey := "test:evaluate:key"
+	storage := newTestRedisStorage(t, testKey)
+	ctx := context.Background()
+	req := Request{Key: testKey, Limit: 3, Window: time.Second, BlockDuration: 2 * time.Second}
+
+	// Test: Requests within limit are allowed
+	for i := int64(1); i <= 3; i++ {
+		result, err := storage.Evaluate(ctx, req)
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		if !result.Allowed || result.Remaining != 3-i {
+			t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 3-i, result)
+		}
+	}
+
+	// Test: Exceeding the limit blocks the key
+	result, err := storage.Evaluate(ctx, req)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result.Allowed || !result.Blocked {
+		t.Fatalf("Expected request to be blocked, got %+v", result)
+	}
+
+	blocked, err := storage.IsBlocked(ctx, testKey)
+	if err != nil {
+		t.Fatalf("IsBlocked failed: %v", err)
+	}
+	if !blocked {
+		t.Error("Expected key to be blocked")
+	}
+
+	// Test: Blocked key is rejected without incrementing the counter
+	result, err = storage.Evaluate(ctx, req)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result.Allowed || !result.Blocked || result.RetryAfter <= 0 || result.RetryAfter > 2*time.Second {
+		t.Fatalf("Expected blocked result with retry after up to 2s, got %+v", result)
+	}
+
+	count, err := storage.Get(ctx, testKey)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("Expected counter to stay at 4, got %d", count)
+	}
+}
+
+func TestRedisStorage_EvaluateConcurrent(t *testing.T) {
+	testKey := "test:evaluate:concurrent"
+	storage := newTestRedisStorage(t, testKey)
+	ctx := context.Background()
+
+	const limit = 100
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 500; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := storage.Evaluate(ctx, Request{
+				Key:           testKey,
+				Limit:         limit,
+				Window:        10 * time.Second,
+				BlockDuration: 10 * time.Second,
+			})
+			if err != nil {
+				t.Errorf("Evaluate failed: %v", err)
+				return
+			}
+			if result.Allowed {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != limit {
+		t.Fatalf("Expected exactly %d allowed requests, got %d", limit, allowed)
+	}
+}
+
+func TestRedisStorage_TokenBucket(t *testing.T) {
+	testKey := "test:evaluate:bucket"
+	storage := newTestRedisStorage(t, testKey)
+	ctx := context.Background()
+	req := Request{Algorithm: TokenBucket, Key: testKey, Limit: 10, Window: time.Second, Burst: 3}
+
+	// Test: Burst capacity is available immediately
+	for i := int64(1); i <= 3; i++ {
+		result, err := storage.Evaluate(ctx, req)
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		if !result.Allowed || result.Remaining != 3-i {
+			t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 3-i, result)
+		}
+	}
+
+	// Test: Empty bucket rejects with a retry after of about one token
+	result, err := storage.Evaluate(ctx, req)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result.Allowed || result.Blocked || result.RetryAfter <= 0 || result.RetryAfter > 100*time.Millisecond {
+		t.Fatalf("Expected rejection with retry after up to 100ms, got %+v", result)
+	}
+
+	// Test: Tokens refill over time
+	time.Sleep(150 * time.Millisecond)
+	result, err = storage.Evaluate(ctx, req)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if !result.Allowed {
+		t.Fatalf("Expected request to be allowed after refill, got %+v", result)
+	}
+}
+
+func TestRedisStorage_SlidingWindows(t *testing.T) {
+	for _, algorithm := range []Algorithm{SlidingWindowCounter, SlidingWindowLog} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			testKey := "test:evaluate:" + string(algorithm)
+			storage := newTestRedisStorage(t, testKey)
+			ctx := context.Background()
+			req := Request{Algorithm: algorithm, Key: testKey, Limit: 3, Window: time.Second}
+
+			for i := int64(1); i <= 3; i++ {
+				result, err := storage.Evaluate(ctx, req)
+				if err != nil {
+					t.Fatalf("Evaluate failed: %v", err)
+				}
+				if !result.Allowed || result.Remaining != 3-i {
+					t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 3-i, result)
+				}
+			}
+
+			// Test: Rejected with a retry after within two windows
+			result, err := storage.Evaluate(ctx, req)
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+			if result.Allowed || result.Blocked || result.RetryAfter <= 0 || result.RetryAfter > 2*time.Second {
+				t.Fatalf("Expected rejection with retry after up to 2s, got %+v", result)
+			}
+
+			// Test: Allowed again once the retry after elapsed
+			time.Sleep(result.RetryAfter + 20*time.Millisecond)
+			result, err = storage.Evaluate(ctx, req)
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+			if !result.Allowed {
+				t.Fatalf("Expected request to be allowed after retry after, got %+v", result)
+			}
+
+			// Test: Blocks apply once the limit is exceeded
+			req.BlockDuration = time.Minute
+			for i := 0; i < 3; i++ {
+				result, _ = storage.Evaluate(ctx, req)
+			}
+			if !result.Blocked || result.RetryAfter != time.Minute {
+				t.Fatalf("Expected blocked result with 1m retry after, got %+v", result)
+			}
+		})
+	}
+}
+
+func TestRedisStorage_GCRA(t *testing.T) {
+	testKey := "test:evaluate:gcra"
+	storage := newTestRedisStorage(t, testKey)
+	ctx := context.Background()
+	req := Request{Algorithm: GCRA, Key: testKey, Limit: 10, Window: time.Second, Burst: 3}
+
+	// Test: Burst tolerance allows back-to-back requests
+	for i := int64(1); i <= 3; i++ {
+		result, err := storage.Evaluate(ctx, req)
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		if !result.Allowed || result.Remaining != 3-i {
+			t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 3-i, result)
+		}
+	}
+
+	// Test: Retry after is about one emission interval
+	result, err := storage.Evaluate(ctx, req)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 100*time.Millisecond {
+		t.Fatalf("Expected rejection with retry after up to 100ms, got %+v", result)
+	}
+
+	// Test: The key state is a single string holding the TAT
+	keyType, err := storage.client.Type(ctx, gcraPrefix+testKey).Result()
+	if err != nil {
+		t.Fatalf("Type failed: %v", err)
+	}
+	if keyType != "string" {
+		t.Errorf("Expected TAT stored as a string, got %s", keyType)
+	}
+
+	time.Sleep(result.RetryAfter + 20*time.Millisecond)
+	result, err = storage.Evaluate(ctx, req)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if !result.Allowed {
+		t.Fatalf("Expected request to be allowed after retry after, got %+v", result)
+	}
+}
+
+func TestRedisStorage_Cost(t *testing.T) {
+	testKey := "test:cost:key"
+	algorithms := []Algorithm{FixedWindow, TokenBucket, SlidingWindowCounter, SlidingWindowLog, GCRA}
+	keys := []string{testKey}
+	for _, algorithm := range algorithms {
+		keys = append(keys, testKey+":"+string(algorithm))
+	}
+	storage := newTestRedisStorage(t, keys...)
+
+	testEvaluateCost(t, storage, testKey)
+
+	// Test: Counters are incremented by n
+	ctx := context.Background()
+	storage.IncrementBy(ctx, testKey, 50, time.Second)
+	count, _ := storage.Increment(ctx, testKey, time.Second)
+	if count != 51 {
+		t.Fatalf("Expected count 51, got %d", count)
+	}
+}
+
+func TestRedisStorage_TTLNotBlocked(t *testing.T) {
+	key := "test:ttl:key"
+	storage := newTestRedisStorage(t, key)
+	ctx := context.Background()
+
+	// Test: A key that was never blocked has no TTL
+	if ttl, err := storage.TTL(ctx, key); err != nil || ttl != 0 {
+		t.Fatalf("Expected TTL 0 for a key that is not blocked, got %v (%v)", ttl, err)
+	}
+
+	// Test: A lifted block has no TTL either
+	if err := storage.SetBlock(ctx, key, time.Minute); err != nil {
+		t.Fatalf("SetBlock failed: %v", err)
+	}
+	if err := storage.Unblock(ctx, key); err != nil {
+		t.Fatalf("Unblock failed: %v", err)
+	}
+	if ttl, err := storage.TTL(ctx, key); err != nil || ttl != 0 {
+		t.Fatalf("Expected TTL 0 after unblocking, got %v (%v)", ttl, err)
+	}
+}
+
+func TestRedisStorage_BlockWithoutExpiry(t *testing.T) {
+	for _, algorithm := range []Algorithm{FixedWindow, TokenBucket, SlidingWindowCounter, SlidingWindowLog, GCRA} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			key := "test:noexpiry:" + string(algorithm)
+			storage := newTestRedisStorage(t, key)
+			ctx := context.Background()
+
+			// A block key without expiry, as set by hand with redis-cli,
+			// makes the scripts reply a PTTL of -1
+			if err := storage.client.Set(ctx, blockPrefix+key, "1", 0).Err(); err != nil {
+				t.Fatalf("Set failed: %v", err)
+			}
+
+			result, err := storage.Evaluate(ctx, Request{Algorithm: algorithm, Key: key, Limit: 3, Window: time.Second})
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+			if result.Allowed || !result.Blocked || result.RetryAfter != 0 || result.ResetAfter != 0 {
+				t.Fatalf("Expected a blocked result without negative durations, got %+v", result)
+			}
+		})
+	}
+}
+
+func TestRedisStorage_Admin(t *testing.T) {
+	prefix := "test:admin:"
+	storage := newTestRedisStorage(t, prefix+"ip:10.0.0.1", prefix+"ip:10.0.0.1:1h0m0s", prefix+"token:abc123")
+
+	testAdminOperations(t, storage, prefix)
+
+	// Test: Glob characters of the prefix are matched literally
+	if keys, err := storage.List(context.Background(), "test:adm*"); err != nil || len(keys) != 0 {
+		t.Fatalf("Expected no keys for a literal *, got %v (%v)", keys, err)
+	}
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the storage spans
+const tracerName = "github.com/allis/rate-limiter/internal/storage"
+
+// RedisOption configures a RedisStorage
+type RedisOption func(*RedisStorage)
+
+// WithRedisTracing creates a span with provider for every Redis command, a
+// child of the span in the context of the storage call. Calls without a
+// span, e.g. the connection check, are not traced. Command arguments hold
+// tokens and are never recorded.
+func WithRedisTracing(provider trace.TracerProvider) RedisOption {
+	return func(r *RedisStorage) {
+		r.client.AddHook(&tracingHook{
+			tracer: provider.Tracer(tracerName),
+			attrs: []attribute.KeyValue{
+				attribute.String("db.system", "redis"),
+				attribute.String("server.address", r.client.Options().Addr),
+			},
+		})
+	}
+}
+
+// tracingHook is a redis.Hook creating a span per command or pipeline
+type tracingHook struct {
+	tracer trace.Tracer
+	attrs  []attribute.KeyValue
+}
+
+// commandSpanKey is the context key of the span started by tracingHook, kept
+// apart from the span of the caller
+type commandSpanKey struct{}
+
+// BeforeProcess starts the span of a command
+func (h *tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.start(ctx, cmd.FullName(), attribute.String("db.operation.name", cmd.FullName())), nil
+}
+
+// AfterProcess ends the span of a command
+func (h *tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endSpan(ctx, cmd.Err())
+	return nil
+}
+
+// BeforeProcessPipeline starts the span of a pipeline
+func (h *tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return h.start(ctx, "pipeline", attribute.Int("db.operation.batch.size", len(cmds))), nil
+}
+
+// AfterProcessPipeline ends the span of a pipeline with its first error
+func (h *tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+			break
+		}
+	}
+	endSpan(ctx, err)
+	return nil
+}
+
+// start starts a client span if ctx carries a span to attach it to
+func (h *tracingHook) start(ctx context.Context, name string, attr attribute.KeyValue) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	ctx, span := h.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(h.attrs...),
+		trace.WithAttributes(attr))
+	return context.WithValue(ctx, commandSpanKey{}, span)
+}
+
+// endSpan ends the span started by start, if any, recording err unless it
+// reports a missing key
+func endSpan(ctx context.Context, err error) {
+	span, ok := ctx.Value(commandSpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if err != nil && !errors.Is(err, redis.Nil) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
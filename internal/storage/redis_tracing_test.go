@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRedisStorage_Tracing(t *testing.T) {
+	testKey := "test:tracing:key"
+	storage := newTestRedisStorage(t, testKey)
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	WithRedisTracing(provider)(storage)
+
+	// Test: Calls without a span are not traced
+	if _, err := storage.Get(context.Background(), testKey); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if spans := recorder.Ended(); len(spans) != 0 {
+		t.Fatalf("Expected no spans without a parent, got %d", len(spans))
+	}
+
+	// Test: Commands are children of the span of the call
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "check")
+	if _, err := storage.Get(ctx, testKey); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected the command and the parent span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "get" || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("Expected a get span child of the parent span, got %q", span.Name())
+	}
+
+	// Test: A missing key is not an error
+	if span.Status().Code != codes.Unset {
+		t.Errorf("Expected no error for a missing key, got %v", span.Status())
+	}
+	attrs := make(map[string]string)
+	for _, kv := range span.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["db.system"] != "redis" || attrs["db.operation.name"] != "get" {
+		t.Errorf("Expected the Redis attributes, got %v", attrs)
+	}
+}
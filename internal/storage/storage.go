@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
@@ -11,6 +12,10 @@ type Storage interface {
 	// If the key doesn't exist, it creates it with value 1 and sets the expiration
 	Increment(ctx context.Context, key string, expiration time.Duration) (int64, error)
 
+	// IncrementBy increments the counter for a key by n and returns the new
+	// value, creating it with value n and the expiration if it doesn't exist
+	IncrementBy(ctx context.Context, key string, n int64, expiration time.Duration) (int64, error)
+
 	// Get returns the current counter value for a key
 	Get(ctx context.Context, key string) (int64, error)
 
@@ -23,6 +28,110 @@ type Storage interface {
 	// TTL returns the time to live for a key
 	TTL(ctx context.Context, key string) (time.Duration, error)
 
+	// SetBlockWithReason sets a block for a key with the specified duration,
+	// recording why it was imposed, e.g. by an operator
+	SetBlockWithReason(ctx context.Context, key string, duration time.Duration, reason string) error
+
+	// BlockReason returns the reason of the block of a key, empty if it is
+	// not blocked or was blocked for exceeding its limit
+	BlockReason(ctx context.Context, key string) (string, error)
+
+	// Unblock lifts the block of a key, keeping its counter and algorithm state
+	Unblock(ctx context.Context, key string) error
+
+	// Delete removes the counter, the algorithm state and the block of a key
+	Delete(ctx context.Context, key string) error
+
+	// List returns the keys starting with prefix that have a counter, an
+	// algorithm state or a block, in lexical order
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	// ListBlocked returns the blocked keys starting with prefix, in lexical
+	// order
+	ListBlocked(ctx context.Context, prefix string) ([]string, error)
+
 	// Close closes the storage connection
 	Close() error
 }
+
+// Algorithm identifies a rate limiting algorithm
+type Algorithm string
+
+// Supported rate limiting algorithms
+const (
+	// FixedWindow counts requests in consecutive windows of fixed length
+	FixedWindow Algorithm = "fixed_window"
+	// TokenBucket refills Limit tokens per Window up to a capacity of Burst
+	TokenBucket Algorithm = "token_bucket"
+	// SlidingWindowCounter weights the previous window count by its overlap
+	// with the sliding window and adds the current window count
+	SlidingWindowCounter Algorithm = "sliding_window_counter"
+	// SlidingWindowLog records the timestamp of every allowed request and
+	// counts those within the last Window
+	SlidingWindowLog Algorithm = "sliding_window_log"
+	// GCRA spaces requests Window/Limit apart with a tolerance of Burst
+	// requests, storing only the theoretical arrival time of the next request
+	GCRA Algorithm = "gcra"
+)
+
+// Request describes a rate limit evaluation for a key
+type Request struct {
+	// Algorithm selects the rate limiting algorithm, FixedWindow if empty
+	Algorithm Algorithm
+	// Key identifies the limited subject, e.g. "ip:192.168.1.1"
+	Key string
+	// Limit is the number of requests allowed per Window
+	Limit int64
+	// Window is the period the limit applies to
+	Window time.Duration
+	// Burst is the token bucket capacity or the GCRA burst tolerance,
+	// Limit if zero
+	Burst int64
+	// BlockDuration is how long the key is blocked once the limit is
+	// exceeded, no block is applied if zero
+	BlockDuration time.Duration
+	// Cost is the number of units the request consumes, 1 if zero
+	Cost int64
+}
+
+// Result holds the outcome of an atomic rate limit evaluation
+type Result struct {
+	// Allowed reports whether the request fits within the limit
+	Allowed bool
+	// Blocked reports whether the key is under a block
+	Blocked bool
+	// Remaining is the number of units still available
+	Remaining int64
+	// ResetAfter is the time until the limit is fully available again
+	ResetAfter time.Duration
+	// RetryAfter is the time until a request may be allowed again, zero
+	// when the request was allowed
+	RetryAfter time.Duration
+}
+
+// Evaluator is implemented by storages that can check the block, apply the
+// algorithm and block the key in a single atomic operation
+type Evaluator interface {
+	// Evaluate counts a request against the limit described by req and
+	// blocks the key for req.BlockDuration once the limit is exceeded
+	Evaluate(ctx context.Context, req Request) (Result, error)
+}
+
+// ErrUnsupportedAlgorithm is returned when a storage cannot evaluate an algorithm
+var ErrUnsupportedAlgorithm = errors.New("unsupported rate limit algorithm")
+
+// burst returns the token bucket capacity or GCRA burst tolerance for a request
+func burst(req Request) int64 {
+	if req.Burst > 0 {
+		return req.Burst
+	}
+	return req.Limit
+}
+
+// cost returns the number of units consumed by a request
+func cost(req Request) int64 {
+	if req.Cost > 0 {
+		return req.Cost
+	}
+	return 1
+}
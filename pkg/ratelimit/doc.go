@@ -0,0 +1,24 @@
+// Package ratelimit is the public API of the rate limiter: a limiter
+// applying per IP and per token limits, the storages keeping its counters
+// and an HTTP middleware enforcing it.
+//
+//	store, err := ratelimit.NewRedisStorage("localhost:6379")
+//	if err != nil {
+//		return err
+//	}
+//	defer store.Close()
+//
+//	limiter := ratelimit.New(store,
+//		ratelimit.WithIPLimit(ratelimit.Limit{Limit: 10, BlockDuration: time.Minute}),
+//		ratelimit.WithTokenLimit("premium", ratelimit.Limit{Limit: 1000, BlockDuration: time.Minute}),
+//	)
+//	handler := ratelimit.Middleware(limiter, ratelimit.WithFailOpen())(mux)
+//
+// The package follows semantic versioning, see Version: the exported
+// identifiers are only removed or changed incompatibly in a new major
+// version. The packages under internal/ it is built on are not part of the
+// API.
+package ratelimit
+
+// Version is the semantic version of the API of the package
+const Version = "1.0.0"
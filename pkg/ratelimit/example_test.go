@@ -0,0 +1,57 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/allis/rate-limiter/pkg/ratelimit"
+)
+
+func ExampleNew() {
+	store := ratelimit.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := ratelimit.New(store,
+		ratelimit.WithIPLimit(ratelimit.Limit{Limit: 2, Period: time.Minute, BlockDuration: time.Minute}),
+	)
+
+	for i := 0; i < 3; i++ {
+		decision, err := limiter.CheckIP(context.Background(), "10.0.0.1")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(decision.Allowed, decision.Remaining)
+	}
+	// Output:
+	// true 1
+	// true 0
+	// false 0
+}
+
+func ExampleMiddleware() {
+	store := ratelimit.NewMemoryStorage()
+	defer store.Close()
+
+	limiter := ratelimit.New(store,
+		ratelimit.WithTokenLimit("premium", ratelimit.Limit{Limit: 1, BlockDuration: time.Minute}),
+	)
+	handler := ratelimit.Middleware(limiter,
+		ratelimit.WithResponder(ratelimit.JSONResponder()),
+		ratelimit.WithBypass("GET /health"),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, path := range []string{"/", "/", "/health"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("API_KEY", "premium")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		fmt.Println(path, rec.Code)
+	}
+	// Output:
+	// / 200
+	// / 429
+	// /health 200
+}
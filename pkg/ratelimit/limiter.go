@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/limiter"
+)
+
+// Limiter applies the limits of its configuration to IPs and tokens. It is
+// safe for concurrent use, and its configuration can be replaced while
+// serving with SetConfig.
+type Limiter = limiter.RateLimiter
+
+// Config holds every limit of a Limiter
+type Config = limiter.Config
+
+// Limit is a limit per period with its block duration, algorithm and
+// additional windows
+type Limit = limiter.TokenConfig
+
+// Window is an additional limit over a period of time, evaluated together
+// with the Limit it belongs to
+type Window = limiter.Window
+
+// Decision holds the outcome of a rate limit check
+type Decision = limiter.Decision
+
+// Strategy applies a rate limiting algorithm to a key, see WithStrategy
+type Strategy = limiter.Strategy
+
+// Algorithm identifies a rate limiting algorithm
+type Algorithm = limiter.Algorithm
+
+// Supported rate limiting algorithms
+const (
+	FixedWindow          = limiter.FixedWindow
+	TokenBucket          = limiter.TokenBucket
+	SlidingWindowCounter = limiter.SlidingWindowCounter
+	SlidingWindowLog     = limiter.SlidingWindowLog
+	GCRA                 = limiter.GCRA
+)
+
+// Rule names reported in a Decision
+const (
+	RuleIP           = limiter.RuleIP
+	RuleToken        = limiter.RuleToken
+	RuleDefaultToken = limiter.RuleDefaultToken
+)
+
+// ErrTimeout is returned when a rate limit check does not complete before
+// the deadline of its context
+var ErrTimeout = limiter.ErrTimeout
+
+// ParseAlgorithm parses an algorithm name, returning FixedWindow for an
+// empty name
+func ParseAlgorithm(name string) (Algorithm, error) {
+	return limiter.ParseAlgorithm(name)
+}
+
+// RouteKeyPrefix returns the Config.KeyPrefix of the limiter of a named
+// route, keeping its counters apart from the other limiters
+func RouteKeyPrefix(name string) string {
+	return limiter.RouteKeyPrefix(name)
+}
+
+// DefaultConfig returns the limits of a Limiter created without options:
+// 10 req/s per IP and 100 req/s per token, both blocking for 5 minutes
+func DefaultConfig() Config {
+	return Config{
+		IPLimit:                   10,
+		IPBlockDuration:           5 * time.Minute,
+		IPAlgorithm:               FixedWindow,
+		DefaultTokenLimit:         100,
+		DefaultTokenBlockDuration: 5 * time.Minute,
+		DefaultTokenAlgorithm:     FixedWindow,
+		TokenLimits:               make(map[string]Limit),
+	}
+}
+
+// Option configures a Limiter
+type Option func(*limiterOptions)
+
+// limiterOptions holds the settings of a Limiter
+type limiterOptions struct {
+	config     Config
+	strategies map[Algorithm]Strategy
+}
+
+// WithConfig replaces the whole configuration, e.g. loaded from a file.
+// Options applied after it change the configuration further.
+func WithConfig(config Config) Option {
+	return func(o *limiterOptions) {
+		o.config = config
+	}
+}
+
+// WithIPLimit sets the limit of the requests without a token, per client IP
+func WithIPLimit(limit Limit) Option {
+	return func(o *limiterOptions) {
+		o.config.IPLimit = limit.Limit
+		o.config.IPPeriod = limit.Period
+		o.config.IPBlockDuration = limit.BlockDuration
+		o.config.IPAlgorithm = limit.Algorithm
+		o.config.IPBurst = limit.Burst
+		o.config.IPWindows = limit.Windows
+	}
+}
+
+// WithDefaultTokenLimit sets the limit of the tokens without their own limit
+func WithDefaultTokenLimit(limit Limit) Option {
+	return func(o *limiterOptions) {
+		o.config.DefaultTokenLimit = limit.Limit
+		o.config.DefaultTokenPeriod = limit.Period
+		o.config.DefaultTokenBlockDuration = limit.BlockDuration
+		o.config.DefaultTokenAlgorithm = limit.Algorithm
+		o.config.DefaultTokenBurst = limit.Burst
+		o.config.DefaultTokenWindows = limit.Windows
+	}
+}
+
+// WithTokenLimit sets the limit of a token, replacing the default token
+// limit for its requests
+func WithTokenLimit(token string, limit Limit) Option {
+	return func(o *limiterOptions) {
+		// The map may be shared with the Config passed to WithConfig
+		tokenLimits := maps.Clone(o.config.TokenLimits)
+		if tokenLimits == nil {
+			tokenLimits = make(map[string]Limit)
+		}
+		tokenLimits[token] = limit
+		o.config.TokenLimits = tokenLimits
+	}
+}
+
+// WithKeyPrefix prepends prefix to the storage keys, keeping apart the
+// counters of limiters sharing a storage
+func WithKeyPrefix(prefix string) Option {
+	return func(o *limiterOptions) {
+		o.config.KeyPrefix = prefix
+	}
+}
+
+// WithLogger has no effect: storage failures are returned by the checks
+// and logged once by the middleware, see WithMiddlewareLogger.
+//
+// Deprecated: Log the errors returned by the checks instead.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *limiterOptions) {}
+}
+
+// WithStrategy applies strategy to the limits using algorithm, replacing
+// the built-in implementation, if any
+func WithStrategy(algorithm Algorithm, strategy Strategy) Option {
+	return func(o *limiterOptions) {
+		if o.strategies == nil {
+			o.strategies = make(map[Algorithm]Strategy)
+		}
+		o.strategies[algorithm] = strategy
+	}
+}
+
+// New creates a Limiter keeping its counters and blocks in store, with the
+// limits of DefaultConfig changed by opts
+func New(store Storage, opts ...Option) *Limiter {
+	o := limiterOptions{config: DefaultConfig()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	l := limiter.NewRateLimiter(store, o.config)
+	for algorithm, strategy := range o.strategies {
+		l.RegisterStrategy(algorithm, strategy)
+	}
+	return l
+}
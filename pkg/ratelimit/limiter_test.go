@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fixedStrategy always returns its result
+type fixedStrategy struct {
+	result Result
+}
+
+func (s fixedStrategy) Allow(ctx context.Context, key string, window Window, cost int) (Result, error) {
+	return s.result, nil
+}
+
+func TestNew_Options(t *testing.T) {
+	// Test: Without options the default limits apply
+	l := New(NewMemoryStorage(WithCleanupInterval(0)))
+	if config := l.Config(); config.IPLimit != 10 || config.DefaultTokenLimit != 100 {
+		t.Errorf("Expected the default limits, got %+v", config)
+	}
+
+	// Test: Token limits do not change the configuration passed to WithConfig
+	config := Config{IPLimit: 1, TokenLimits: map[string]Limit{"basic": {Limit: 5}}}
+	l = New(NewMemoryStorage(WithCleanupInterval(0)),
+		WithConfig(config),
+		WithTokenLimit("premium", Limit{Limit: 1000, Period: time.Minute}),
+		WithDefaultTokenLimit(Limit{Limit: 50, Algorithm: GCRA}),
+		WithKeyPrefix("api:"),
+	)
+	got := l.Config()
+	if len(config.TokenLimits) != 1 || len(got.TokenLimits) != 2 || got.TokenLimits["premium"].Period != time.Minute {
+		t.Errorf("Expected the premium limit added to a copy, got %+v and %+v", config.TokenLimits, got.TokenLimits)
+	}
+	if got.IPLimit != 1 || got.DefaultTokenLimit != 50 || got.DefaultTokenAlgorithm != GCRA || got.IPKey("x") != "api:ip:x" {
+		t.Errorf("Expected the options applied to the configuration, got %+v", got)
+	}
+}
+
+func TestNew_Strategy(t *testing.T) {
+	l := New(NewMemoryStorage(WithCleanupInterval(0)),
+		WithIPLimit(Limit{Limit: 1, Algorithm: "custom"}),
+		WithStrategy("custom", fixedStrategy{result: Result{Allowed: true, Remaining: 42}}),
+	)
+
+	decision, err := l.CheckIP(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("CheckIP failed: %v", err)
+	}
+	if !decision.Allowed || decision.Remaining != 42 || decision.Rule != RuleIP {
+		t.Errorf("Expected the decision of the custom strategy, got %+v", decision)
+	}
+}
@@ -0,0 +1,246 @@
+package ratelimit
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/allis/rate-limiter/internal/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MiddlewareOption configures the middleware created by Middleware
+type MiddlewareOption = middleware.Option
+
+// Middleware creates an HTTP middleware limiting the requests with limiter:
+// by the token of the API_KEY header if present, by client IP otherwise.
+// Rejected requests get 429 Too Many Requests. It panics on invalid or
+// conflicting route and bypass patterns, see CheckPatterns.
+func Middleware(limiter *Limiter, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	return middleware.RateLimiterMiddleware(limiter, opts...)
+}
+
+// KeyType returns the kind of key the requests are limited by, RuleToken
+// with an API_KEY header and RuleIP otherwise
+func KeyType(r *http.Request) string {
+	return middleware.KeyType(r)
+}
+
+// RoutePattern returns the pattern of the route whose limits the middleware
+// applied to a request, or an empty string for the default limits
+func RoutePattern(r *http.Request) string {
+	return middleware.RoutePattern(r)
+}
+
+// Outcome is the result of the rate limit check of a request
+type Outcome = middleware.Outcome
+
+// Rate limit check outcomes
+const (
+	OutcomeAllowed    = middleware.OutcomeAllowed
+	OutcomeRejected   = middleware.OutcomeRejected
+	OutcomeError      = middleware.OutcomeError
+	OutcomeTimeout    = middleware.OutcomeTimeout
+	OutcomeCanceled   = middleware.OutcomeCanceled
+	OutcomeNoClientIP = middleware.OutcomeNoClientIP
+)
+
+// Observer is called with the outcome of the rate limit check of every
+// request, see WithObserver
+type Observer = middleware.Observer
+
+// WithLegacyHeaders also emits the X-RateLimit-Limit, X-RateLimit-Remaining
+// and X-RateLimit-Reset headers
+func WithLegacyHeaders() MiddlewareOption {
+	return middleware.WithLegacyHeaders()
+}
+
+// WithResponder sets the responder that writes rejected requests, plain
+// text if not set
+func WithResponder(responder Responder) MiddlewareOption {
+	return middleware.WithResponder(responder)
+}
+
+// WithTrustedProxies sets the proxies whose forwarding headers are trusted.
+// Without trusted proxies the client IP is always the connection address.
+func WithTrustedProxies(prefixes []netip.Prefix) MiddlewareOption {
+	return middleware.WithTrustedProxies(prefixes)
+}
+
+// WithIPHeaders sets the headers read from trusted proxies, in order of
+// precedence. Defaults to DefaultIPHeaders.
+func WithIPHeaders(headers []string) MiddlewareOption {
+	return middleware.WithIPHeaders(headers)
+}
+
+// WithFailOpen lets requests through when the check fails, e.g. because
+// the storage is unavailable, instead of responding 500 Internal Server
+// Error
+func WithFailOpen() MiddlewareOption {
+	return middleware.WithFailOpen()
+}
+
+// WithTimeout bounds each check to timeout. Checks that time out respond
+// 503 Service Unavailable, or let the request through with WithFailOpen.
+func WithTimeout(timeout time.Duration) MiddlewareOption {
+	return middleware.WithTimeout(timeout)
+}
+
+// WithObserver sets a function called with the outcome of every check
+func WithObserver(observer Observer) MiddlewareOption {
+	return middleware.WithObserver(observer)
+}
+
+// WithCost sets the function resolving the number of units each request
+// consumes, e.g. RouteCosts. Every request costs 1 unit if not set.
+func WithCost(cost CostFunc) MiddlewareOption {
+	return middleware.WithCost(cost)
+}
+
+// WithCostHeader lets handlers raise the cost of a request by setting the
+// named response header, e.g. DefaultCostHeader. The units above the cost
+// already checked are charged once the handler returns.
+func WithCostHeader(name string) MiddlewareOption {
+	return middleware.WithCostHeader(name)
+}
+
+// WithRoutes applies the limiter of the most specific matching route
+// instead of the one passed to Middleware
+func WithRoutes(routes ...Route) MiddlewareOption {
+	return middleware.WithRoutes(routes...)
+}
+
+// WithBypass excludes the requests matching any of the http.ServeMux
+// patterns from rate limiting, e.g. "GET /health"
+func WithBypass(patterns ...string) MiddlewareOption {
+	return middleware.WithBypass(patterns...)
+}
+
+// WithRouteTable applies the routes and bypassed patterns of a table that
+// can be updated while serving, instead of WithRoutes and WithBypass
+func WithRouteTable(table *RouteTable) MiddlewareOption {
+	return middleware.WithRouteTable(table)
+}
+
+// WithMiddlewareLogger sets the logger every rejected request and failed
+// check is written to, slog.Default() if not set
+func WithMiddlewareLogger(logger *slog.Logger) MiddlewareOption {
+	return middleware.WithLogger(logger)
+}
+
+// WithTracerProvider sets the provider of the "ratelimit.check" span
+// created for every check, otel.GetTracerProvider() if not set
+func WithTracerProvider(provider trace.TracerProvider) MiddlewareOption {
+	return middleware.WithTracerProvider(provider)
+}
+
+// Client IP headers
+const (
+	HeaderForwarded     = middleware.HeaderForwarded
+	HeaderXForwardedFor = middleware.HeaderXForwardedFor
+	HeaderXRealIP       = middleware.HeaderXRealIP
+)
+
+// DefaultIPHeaders returns the default precedence of the client IP headers
+func DefaultIPHeaders() []string {
+	return append([]string(nil), middleware.DefaultIPHeaders...)
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or single IPs
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	return middleware.ParseTrustedProxies(s)
+}
+
+// Route is a rate limit rule for the requests matching an http.ServeMux
+// pattern
+type Route = middleware.Route
+
+// RouteTable holds routes and bypassed patterns that can be replaced while
+// serving
+type RouteTable = middleware.RouteTable
+
+// NewRouteTable creates a route table, returning an error for invalid or
+// conflicting patterns
+func NewRouteTable(routes []Route, bypass []string) (*RouteTable, error) {
+	return middleware.NewRouteTable(routes, bypass)
+}
+
+// CheckPatterns reports invalid or conflicting route and bypass patterns
+func CheckPatterns(patterns ...string) error {
+	return middleware.CheckPatterns(patterns...)
+}
+
+// DefaultCostHeader is the response header handlers set to raise the cost
+// of a request with WithCostHeader
+const DefaultCostHeader = middleware.DefaultCostHeader
+
+// CostFunc returns the number of units of the limit a request consumes
+type CostFunc = middleware.CostFunc
+
+// RouteCost is the cost of the requests matching a method and a path prefix
+type RouteCost = middleware.RouteCost
+
+// RouteCosts returns a CostFunc using the rule with the longest matching
+// path. Requests matching no rule cost 1 unit.
+func RouteCosts(rules []RouteCost) CostFunc {
+	return middleware.RouteCosts(rules)
+}
+
+// ParseRouteCosts parses a comma-separated list of [METHOD ]PATH=COST
+// rules, e.g. "POST /export=50, /search=5"
+func ParseRouteCosts(s string) ([]RouteCost, error) {
+	return middleware.ParseRouteCosts(s)
+}
+
+// Responder writes the response for a request rejected by the middleware
+type Responder = middleware.Responder
+
+// ResponderFunc adapts a function to the Responder interface
+type ResponderFunc = middleware.ResponderFunc
+
+// ResponseFormat names a built-in rejection response format
+type ResponseFormat = middleware.ResponseFormat
+
+// Supported response formats
+const (
+	FormatPlain     = middleware.FormatPlain
+	FormatJSON      = middleware.FormatJSON
+	FormatProblem   = middleware.FormatProblem
+	FormatHTML      = middleware.FormatHTML
+	FormatNegotiate = middleware.FormatNegotiate
+)
+
+// PlainResponder writes message as text/plain
+func PlainResponder(message string) Responder {
+	return middleware.PlainResponder(message)
+}
+
+// JSONResponder writes the limit and retry delay as application/json
+func JSONResponder() Responder {
+	return middleware.JSONResponder()
+}
+
+// ProblemResponder writes an RFC 9457 application/problem+json document of
+// type typeURI, about:blank if empty
+func ProblemResponder(typeURI string) Responder {
+	return middleware.ProblemResponder(typeURI)
+}
+
+// HTMLResponder renders tmpl as text/html, or a default page if tmpl is nil
+func HTMLResponder(tmpl *template.Template) Responder {
+	return middleware.HTMLResponder(tmpl)
+}
+
+// NegotiatingResponder picks the responder of the media type preferred by
+// the Accept header of the request, fallback if none is acceptable
+func NegotiatingResponder(responders map[string]Responder, fallback Responder) Responder {
+	return middleware.NegotiatingResponder(responders, fallback)
+}
+
+// NewResponder creates the responder of a built-in format. htmlTemplate
+// and problemType are optional.
+func NewResponder(format ResponseFormat, htmlTemplate *template.Template, problemType string) (Responder, error) {
+	return middleware.NewResponder(format, htmlTemplate, problemType)
+}
@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/allis/rate-limiter/internal/storage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Storage keeps the counters, algorithm state and blocks of a Limiter
+type Storage = storage.Storage
+
+// Evaluator is implemented by storages that apply every algorithm in a
+// single atomic operation. Storages that do not only support FixedWindow.
+type Evaluator = storage.Evaluator
+
+// Request describes a rate limit evaluation for a key
+type Request = storage.Request
+
+// Result holds the outcome of a rate limit evaluation
+type Result = storage.Result
+
+// RedisStorage is a Storage shared by every instance connected to the same
+// Redis server
+type RedisStorage = storage.RedisStorage
+
+// MemoryStorage is a Storage local to a single instance
+type MemoryStorage = storage.MemoryStorage
+
+// CircuitBreaker stops calling a failing storage, see
+// NewCircuitBreakerStorage
+type CircuitBreaker = storage.CircuitBreaker
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState = storage.BreakerState
+
+// Circuit breaker states
+const (
+	BreakerClosed   = storage.BreakerClosed
+	BreakerOpen     = storage.BreakerOpen
+	BreakerHalfOpen = storage.BreakerHalfOpen
+)
+
+// ErrCircuitOpen is returned without calling the storage while the circuit
+// breaker is open
+var ErrCircuitOpen = storage.ErrCircuitOpen
+
+// ErrUnsupportedAlgorithm is returned when a storage cannot evaluate an
+// algorithm
+var ErrUnsupportedAlgorithm = storage.ErrUnsupportedAlgorithm
+
+// RedisOption configures a RedisStorage
+type RedisOption func(*redisOptions)
+
+// redisOptions holds the settings of a RedisStorage
+type redisOptions struct {
+	password string
+	db       int
+	storage  []storage.RedisOption
+}
+
+// WithRedisPassword sets the password of the Redis server
+func WithRedisPassword(password string) RedisOption {
+	return func(o *redisOptions) {
+		o.password = password
+	}
+}
+
+// WithRedisDB selects the Redis database, 0 if not set
+func WithRedisDB(db int) RedisOption {
+	return func(o *redisOptions) {
+		o.db = db
+	}
+}
+
+// WithRedisTracing creates a span with provider for every Redis command
+// called within a span, e.g. the one of WithTracerProvider
+func WithRedisTracing(provider trace.TracerProvider) RedisOption {
+	return func(o *redisOptions) {
+		o.storage = append(o.storage, storage.WithRedisTracing(provider))
+	}
+}
+
+// NewRedisStorage connects to the Redis server at addr, returning an error
+// if it does not respond within 5 seconds
+func NewRedisStorage(addr string, opts ...RedisOption) (*RedisStorage, error) {
+	var o redisOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return storage.NewRedisStorage(addr, o.password, o.db, o.storage...)
+}
+
+// MemoryOption configures a MemoryStorage
+type MemoryOption func(*memoryOptions)
+
+// memoryOptions holds the settings of a MemoryStorage
+type memoryOptions struct {
+	cleanupInterval time.Duration
+}
+
+// WithCleanupInterval sets how often the expired keys are evicted, every
+// minute if not set
+func WithCleanupInterval(interval time.Duration) MemoryOption {
+	return func(o *memoryOptions) {
+		o.cleanupInterval = interval
+	}
+}
+
+// NewMemoryStorage creates an in-memory storage. Close it to stop the
+// eviction of expired keys.
+func NewMemoryStorage(opts ...MemoryOption) *MemoryStorage {
+	o := memoryOptions{cleanupInterval: time.Minute}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return storage.NewMemoryStorage(o.cleanupInterval)
+}
+
+// NewCircuitBreaker creates a circuit breaker that opens after
+// failureThreshold consecutive failures for openTimeout
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	return storage.NewCircuitBreaker(failureThreshold, openTimeout)
+}
+
+// NewCircuitBreakerStorage wraps store so its calls fail fast with
+// ErrCircuitOpen while breaker is open
+func NewCircuitBreakerStorage(store Storage, breaker *CircuitBreaker) Storage {
+	return storage.NewCircuitBreakerStorage(store, breaker)
+}
+
+// NewFailoverStorage wraps primary so its calls are served by fallback
+// while it fails, e.g. limiting requests in memory while Redis is down
+func NewFailoverStorage(primary, fallback Storage) Storage {
+	return storage.NewFailoverStorage(primary, fallback)
+}
+
+// StorageObserver is called with the operation name, duration and error of
+// every storage call, see NewInstrumentedStorage
+type StorageObserver = storage.Observe
+
+// NewInstrumentedStorage wraps store reporting every call to observe, e.g.
+// to record its latency
+func NewInstrumentedStorage(store Storage, observe StorageObserver) Storage {
+	return storage.NewInstrumentedStorage(store, observe)
+}